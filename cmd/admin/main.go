@@ -0,0 +1,263 @@
+// Command admin does operational tasks without the web ui:
+//
+//	admin [-json] user list
+//	admin [-json] user create -email E [-first-name F] [-last-name L] [-level owner] [-password-stdin]
+//	admin [-json] user reset-password -email E [-password-stdin]
+//	admin [-json] user set-level -email E -level L
+//	admin [-json] rooms list
+//	admin [-json] rooms add -name N
+//	admin [-json] block list [-from D] [-to D]
+//	admin [-json] block add -room ID -from D -to D
+//	admin [-json] block remove -id ID
+//	admin [-json] reservations list [-from D] [-to D]
+//	admin [-json] export -from D -to D
+//
+// Dates are YYYY-MM-DD. Changes are audited as made by the operator running
+// the command. It connects to DATABASE_URL, or the development database when
+// it's not set
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/signal"
+	"os/user"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/driver"
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/repository"
+	"github.com/acceleraterA/go_app_udemy/internal/repository/dbrepo"
+)
+
+const usage = `usage: admin [-json] <command>
+
+commands:
+  user list | create | reset-password | set-level
+  rooms list | add
+  block list | add | remove
+  reservations list
+  export
+
+run admin <command> -h for the flags of a command`
+
+// dateLayout is how dates are given and printed
+const dateLayout = "2006-01-02"
+
+// errUsage is returned for a command line that can't be run, the usage was
+// already printed
+var errUsage = errors.New("invalid command line")
+
+// cli runs one command against the repository
+type cli struct {
+	db     repository.DatabaseRepo
+	out    io.Writer
+	errOut io.Writer
+	in     io.Reader
+	json   bool
+	actor  models.Actor
+	now    func() time.Time
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fs := flag.NewFlagSet("admin", flag.ContinueOnError)
+	fs.Usage = func() { fmt.Fprintln(os.Stderr, usage) }
+	asJSON := fs.Bool("json", false, "print JSON instead of a table")
+	if err := fs.Parse(os.Args[1:]); err != nil {
+		os.Exit(2)
+	}
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	db, err := driver.ConnectSQL(driver.DSN())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin: cannot connect to database:", err)
+		os.Exit(1)
+	}
+	defer db.SQL.Close()
+	app := config.AppConfig{Logger: logging.New(os.Stderr, false, slog.LevelWarn)}
+
+	c := &cli{
+		db:     dbrepo.NewPostgresRepo(db.SQL, &app),
+		out:    os.Stdout,
+		errOut: os.Stderr,
+		in:     os.Stdin,
+		json:   *asJSON,
+		actor:  models.Actor{Type: models.ActorOperator, Label: operator()},
+		now:    time.Now,
+	}
+	err = c.run(ctx, fs.Args())
+	if errors.Is(err, errUsage) {
+		os.Exit(2)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "admin:", err)
+		os.Exit(1)
+	}
+}
+
+// operator returns the login of whoever runs the command, for the audit log
+func operator() string {
+	if u, err := user.Current(); err == nil && u.Username != "" {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
+
+// run runs the command in args
+func (c *cli) run(ctx context.Context, args []string) error {
+	if len(args) == 0 {
+		return c.usageError(usage)
+	}
+	sub := ""
+	if len(args) > 1 {
+		sub = args[1]
+	}
+	switch args[0] + " " + sub {
+	case "user list":
+		return c.userList(ctx, args[2:])
+	case "user create":
+		return c.userCreate(ctx, args[2:])
+	case "user reset-password":
+		return c.userResetPassword(ctx, args[2:])
+	case "user set-level":
+		return c.userSetLevel(ctx, args[2:])
+	case "rooms list":
+		return c.roomsList(ctx, args[2:])
+	case "rooms add":
+		return c.roomsAdd(ctx, args[2:])
+	case "block list":
+		return c.blockList(ctx, args[2:])
+	case "block add":
+		return c.blockAdd(ctx, args[2:])
+	case "block remove":
+		return c.blockRemove(ctx, args[2:])
+	case "reservations list":
+		return c.reservationsList(ctx, args[2:])
+	}
+	if args[0] == "export" {
+		return c.export(ctx, args[1:])
+	}
+	return c.usageError(usage)
+}
+
+func (c *cli) usageError(text string) error {
+	fmt.Fprintln(c.errOut, text)
+	return errUsage
+}
+
+// flags returns the flag set of a command, errors go to errOut
+func (c *cli) flags(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(c.errOut)
+	return fs
+}
+
+// parse parses the flags of a command, it fails on extra arguments
+func (c *cli) parse(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(c.errOut, "%s: unexpected argument %q\n", fs.Name(), fs.Arg(0))
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// check returns the errors of a validated form as one error
+func check(form *forms.Form) error {
+	if form.Valid() {
+		return nil
+	}
+	var msgs []string
+	for field, errs := range form.Errors {
+		msgs = append(msgs, "-"+strings.ReplaceAll(field, "_", "-")+": "+strings.Join(errs, ", "))
+	}
+	sort.Strings(msgs)
+	return errors.New(strings.Join(msgs, "; "))
+}
+
+// print writes v as JSON with -json, and the rows under header as an
+// aligned table otherwise
+func (c *cli) print(v interface{}, header []string, rows [][]string) error {
+	if c.json {
+		enc := json.NewEncoder(c.out)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	w := tabwriter.NewWriter(c.out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+	for _, row := range rows {
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	return w.Flush()
+}
+
+// today returns the date of today, at midnight UTC like dates from the database
+func (c *cli) today() time.Time {
+	y, m, d := c.now().Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+// dateFlag is a YYYY-MM-DD flag
+type dateFlag struct {
+	t *time.Time
+}
+
+func (d dateFlag) String() string {
+	if d.t == nil || d.t.IsZero() {
+		return ""
+	}
+	return d.t.Format(dateLayout)
+}
+
+func (d dateFlag) Set(s string) error {
+	t, err := time.Parse(dateLayout, s)
+	if err != nil {
+		return errors.New("use the format YYYY-MM-DD")
+	}
+	*d.t = t
+	return nil
+}
+
+// parseLevel accepts a role name, guest or an access level number
+func parseLevel(s string) (int, error) {
+	for level, name := range models.Roles {
+		if name == s {
+			return level, nil
+		}
+	}
+	if s == "guest" {
+		return models.AccessLevelGuest, nil
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		if _, ok := models.Roles[n]; ok || n == models.AccessLevelGuest {
+			return n, nil
+		}
+	}
+	return 0, fmt.Errorf("level %q: use front-desk, manager, owner or guest", s)
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/repository/dbrepo"
+)
+
+// newTestCLI returns a cli on the test repository, today is 2050-01-01
+func newTestCLI(asJSON bool, stdin string) (*cli, *bytes.Buffer) {
+	app := config.AppConfig{Logger: logging.New(os.Stdout, false, slog.LevelInfo)}
+	var out bytes.Buffer
+	return &cli{
+		db:     dbrepo.NewTestingRepo(&app),
+		out:    &out,
+		errOut: &bytes.Buffer{},
+		in:     strings.NewReader(stdin),
+		json:   asJSON,
+		actor:  models.Actor{Type: models.ActorOperator, Label: "tester"},
+		now:    func() time.Time { return time.Date(2050, 1, 1, 9, 0, 0, 0, time.Local) },
+	}, &out
+}
+
+func TestCLI(t *testing.T) {
+	var tests = []struct {
+		name    string
+		args    []string
+		json    bool
+		stdin   string
+		want    []string
+		wantErr string
+	}{
+		{"no command", nil, false, "", nil, errUsage.Error()},
+		{"unknown command", []string{"rooms", "paint"}, false, "", nil, errUsage.Error()},
+		{"extra argument", []string{"rooms", "list", "now"}, false, "", nil, errUsage.Error()},
+		{"rooms list", []string{"rooms", "list"}, false, "", []string{"ID  NAME", "2   Major's Suite"}, ""},
+		{"rooms add", []string{"rooms", "add", "-name", "Colonel's Cabin"}, false, "", []string{"3   Colonel's Cabin"}, ""},
+		{"rooms add without name", []string{"rooms", "add"}, false, "", nil, "-name: This field cannot be blank"},
+		{"user list", []string{"user", "list"}, false, "", []string{"ROLE", "owner"}, ""},
+		{"user create", []string{"user", "create", "-email", "boss@here.com", "-first-name", "Jane"}, false, "",
+			[]string{"PASSWORD", "boss@here.com  Jane  owner"}, ""},
+		{"user create with a password", []string{"user", "create", "-email", "desk@here.com", "-level", "front-desk", "-password-stdin"},
+			false, "correct horse\n", []string{"front-desk"}, ""},
+		{"user create with a short password", []string{"user", "create", "-email", "desk@here.com", "-password-stdin"},
+			false, "short\n", nil, "at least 8 characters"},
+		{"user create with a bad email", []string{"user", "create", "-email", "nobody"}, false, "", nil, "-email: Invalid email address"},
+		{"user create taken", []string{"user", "create", "-email", "taken@here.com"}, false, "", nil, "can't create taken@here.com"},
+		{"user set-level", []string{"user", "set-level", "-email", "user@here.com", "-level", "manager"}, false, "", []string{"manager"}, ""},
+		{"user set-level bad level", []string{"user", "set-level", "-email", "user@here.com", "-level", "king"}, false, "", nil, `level "king"`},
+		{"user reset-password unknown", []string{"user", "reset-password", "-email", "who@here.com"}, false, "", nil, "no user who@here.com"},
+		{"block list", []string{"block", "list"}, false, "", []string{"7   2        Major's Suite  2050-01-01  2050-01-08"}, ""},
+		{"block add", []string{"block", "add", "-room", "1", "-from", "2049-06-01", "-to", "2049-06-05"}, false, "",
+			[]string{"1        ", "2049-06-01  2049-06-05"}, ""},
+		{"block add booked", []string{"block", "add", "-room", "1", "-from", "2050-06-01", "-to", "2050-06-05"}, false, "",
+			nil, "already booked or blocked"},
+		{"block add backwards", []string{"block", "add", "-room", "1", "-from", "2049-06-05", "-to", "2049-06-01"}, false, "",
+			nil, "-to must be after -from"},
+		{"block remove", []string{"block", "remove", "-id", "7"}, false, "", []string{"7"}, ""},
+		{"block remove unknown", []string{"block", "remove", "-id", "8"}, false, "", nil, "no owner block 8"},
+		{"reservations list", []string{"reservations", "list"}, false, "", []string{"John Smith", "2050-01-03"}, ""},
+		{"reservations list bad date", []string{"reservations", "list", "-from", "01/01/2050"}, false, "", nil, errUsage.Error()},
+		{"export csv", []string{"export", "-from", "2050-01-01", "-to", "2050-01-31"}, false, "",
+			[]string{"id,room_id,room,first_name", "1,1,General's Quarters,John,Smith,john@smith.com,,2050-01-01,2050-01-03,2,"}, ""},
+		{"export json", []string{"export", "-from", "2050-01-01", "-to", "2050-01-31"}, true, "",
+			[]string{`"nights":2`, `"room":"General's Quarters"`}, ""},
+		{"export without dates", []string{"export"}, false, "", nil, "-from and -to are required"},
+	}
+
+	for _, e := range tests {
+		c, out := newTestCLI(e.json, e.stdin)
+		err := c.run(context.Background(), e.args)
+		if e.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), e.wantErr) {
+				t.Errorf("%s: expected error %q, got %v", e.name, e.wantErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		for _, w := range e.want {
+			if !strings.Contains(out.String(), w) {
+				t.Errorf("%s: missing %q in:\n%s", e.name, w, out.String())
+			}
+		}
+	}
+}
+
+func TestCLI_JSON(t *testing.T) {
+	c, out := newTestCLI(true, "")
+	if err := c.run(context.Background(), []string{"reservations", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	var list []reservationRow
+	if err := json.Unmarshal(out.Bytes(), &list); err != nil {
+		t.Fatalf("not a JSON list: %s", out.String())
+	}
+	if len(list) != 1 || list[0].Email != "john@smith.com" || list[0].StartDate != "2050-01-01" {
+		t.Errorf("unexpected reservations %+v", list)
+	}
+
+	c, out = newTestCLI(true, "")
+	if err := c.run(context.Background(), []string{"user", "create", "-email", "boss@here.com"}); err != nil {
+		t.Fatal(err)
+	}
+	var u userRow
+	if err := json.Unmarshal(out.Bytes(), &u); err != nil {
+		t.Fatalf("not a JSON object: %s", out.String())
+	}
+	if u.ID != 5 || u.Role != "owner" || len(u.Password) < minPasswordLength {
+		t.Errorf("unexpected user %+v", u)
+	}
+
+	c, out = newTestCLI(true, "")
+	if err := c.run(context.Background(), []string{"rooms", "list"}); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), `"name": "General's Quarters"`) {
+		t.Errorf("unexpected rooms %s", out.String())
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for in, want := range map[string]int{
+		"owner":      models.AccessLevelOwner,
+		"front-desk": models.AccessLevelFrontDesk,
+		"guest":      models.AccessLevelGuest,
+		"2":          models.AccessLevelManager,
+	} {
+		got, err := parseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("%s: got %d, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "admin", "9"} {
+		if _, err := parseLevel(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
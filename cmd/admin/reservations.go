@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/export"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// reservationRow is a reservation as printed
+type reservationRow struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	Room      string `json:"room"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+func toReservationRow(r models.Reservation) reservationRow {
+	return reservationRow{
+		ID:        r.ID,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format(dateLayout),
+		EndDate:   r.EndDate.Format(dateLayout),
+		RoomID:    r.RoomID,
+		Room:      r.Room.RoomName,
+		Status:    r.Status,
+		CreatedAt: r.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// arrivals returns the reservations arriving from -from to -to, both inclusive
+func (c *cli) arrivals(ctx context.Context, from, to time.Time) ([]reservationRow, error) {
+	if to.Before(from) {
+		return nil, errors.New("-to is before -from")
+	}
+	reservations, err := c.db.ReservationsArriving(ctx, from, to)
+	if err != nil {
+		return nil, err
+	}
+	rows := []reservationRow{}
+	for _, r := range reservations {
+		rows = append(rows, toReservationRow(r))
+	}
+	return rows, nil
+}
+
+// reservationsList prints the reservations arriving from -from to -to, today's
+// arrivals by default
+func (c *cli) reservationsList(ctx context.Context, args []string) error {
+	fs := c.flags("reservations list")
+	from, to := c.today(), c.today()
+	fs.Var(dateFlag{&from}, "from", "first arrival day, today by default")
+	fs.Var(dateFlag{&to}, "to", "last arrival day, today by default")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	list, err := c.arrivals(ctx, from, to)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for _, r := range list {
+		rows = append(rows, []string{strconv.Itoa(r.ID), r.FirstName + " " + r.LastName, r.Email, r.Phone,
+			r.Room, r.StartDate, r.EndDate, r.Status})
+	}
+	return c.print(list, []string{"ID", "GUEST", "EMAIL", "PHONE", "ROOM", "ARRIVAL", "DEPARTURE", "STATUS"}, rows)
+}
+
+// export streams the reservations arriving from -from to -to as CSV, or as
+// JSON with -json, in the format of the admin download
+func (c *cli) export(ctx context.Context, args []string) error {
+	fs := c.flags("export")
+	var from, to time.Time
+	fs.Var(dateFlag{&from}, "from", "first arrival day")
+	fs.Var(dateFlag{&to}, "to", "last arrival day")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if from.IsZero() || to.IsZero() {
+		return errors.New("-from and -to are required")
+	}
+	if to.Before(from) {
+		return errors.New("-to is before -from")
+	}
+	format := export.CSV
+	if c.json {
+		format = export.JSON
+	}
+	w := export.NewWriter(c.out, format)
+	err := c.db.EachReservationArriving(ctx, from, to, func(r models.Reservation) error {
+		return w.Write(export.NewRow(r))
+	})
+	if err != nil {
+		return err
+	}
+	return w.Close()
+}
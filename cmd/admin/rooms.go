@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// roomRow is a room as printed
+type roomRow struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// blockRow is an owner block as printed, the end date is the day the room is
+// free again
+type blockRow struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	Room      string `json:"room"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+}
+
+func toBlockRow(b models.RoomRestriction) blockRow {
+	return blockRow{
+		ID:        b.ID,
+		RoomID:    b.RoomID,
+		Room:      b.Room.RoomName,
+		StartDate: b.StartDate.Format(dateLayout),
+		EndDate:   b.EndDate.Format(dateLayout),
+	}
+}
+
+func (c *cli) printBlocks(blocks []blockRow) error {
+	var rows [][]string
+	for _, b := range blocks {
+		rows = append(rows, []string{strconv.Itoa(b.ID), strconv.Itoa(b.RoomID), b.Room, b.StartDate, b.EndDate})
+	}
+	return c.print(blocks, []string{"ID", "ROOM ID", "ROOM", "FROM", "TO"}, rows)
+}
+
+// roomsList prints every room
+func (c *cli) roomsList(ctx context.Context, args []string) error {
+	fs := c.flags("rooms list")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	rooms, err := c.db.AllRooms(ctx)
+	if err != nil {
+		return err
+	}
+	list := []roomRow{}
+	var rows [][]string
+	for _, r := range rooms {
+		list = append(list, roomRow{ID: r.ID, Name: r.RoomName})
+		rows = append(rows, []string{strconv.Itoa(r.ID), r.RoomName})
+	}
+	return c.print(list, []string{"ID", "NAME"}, rows)
+}
+
+// roomsAdd adds a room, it has no page on the site until one is made for it
+func (c *cli) roomsAdd(ctx context.Context, args []string) error {
+	fs := c.flags("rooms add")
+	name := fs.String("name", "", "name of the room")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	form := forms.New(url.Values{"name": {*name}})
+	form.Required("name")
+	form.MinLength("name", 3)
+	if err := check(form); err != nil {
+		return err
+	}
+	id, err := c.db.InsertRoom(ctx, c.actor, models.Room{RoomName: *name})
+	if err != nil {
+		return err
+	}
+	return c.print(roomRow{ID: id, Name: *name}, []string{"ID", "NAME"}, [][]string{{strconv.Itoa(id), *name}})
+}
+
+// blockList prints the owner blocks overlapping -from to -to, the next 90
+// days by default
+func (c *cli) blockList(ctx context.Context, args []string) error {
+	fs := c.flags("block list")
+	from, to := c.today(), c.today().AddDate(0, 0, 90)
+	fs.Var(dateFlag{&from}, "from", "first day, today by default")
+	fs.Var(dateFlag{&to}, "to", "last day, in 90 days by default")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if to.Before(from) {
+		return errors.New("-to is before -from")
+	}
+	blocks, err := c.db.OwnerBlocks(ctx, from, to.AddDate(0, 0, 1))
+	if err != nil {
+		return err
+	}
+	rows := []blockRow{}
+	for _, b := range blocks {
+		rows = append(rows, toBlockRow(b))
+	}
+	return c.printBlocks(rows)
+}
+
+// blockAdd blocks a room for the owner from -from until -to, the day it is
+// free again, like a reservation's departure
+func (c *cli) blockAdd(ctx context.Context, args []string) error {
+	fs := c.flags("block add")
+	roomID := fs.Int("room", 0, "id of the room")
+	var from, to time.Time
+	fs.Var(dateFlag{&from}, "from", "first blocked day")
+	fs.Var(dateFlag{&to}, "to", "the day the room is free again")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	if from.IsZero() || to.IsZero() {
+		return errors.New("-from and -to are required")
+	}
+	if !to.After(from) {
+		return errors.New("-to must be after -from")
+	}
+	room, err := c.db.GetRoomByID(ctx, *roomID)
+	if err != nil {
+		return fmt.Errorf("no room %d", *roomID)
+	}
+	available, err := c.db.SearchAvailabilityByDatesByRoomID(ctx, from, to, *roomID)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return fmt.Errorf("room %d is already booked or blocked between %s and %s", *roomID,
+			from.Format(dateLayout), to.Format(dateLayout))
+	}
+
+	block := models.RoomRestriction{
+		StartDate:     from,
+		EndDate:       to,
+		RoomID:        *roomID,
+		RestrictionID: models.RestrictionOwnerBlock,
+		Room:          room,
+	}
+	if err := c.db.InsertRoomRestriction(ctx, c.actor, block); err != nil {
+		return err
+	}
+	row := toBlockRow(block)
+	return c.print(row, []string{"ROOM ID", "ROOM", "FROM", "TO"},
+		[][]string{{strconv.Itoa(row.RoomID), row.Room, row.StartDate, row.EndDate}})
+}
+
+// blockRemove removes an owner block, see block list for the ids
+func (c *cli) blockRemove(ctx context.Context, args []string) error {
+	fs := c.flags("block remove")
+	id := fs.Int("id", 0, "id of the block")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	err := c.db.DeleteOwnerBlock(ctx, c.actor, *id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("no owner block %d", *id)
+	}
+	if err != nil {
+		return err
+	}
+	return c.print(map[string]int{"removed": *id}, []string{"REMOVED"}, [][]string{{strconv.Itoa(*id)}})
+}
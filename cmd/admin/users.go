@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptCost and minPasswordLength are the ones of the web app
+const (
+	bcryptCost        = 12
+	minPasswordLength = 8
+)
+
+// userRow is a user as printed, without secrets
+type userRow struct {
+	ID            int    `json:"id"`
+	Email         string `json:"email"`
+	Name          string `json:"name"`
+	Role          string `json:"role"`
+	Active        bool   `json:"active"`
+	EmailVerified bool   `json:"email_verified"`
+	TwoFactor     bool   `json:"two_factor"`
+	// Password is only set when the command generated it
+	Password string `json:"password,omitempty"`
+}
+
+func toUserRow(u models.User) userRow {
+	return userRow{
+		ID:            u.ID,
+		Email:         u.Email,
+		Name:          strings.TrimSpace(u.Name()),
+		Role:          u.Role(),
+		Active:        u.Active,
+		EmailVerified: u.EmailVerified,
+		TwoFactor:     u.TOTPEnabled,
+	}
+}
+
+// printUsers prints users as a table or a JSON list
+func (c *cli) printUsers(users []userRow) error {
+	var rows [][]string
+	for _, u := range users {
+		rows = append(rows, []string{strconv.Itoa(u.ID), u.Email, u.Name, u.Role,
+			strconv.FormatBool(u.Active), strconv.FormatBool(u.TwoFactor)})
+	}
+	return c.print(users, []string{"ID", "EMAIL", "NAME", "ROLE", "ACTIVE", "2FA"}, rows)
+}
+
+// printUser prints the user a command changed, with the password when it was
+// generated
+func (c *cli) printUser(u userRow) error {
+	header := []string{"ID", "EMAIL", "NAME", "ROLE"}
+	row := []string{strconv.Itoa(u.ID), u.Email, u.Name, u.Role}
+	if u.Password != "" {
+		header = append(header, "PASSWORD")
+		row = append(row, u.Password)
+	}
+	return c.print(u, header, [][]string{row})
+}
+
+// userList prints every user
+func (c *cli) userList(ctx context.Context, args []string) error {
+	fs := c.flags("user list")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	users, err := c.db.AllUsers(ctx)
+	if err != nil {
+		return err
+	}
+	rows := []userRow{}
+	for _, u := range users {
+		rows = append(rows, toUserRow(u))
+	}
+	return c.printUsers(rows)
+}
+
+// userCreate creates an active, verified user, the first admin of a new site;
+// without -password-stdin a password is generated and printed once
+func (c *cli) userCreate(ctx context.Context, args []string) error {
+	fs := c.flags("user create")
+	email := fs.String("email", "", "email address, the login")
+	first := fs.String("first-name", "", "first name")
+	last := fs.String("last-name", "", "last name")
+	level := fs.String("level", "owner", "front-desk, manager, owner or guest")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+
+	form := forms.New(url.Values{"email": {*email}})
+	form.Required("email")
+	form.IsEmail("email")
+	if err := check(form); err != nil {
+		return err
+	}
+	accessLevel, err := parseLevel(*level)
+	if err != nil {
+		return err
+	}
+	password, generated, err := c.password(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return err
+	}
+
+	u := models.User{
+		FirstName:     *first,
+		LastName:      *last,
+		Email:         *email,
+		Password:      string(hash),
+		AccessLevel:   accessLevel,
+		Active:        true,
+		EmailVerified: true,
+	}
+	u.ID, err = c.db.InsertUser(ctx, c.actor, u)
+	if err != nil {
+		return fmt.Errorf("can't create %s: %w", *email, err)
+	}
+	row := toUserRow(u)
+	if generated {
+		row.Password = password
+	}
+	return c.printUser(row)
+}
+
+// userResetPassword sets a new password, which logs the user out everywhere
+func (c *cli) userResetPassword(ctx context.Context, args []string) error {
+	fs := c.flags("user reset-password")
+	email := fs.String("email", "", "email address of the user")
+	fromStdin := fs.Bool("password-stdin", false, "read the password from the first line of stdin")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	u, err := c.userByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	password, generated, err := c.password(*fromStdin)
+	if err != nil {
+		return err
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		return err
+	}
+	if err := c.db.UpdateUserPassword(ctx, c.actor, u.ID, string(hash)); err != nil {
+		return err
+	}
+	row := toUserRow(u)
+	if generated {
+		row.Password = password
+	}
+	return c.printUser(row)
+}
+
+// userSetLevel changes the role of a user
+func (c *cli) userSetLevel(ctx context.Context, args []string) error {
+	fs := c.flags("user set-level")
+	email := fs.String("email", "", "email address of the user")
+	level := fs.String("level", "", "front-desk, manager, owner or guest")
+	if err := c.parse(fs, args); err != nil {
+		return err
+	}
+	accessLevel, err := parseLevel(*level)
+	if err != nil {
+		return err
+	}
+	u, err := c.userByEmail(ctx, *email)
+	if err != nil {
+		return err
+	}
+	u.AccessLevel = accessLevel
+	if err := c.db.UpdateUser(ctx, c.actor, u); err != nil {
+		return err
+	}
+	return c.printUser(toUserRow(u))
+}
+
+func (c *cli) userByEmail(ctx context.Context, email string) (models.User, error) {
+	if email == "" {
+		return models.User{}, errors.New("-email: This field cannot be blank")
+	}
+	u, err := c.db.GetUserByEmail(ctx, email)
+	if err != nil {
+		return u, fmt.Errorf("no user %s", email)
+	}
+	return u, nil
+}
+
+// password reads the password from stdin, or generates one; generated
+// reports which
+func (c *cli) password(fromStdin bool) (password string, generated bool, err error) {
+	if !fromStdin {
+		b := make([]byte, 15)
+		if _, err := rand.Read(b); err != nil {
+			return "", false, err
+		}
+		return base64.RawURLEncoding.EncodeToString(b), true, nil
+	}
+	line, err := bufio.NewReader(c.in).ReadString('\n')
+	if err != nil && line == "" {
+		return "", false, errors.New("no password on stdin")
+	}
+	password = strings.TrimRight(line, "\r\n")
+	if len(password) < minPasswordLength {
+		return "", false, fmt.Errorf("the password must be at least %d characters long", minPasswordLength)
+	}
+	return password, false, nil
+}
@@ -0,0 +1,126 @@
+// Command migrate applies the embedded database migrations without soda:
+//
+//	migrate up        apply every pending migration
+//	migrate down [N]  roll back the last N migrations, 1 by default
+//	migrate status    list migrations and whether they are applied
+//	migrate seed      insert the rooms and restrictions the site needs
+//
+// It connects to DATABASE_URL, or the development database when it's not set
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/acceleraterA/go_app_udemy/internal/driver"
+	"github.com/acceleraterA/go_app_udemy/internal/migrate"
+	"github.com/acceleraterA/go_app_udemy/migrations"
+)
+
+const usage = `usage: migrate up | down [N] | status | seed`
+
+var errUsage = errors.New(usage)
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+// run parses the command before connecting, so a typo doesn't need a database
+func run(ctx context.Context, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errUsage
+	}
+	n := 1
+	switch args[0] {
+	case "up", "status", "seed":
+		if len(args) != 1 {
+			return errUsage
+		}
+	case "down":
+		if len(args) > 2 {
+			return errUsage
+		}
+		if len(args) == 2 {
+			var err error
+			n, err = strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("down: %q is not a positive number", args[1])
+			}
+		}
+	default:
+		return errUsage
+	}
+
+	db, err := driver.ConnectSQL(driver.DSN())
+	if err != nil {
+		return fmt.Errorf("cannot connect to database: %w", err)
+	}
+	defer db.SQL.Close()
+	m, err := migrate.New(db.SQL, migrations.FS)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "up":
+		done, err := m.Up(ctx)
+		if err != nil {
+			return err
+		}
+		report(out, "applied", done)
+	case "down":
+		done, err := m.Down(ctx, n)
+		if err != nil {
+			return err
+		}
+		report(out, "rolled back", done)
+	case "status":
+		statuses, err := m.Status(ctx)
+		if err != nil {
+			return err
+		}
+		printStatus(out, statuses)
+	case "seed":
+		if err := m.Seed(ctx, migrations.Seed); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "seeded")
+	}
+	return nil
+}
+
+func report(out io.Writer, verb string, done []migrate.Migration) {
+	if len(done) == 0 {
+		fmt.Fprintln(out, "nothing to do")
+		return
+	}
+	for _, mig := range done {
+		fmt.Fprintf(out, "%s %s_%s\n", verb, mig.Version, mig.Name)
+	}
+}
+
+func printStatus(out io.Writer, statuses []migrate.Status) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Version\tName\tStatus")
+	for _, s := range statuses {
+		status := "pending"
+		if s.Applied {
+			status = "applied"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.Version, s.Name, status)
+	}
+	w.Flush()
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"testing"
+)
+
+func TestRunUsage(t *testing.T) {
+	var tests = [][]string{
+		{},
+		{"sideways"},
+		{"up", "2"},
+		{"down", "0"},
+		{"down", "two"},
+		{"down", "1", "2"},
+	}
+
+	for _, args := range tests {
+		var out bytes.Buffer
+		if err := run(context.Background(), args, &out); err == nil {
+			t.Errorf("%q: expected an error", args)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/handlers"
+	"github.com/acceleraterA/go_app_udemy/internal/scheduler"
+)
+
+// defaultHousekeepingMailTime is when the housekeeping schedule is mailed
+// when HOUSEKEEPING_MAIL_TIME is not set
+const defaultHousekeepingMailTime = 7 * time.Hour
+
+// defaultGuestMailTime is when the guest emails are sent when
+// GUEST_MAIL_TIME is not set
+const defaultGuestMailTime = 10 * time.Hour
+
+// defaultPreArrivalDays is how many days before their arrival guests are
+// reminded when PRE_ARRIVAL_DAYS is not set
+const defaultPreArrivalDays = 3
+
+// parseTimeOfDay parses HH:MM into the time since midnight
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a time of day like 07:00", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// scheduleJobs returns the scheduler of the background jobs, the caller
+// starts it
+func scheduleJobs() *scheduler.Scheduler {
+	s := scheduler.New(app.Logger)
+	//days missed while the site was down are caught up on the next run, the
+	//guest_emails table keeps an email from going out twice
+	s.Add("guest emails", scheduler.Daily(guestMailTime), handlers.Repo.SendGuestEmails)
+	if app.HousekeepingEmail != "" {
+		s.Add("housekeeping schedule", scheduler.Daily(housekeepingMailTime), mailHousekeepingSchedule)
+	}
+	return s
+}
+
+// mailHousekeepingSchedule mails the housekeeping schedule of the day of now
+func mailHousekeepingSchedule(ctx context.Context, now time.Time) error {
+	y, m, d := now.Date()
+	day := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	if err := handlers.Repo.SendHousekeepingSchedule(ctx, day); err != nil {
+		return err
+	}
+	app.Logger.Info("housekeeping schedule mailed", "date", day.Format("2006-01-02"), "to", app.HousekeepingEmail)
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseTimeOfDay(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"07:00": 7 * time.Hour,
+		"6:30":  6*time.Hour + 30*time.Minute,
+		"00:00": 0,
+	} {
+		got, err := parseTimeOfDay(in)
+		if err != nil || got != want {
+			t.Errorf("%s: got %s, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "7", "25:00", "7am"} {
+		if _, err := parseTimeOfDay(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
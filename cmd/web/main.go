@@ -1,29 +1,68 @@
 package main
 
 import (
+	"context"
 	"encoding/gob"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
 	"net/http"
+	"net/url"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/acceleraterA/go_app_udemy/internal/botguard"
 	"github.com/acceleraterA/go_app_udemy/internal/config"
 	"github.com/acceleraterA/go_app_udemy/internal/driver"
+	"github.com/acceleraterA/go_app_udemy/internal/encryption"
 	"github.com/acceleraterA/go_app_udemy/internal/handlers"
+	"github.com/acceleraterA/go_app_udemy/internal/health"
 	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/loginguard"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/ratelimit"
 	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/secheaders"
+	"github.com/acceleraterA/go_app_udemy/internal/sessionstore"
+	"github.com/acceleraterA/go_app_udemy/internal/webhooks"
 
 	scs "github.com/alexedwards/scs/v2"
 )
 
 const portNumber = ":8080"
 
+// devEncryptionKey is only used outside production when ENCRYPTION_KEY is not set
+const devEncryptionKey = "0000000000000000000000000000000000000000000000000000000000000000"
+
+// devBaseURL is only used outside production when APP_BASE_URL is not set
+const devBaseURL = "http://localhost" + portNumber
+
+// defaultSessionCleanupInterval is how often expired sessions are deleted when SESSION_CLEANUP_INTERVAL is not set
+const defaultSessionCleanupInterval = 5 * time.Minute
+
+// mailQueueSize is how many emails can wait for the mail worker before
+// handlers block on sending
+const mailQueueSize = 100
+
+// errDatabaseUnavailable is returned by run when postgres can't be reached
+var errDatabaseUnavailable = errors.New("cannot connect to database")
+
 var app config.AppConfig
 var session *scs.SessionManager
-var infoLog log.Logger
-var ErrorLog log.Logger
+
+// housekeepingMailTime is when the housekeeping schedule is mailed each day,
+// as the time since midnight
+var housekeepingMailTime = defaultHousekeepingMailTime
+
+// guestMailTime is when the pre-arrival and post-stay emails are sent each
+// day, as the time since midnight
+var guestMailTime = defaultGuestMailTime
 
 func main() {
 	db, err := run()
@@ -32,19 +71,29 @@ func main() {
 	}
 	//close the database when the main(app) is stopped running
 	defer db.SQL.Close()
-	defer close(app.MailChan)
-	fmt.Println("starting mail listener...")
-	//start the function to listen for app.mailChan and send the msg
-	listenForMail()
-
-	fmt.Printf(fmt.Sprintf("Starting application on port %s", portNumber))
+	app.Logger.Info("starting mail listener")
+	//start the function to listen for app.mailChan and send the msg, the
+	//queued mail is sent before the app exits
+	mailDone := listenForMail()
+	defer stopMail(mailDone, mailDrainTimeout)
+	//stop deleting expired sessions
+	if s, ok := session.Store.(interface{ StopCleanup() }); ok {
+		defer s.StopCleanup()
+	}
+	//deliver webhooks in the background
+	app.Webhooks.Start(2)
+	defer app.Webhooks.Stop()
+	//run the guest emails and the housekeeping schedule each day, stopped
+	//before the mail channel is closed
+	jobs := scheduleJobs()
+	jobs.Start()
+	defer jobs.Stop()
 
-	srv := &http.Server{
-		Addr:    portNumber,
-		Handler: routes(&app),
+	err = serve(routes(&app))
+	if err != nil {
+		log.Fatal(err)
 	}
-	err = srv.ListenAndServe()
-	log.Fatal(err)
+	app.Logger.Info("server stopped")
 }
 func run() (*driver.DB, error) {
 	// (register the reservation object to session) what am I going to put in the session
@@ -54,32 +103,171 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
 
-	mailChan := make(chan models.MailData)
+	mailChan := make(chan models.MailData, mailQueueSize)
 	app.MailChan = mailChan
+	app.MailSenders = &sync.WaitGroup{}
 	//change this to true when in production
 	app.InProduction = false
+	//one logger for everything, JSON for the log collector in production;
+	//LOG_LEVEL is debug, info (default), warn or error
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(os.Getenv("LOG_LEVEL"))); err != nil && os.Getenv("LOG_LEVEL") != "" {
+		return nil, fmt.Errorf("LOG_LEVEL: %w", err)
+	}
+	app.Logger = logging.New(os.Stdout, app.InProduction, level)
+	//the standard log package and libraries using it go through it too
+	slog.SetDefault(app.Logger)
+
+	//prometheus metrics on /metrics, METRICS_TOKEN keeps them private
+	app.Metrics = metrics.New()
+	app.Metrics.RegisterMailQueue(func() int { return len(app.MailChan) })
+	app.MetricsToken = os.Getenv("METRICS_TOKEN")
+	if app.MetricsToken == "" && app.InProduction {
+		app.Logger.Warn("METRICS_TOKEN is not set, /metrics is public")
+	}
 
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-	ErrorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = ErrorLog
+	app.LoginPolicy = loginguard.DefaultPolicy()
+	app.SecurityHeaders = secheaders.Default()
+	//browsers remember hsts, only send it when the site is served over https
+	app.SecurityHeaders.HSTS = app.InProduction
+
+	//secrets in the database are encrypted with a 32 byte hex key
+	key := os.Getenv("ENCRYPTION_KEY")
+	if key == "" && !app.InProduction {
+		app.Logger.Warn("ENCRYPTION_KEY is not set, using the insecure development key")
+		key = devEncryptionKey
+	}
+	encryptionKey, err := encryption.ParseKey(key)
+	if err != nil {
+		return nil, fmt.Errorf("ENCRYPTION_KEY: %w", err)
+	}
+	app.EncryptionKey = encryptionKey
+
+	//links in emails point to APP_BASE_URL, the host of a request is up to
+	//the client
+	baseURL := os.Getenv("APP_BASE_URL")
+	if baseURL == "" && !app.InProduction {
+		app.Logger.Warn("APP_BASE_URL is not set, emailed links point to " + devBaseURL)
+		baseURL = devBaseURL
+	}
+	app.AppBaseURL, err = parseBaseURL(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("APP_BASE_URL: %w", err)
+	}
+
+	//reservations that look like spam wait for approval, BOT_CHALLENGE picks
+	//proof-of-work (default), arithmetic or none
+	challengeKind, ok := os.LookupEnv("BOT_CHALLENGE")
+	if !ok {
+		challengeKind = botguard.KindProofOfWork
+	}
+	challenge, err := botguard.NewChallenge(challengeKind)
+	if err != nil {
+		return nil, fmt.Errorf("BOT_CHALLENGE: %w", err)
+	}
+	//the guard gets its own key, a leaked form token must not put the totp
+	//secrets at risk
+	guardKey, err := encryption.DeriveKey(app.EncryptionKey, "botguard")
+	if err != nil {
+		return nil, err
+	}
+	app.BotGuard = botguard.New(guardKey, challenge)
+
+	//X-Forwarded-For is only believed when it comes from one of these proxies
+	app.TrustedProxies, err = helpers.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("TRUSTED_PROXIES: %w", err)
+	}
+	//requests a minute per client and route group, a shared ratelimit.Store
+	//is needed once several instances run behind a load balancer. api-ip
+	//limits each address before its api key is looked up. RATE_LIMIT_PUBLIC,
+	//RATE_LIMIT_API and RATE_LIMIT_API_IP override a group as "20,10" for
+	//requests a minute and burst, or turn it off with "off"
+	app.RateLimits = map[string]ratelimit.Limit{
+		"public": ratelimit.PerMinute(20, 10),
+		"api":    ratelimit.PerMinute(120, 30),
+		"api-ip": ratelimit.PerMinute(240, 60),
+	}
+	for group := range app.RateLimits {
+		env := "RATE_LIMIT_" + strings.ToUpper(strings.ReplaceAll(group, "-", "_"))
+		switch v := os.Getenv(env); v {
+		case "":
+		case "off":
+			delete(app.RateLimits, group)
+		default:
+			app.RateLimits[group], err = ratelimit.ParsePerMinute(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", env, err)
+			}
+		}
+	}
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore(), app.Logger)
+
+	//serve https when a certificate is configured
+	app.TLSCertFile = os.Getenv("TLS_CERT_FILE")
+	app.TLSKeyFile = os.Getenv("TLS_KEY_FILE")
+	if (app.TLSCertFile == "") != (app.TLSKeyFile == "") {
+		return nil, errors.New("TLS_CERT_FILE and TLS_KEY_FILE must be set together")
+	}
+
+	//HOUSEKEEPING_EMAIL gets the housekeeping schedule every morning at
+	//HOUSEKEEPING_MAIL_TIME, 07:00 by default
+	app.HousekeepingEmail = os.Getenv("HOUSEKEEPING_EMAIL")
+	if v := os.Getenv("HOUSEKEEPING_MAIL_TIME"); v != "" {
+		housekeepingMailTime, err = parseTimeOfDay(v)
+		if err != nil {
+			return nil, fmt.Errorf("HOUSEKEEPING_MAIL_TIME: %w", err)
+		}
+	}
+	//guests are reminded PRE_ARRIVAL_DAYS before they arrive, 3 by default
+	//and 0 for never, and thanked after they leave, at GUEST_MAIL_TIME,
+	//10:00 by default; REVIEW_URL is linked in the thank-you email
+	app.PreArrivalDays = defaultPreArrivalDays
+	if v := os.Getenv("PRE_ARRIVAL_DAYS"); v != "" {
+		app.PreArrivalDays, err = strconv.Atoi(v)
+		if err != nil || app.PreArrivalDays < 0 {
+			return nil, fmt.Errorf("PRE_ARRIVAL_DAYS: %q is not a number of days", v)
+		}
+	}
+	if v := os.Getenv("GUEST_MAIL_TIME"); v != "" {
+		guestMailTime, err = parseTimeOfDay(v)
+		if err != nil {
+			return nil, fmt.Errorf("GUEST_MAIL_TIME: %w", err)
+		}
+	}
+	app.ReviewURL = os.Getenv("REVIEW_URL")
 
 	// Initialize a new session manager and configure the session lifetime.
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
 	session.Cookie.Persist = true
 	session.Cookie.SameSite = http.SameSiteLaxMode
-	session.Cookie.Secure = app.InProduction
+	session.Cookie.Secure = app.InProduction || app.TLSCertFile != ""
 	// store the session to config app.Session
 	app.Session = session
 	//connect to database
-	log.Println("connecting to database...")
-	//password will be updated later
-	db, err := driver.ConnectSQL("host=localhost port=5432 dbname=bookings user=postgres password=")
+	app.Logger.Info("connecting to database")
+	db, err := driver.ConnectSQL(driver.DSN())
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errDatabaseUnavailable, err)
+	}
+	app.Logger.Info("connected to database")
+	app.Metrics.RegisterDB(db.SQL)
+
+	//sessions are kept in memory unless SESSION_STORE says otherwise,
+	//the sql stores keep logins and reservations across restarts
+	cleanupInterval := defaultSessionCleanupInterval
+	if v := os.Getenv("SESSION_CLEANUP_INTERVAL"); v != "" {
+		cleanupInterval, err = time.ParseDuration(v)
+		if err != nil {
+			return nil, fmt.Errorf("SESSION_CLEANUP_INTERVAL: %w", err)
+		}
+	}
+	store, err := sessionstore.Open(os.Getenv("SESSION_STORE"), db.SQL, cleanupInterval, app.Logger)
 	if err != nil {
-		log.Fatal("cannot connect to db, dying...")
+		return nil, fmt.Errorf("SESSION_STORE: %w", err)
 	}
-	log.Println("Connected to database!")
+	session.Store = store
 	tc, err := render.CreateTemplateCache()
 	if err != nil {
 		log.Fatal("cannot create template cache", err)
@@ -87,10 +275,38 @@ func run() (*driver.DB, error) {
 	}
 	app.TemplateCache = tc
 	app.UseCache = false
+
+	//readiness, load balancers stop sending requests while a check fails
+	app.Health = health.New(health.DefaultTimeout)
+	app.Health.Add("database", db.SQL.PingContext)
+	app.Health.Add("templates", func(ctx context.Context) error {
+		if len(app.TemplateCache) == 0 {
+			return errors.New("template cache is empty")
+		}
+		return nil
+	})
+	app.Health.Add("mail", checkMailServer)
 	// give render access to app
 	render.NewRenderer(&app)
 	repo := handlers.NewRepo(&app, db)
+	app.Webhooks = webhooks.New(repo.DB, app.Logger)
 	handlers.NewHandler(repo)
 	helpers.NewHelper(&app)
 	return db, nil
 }
+
+// parseBaseURL checks an http or https url without a path, query or
+// fragment and returns it without a trailing slash
+func parseBaseURL(s string) (string, error) {
+	u, err := url.Parse(strings.TrimSuffix(s, "/"))
+	if err != nil {
+		return "", err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", fmt.Errorf("%q is not an http or https url", s)
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return "", fmt.Errorf("%q must only have a scheme and host", s)
+	}
+	return u.String(), nil
+}
@@ -1,10 +1,35 @@
 package main
 
-import "testing"
+import (
+	"errors"
+	"testing"
+)
 
 func TestRun(t *testing.T) {
-	_,err := run()
+	_, err := run()
+	if errors.Is(err, errDatabaseUnavailable) {
+		t.Skip("postgres is not running:", err)
+	}
 	if err != nil {
 		t.Error("failed run().")
 	}
 }
+
+func TestParseBaseURL(t *testing.T) {
+	for in, want := range map[string]string{
+		"https://example.com":       "https://example.com",
+		"https://example.com/":      "https://example.com",
+		"http://localhost:8080":     "http://localhost:8080",
+		"https://book.example.com/": "https://book.example.com",
+	} {
+		got, err := parseBaseURL(in)
+		if err != nil || got != want {
+			t.Errorf("%s: got %s, %v", in, got, err)
+		}
+	}
+	for _, in := range []string{"", "example.com", "ftp://example.com", "https://", "https://example.com/app", "https://example.com?x=1", "https://u:p@example.com"} {
+		if _, err := parseBaseURL(in); err == nil {
+			t.Errorf("%q: expected an error", in)
+		}
+	}
+}
@@ -1,9 +1,18 @@
 package main
 
 import (
+	"crypto/subtle"
+	"log/slog"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/acceleraterA/go_app_udemy/internal/handlers"
 	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/secheaders"
+	"github.com/go-chi/chi"
 	"github.com/justinas/nosurf"
 )
 
@@ -22,25 +31,252 @@ func NoSurf(next http.Handler) http.Handler {
 	csrfHandler.SetBaseCookie(http.Cookie{
 		HttpOnly: true,
 		Path:     "/",
-		Secure:   app.InProduction,
+		Secure:   app.InProduction || app.TLSCertFile != "",
 		SameSite: http.SameSiteLaxMode,
 	})
+	//the partner api authenticates with api keys instead of cookies and
+	//browsers send csp reports without a token
+	csrfHandler.ExemptFunc(func(r *http.Request) bool {
+		return strings.HasPrefix(r.URL.Path, "/api/v1/") || r.URL.Path == "/csp-report"
+	})
 	return csrfHandler
 }
 
+// SecureHeaders sets the Content-Security-Policy and the other security headers
+func SecureHeaders(next http.Handler) http.Handler {
+	return secheaders.Middleware(app.SecurityHeaders)(next)
+}
+
 // SessionLoad loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
-// has access to request
+// Auth makes sure a user is logged in and stores the user in the request context
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if !helpers.IsAuthenticated(r) {
 			session.Put(r.Context(), "error", "login first!")
-			http.Redirect(w, r, "user/login", http.StatusSeeOther)
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
-		next.ServeHTTP(w, r)
+		user, err := handlers.Repo.DB.GetUserByID(r.Context(), session.GetInt(r.Context(), "user_id"))
+		if err != nil || !user.Active || user.SessionVersion != session.GetInt(r.Context(), "session_version") {
+			//the account is gone, deactivated or its password changed, drop the stale login
+			_ = session.Destroy(r.Context())
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		//a role that requires two factor authentication can only enrol until it is set up
+		if session.GetBool(r.Context(), "must_enroll_2fa") && !strings.HasPrefix(r.URL.Path, "/user/2fa") {
+			http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+			return
+		}
+		//keep the role shown in templates in step with the database
+		session.Put(r.Context(), "access_level", user.AccessLevel)
+		next.ServeHTTP(w, r.WithContext(helpers.WithUser(r.Context(), user)))
 	})
 }
+
+// RequireRole only lets through users whose access level is at least level,
+// it must run after Auth
+func RequireRole(level int) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, ok := helpers.UserFromContext(r.Context())
+			if !ok || !user.HasRole(level) {
+				helpers.ClientError(w, r, http.StatusForbidden)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// apiKeyLastUsedPrecision is how out of date the last use of an api key may be
+const apiKeyLastUsedPrecision = time.Minute
+
+// APIKeyAuth identifies callers that send an api key in the Authorization
+// header and stores the key in the request context
+func APIKeyAuth(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if header == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !strings.HasPrefix(header, "Bearer ") {
+			helpers.ErrorJSON(w, http.StatusUnauthorized, "authorization header must be Bearer <api key>")
+			return
+		}
+		key, err := handlers.Repo.DB.GetAPIKeyByHash(r.Context(), helpers.HashAPIKey(strings.TrimPrefix(header, "Bearer ")))
+		if err != nil || key.Revoked() {
+			helpers.ErrorJSON(w, http.StatusUnauthorized, "invalid api key")
+			return
+		}
+		//a busy key would write on every request, last use is only recorded
+		//to the minute
+		if time.Since(key.LastUsedAt) > apiKeyLastUsedPrecision {
+			if err := handlers.Repo.DB.UpdateAPIKeyLastUsed(r.Context(), key.ID); err != nil {
+				app.Logger.ErrorContext(r.Context(), "can't record api key use", "api_key_id", key.ID, "err", err)
+			}
+		}
+		next.ServeHTTP(w, r.WithContext(helpers.WithAPIKey(r.Context(), key)))
+	})
+}
+
+// RequireScope only lets through api callers whose key was granted scope
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, ok := helpers.APIKeyFromContext(r.Context())
+			if !ok {
+				helpers.ErrorJSON(w, http.StatusUnauthorized, "api key required")
+				return
+			}
+			if !key.HasScope(scope) {
+				helpers.ErrorJSON(w, http.StatusForbidden, "api key is missing the "+scope+" scope")
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// RateLimit limits how often each client may call the routes of group, groups
+// without a limit in app.RateLimits are not limited
+func RateLimit(group string) func(http.Handler) http.Handler {
+	limit, ok := app.RateLimits[group]
+	if !ok || app.RateLimiter == nil {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return app.RateLimiter.Middleware(group, limit, rateLimitKey)
+}
+
+// rateLimitKey identifies api callers by their key and everybody else by ip address
+func rateLimitKey(r *http.Request) string {
+	if key, ok := helpers.APIKeyFromContext(r.Context()); ok {
+		return "key:" + strconv.Itoa(key.ID)
+	}
+	return "ip:" + helpers.ClientIP(r)
+}
+
+// requestIDHeader carries the request id in from a proxy and back out to the client
+const requestIDHeader = "X-Request-ID"
+
+// RequestID gives every request an id, the one a trusted proxy sent along or
+// a new one, and stores it with the method and path for every log line
+func RequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) || !helpers.FromTrustedProxy(r) {
+			id = logging.NewRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		ctx := logging.WithRequest(r.Context(), logging.Request{ID: id, Method: r.Method, Path: r.URL.Path})
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID only accepts short ids of letters, digits and dashes
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 64 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// LogRequest logs every request once it is served, with its status and latency
+func LogRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		level := slog.LevelInfo
+		if sw.status >= http.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		app.Logger.LogAttrs(r.Context(), level, "request",
+			slog.Int("status", sw.status),
+			slog.Duration("latency", time.Since(start)),
+			slog.Int("bytes", sw.bytes),
+			slog.String("ip", helpers.ClientIP(r)),
+		)
+	})
+}
+
+// RecordMetrics counts every request and its latency by chi route pattern,
+// so /reservations/{id} is one series however many ids are requested
+func RecordMetrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r)
+
+		route := "unmatched"
+		if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+			route = rctx.RoutePattern()
+		}
+		app.Metrics.HTTPRequests.Inc(route, r.Method, strconv.Itoa(sw.status))
+		app.Metrics.HTTPDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+	})
+}
+
+// MetricsAuth requires the bearer token in METRICS_TOKEN on /metrics when it
+// is set, the metrics show booking numbers
+func MetricsAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token != "" {
+				got := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				if subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+					w.Header().Set("WWW-Authenticate", `Bearer realm="metrics"`)
+					helpers.ClientError(w, r, http.StatusUnauthorized)
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// statusWriter remembers the status and size of a response
+type statusWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if !w.wroteHeader {
+		w.status = status
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection, to flush or set deadlines
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Flush sends buffered data to the client, for streamed responses
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -1,9 +1,28 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/go-chi/chi"
+
+	"github.com/acceleraterA/go_app_udemy/internal/handlers"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/repository"
 )
 
 func TestNoSurf(t *testing.T) {
@@ -30,3 +49,356 @@ func TestSessionLoad(t *testing.T) {
 		t.Errorf(fmt.Sprintf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestAPIKeyAuth(t *testing.T) {
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+
+	var tests = []struct {
+		name               string
+		header             string
+		scope              string
+		expectedStatusCode int
+	}{
+		{"no key", "", models.ScopeAvailabilityRead, http.StatusUnauthorized},
+		{"not bearer", "Basic abc", models.ScopeAvailabilityRead, http.StatusUnauthorized},
+		{"unknown key", "Bearer nope", models.ScopeAvailabilityRead, http.StatusUnauthorized},
+		{"revoked key", "Bearer revoked-key", models.ScopeAvailabilityRead, http.StatusUnauthorized},
+		{"missing scope", "Bearer read-key", models.ScopeAdminRead, http.StatusForbidden},
+		{"valid", "Bearer read-key", models.ScopeAvailabilityRead, http.StatusOK},
+		{"all scopes", "Bearer admin-key", models.ScopeAdminRead, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		var caller string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			key, _ := helpers.APIKeyFromContext(r.Context())
+			caller = key.Name
+		})
+		h := APIKeyAuth(RequireScope(e.scope)(next))
+
+		req := httptest.NewRequest("GET", "/api/v1/availability", nil)
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusOK && caller == "" {
+			t.Errorf("%s: api key was not stored in the request context", e.name)
+		}
+		if rr.Code != http.StatusOK && rr.Header().Get("Content-Type") != "application/json" {
+			t.Errorf("%s: expected a JSON error", e.name)
+		}
+	}
+}
+
+// lastUsedRepo hands out an api key last used at lastUsed and counts the
+// updates of its last use, which fail when failing is set
+type lastUsedRepo struct {
+	repository.DatabaseRepo
+	lastUsed time.Time
+	failing  bool
+	updates  int
+}
+
+func (m *lastUsedRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	return models.APIKey{ID: 1, Name: "read", Scopes: []string{models.ScopeAvailabilityRead}, LastUsedAt: m.lastUsed}, nil
+}
+
+func (m *lastUsedRepo) UpdateAPIKeyLastUsed(ctx context.Context, id int) error {
+	m.updates++
+	if m.failing {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func TestAPIKeyAuth_LastUsed(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = logging.New(&buf, false, slog.LevelInfo)
+	repo := handlers.NewTestRepo(&app)
+	handlers.NewHandler(repo)
+	testDB := repo.DB
+
+	var tests = []struct {
+		name     string
+		lastUsed time.Time
+		failing  bool
+		updates  int
+		logged   bool
+	}{
+		{"never used", time.Time{}, false, 1, false},
+		{"used an hour ago", time.Now().Add(-time.Hour), false, 1, false},
+		{"used just now", time.Now().Add(-time.Second), false, 0, false},
+		{"update fails", time.Time{}, true, 1, true},
+	}
+
+	for _, e := range tests {
+		buf.Reset()
+		db := &lastUsedRepo{DatabaseRepo: testDB, lastUsed: e.lastUsed, failing: e.failing}
+		repo.DB = db
+		req := httptest.NewRequest("GET", "/api/v1/availability", nil)
+		req.Header.Set("Authorization", "Bearer read-key")
+		rr := httptest.NewRecorder()
+		APIKeyAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: expected %d but got %d", e.name, http.StatusOK, rr.Code)
+		}
+		if db.updates != e.updates {
+			t.Errorf("%s: expected %d updates but got %d", e.name, e.updates, db.updates)
+		}
+		if logged := strings.Contains(buf.String(), "can't record api key use"); logged != e.logged {
+			t.Errorf("%s: expected the failure logged %v, log was %q", e.name, e.logged, buf.String())
+		}
+	}
+}
+
+func TestAuthAndRequireRole(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	app.Logger = logging.New(os.Stdout, false, slog.LevelInfo)
+	helpers.NewHelper(&app)
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+
+	var tests = []struct {
+		name               string
+		userID             int
+		sessionVersion     int
+		level              int
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"not logged in", 0, 0, models.AccessLevelFrontDesk, http.StatusSeeOther, "/user/login"},
+		{"unknown user", 99, 0, models.AccessLevelFrontDesk, http.StatusSeeOther, "/user/login"},
+		{"deactivated user", 4, 0, models.AccessLevelFrontDesk, http.StatusSeeOther, "/user/login"},
+		{"password changed since login", 1, 1, models.AccessLevelFrontDesk, http.StatusSeeOther, "/user/login"},
+		{"front desk", 1, 0, models.AccessLevelFrontDesk, http.StatusOK, ""},
+		{"front desk on manager page", 1, 0, models.AccessLevelManager, http.StatusForbidden, ""},
+		{"manager", 2, 0, models.AccessLevelManager, http.StatusOK, ""},
+		{"manager on owner page", 2, 0, models.AccessLevelOwner, http.StatusForbidden, ""},
+		{"owner", 3, 0, models.AccessLevelOwner, http.StatusOK, ""},
+		{"guest on staff page", 5, 0, models.AccessLevelFrontDesk, http.StatusForbidden, ""},
+	}
+
+	for _, e := range tests {
+		var role string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, _ := helpers.UserFromContext(r.Context())
+			role = user.Role()
+		})
+		login := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if e.userID > 0 {
+					session.Put(r.Context(), "user_id", e.userID)
+					session.Put(r.Context(), "session_version", e.sessionVersion)
+				}
+				next.ServeHTTP(w, r)
+			})
+		}
+		h := SessionLoad(login(Auth(RequireRole(e.level)(next))))
+
+		req := httptest.NewRequest("GET", "/admin/dashboard", nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if e.expectedLocation != "" && rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %s but got %s", e.name, e.expectedLocation, rr.Header().Get("Location"))
+		}
+		if rr.Code == http.StatusOK && role != models.Roles[e.userID] {
+			t.Errorf("%s: expected role %s in the request context but got %s", e.name, models.Roles[e.userID], role)
+		}
+	}
+}
+
+func TestAuthTwoFactorEnrolment(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+
+	var tests = []struct {
+		name               string
+		path               string
+		expectedStatusCode int
+	}{
+		{"admin page", "/admin/dashboard", http.StatusSeeOther},
+		{"enrolment page", "/user/2fa", http.StatusOK},
+		{"enable", "/user/2fa/enable", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		login := func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				session.Put(r.Context(), "user_id", 3)
+				session.Put(r.Context(), "must_enroll_2fa", true)
+				next.ServeHTTP(w, r)
+			})
+		}
+		h := SessionLoad(login(Auth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))))
+
+		req := httptest.NewRequest("GET", e.path, nil)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/user/2fa" {
+			t.Errorf("%s: expected redirect to /user/2fa but got %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRateLimitKey(t *testing.T) {
+	proxies, err := helpers.ParseTrustedProxies("10.0.0.0/8, 192.0.2.7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.TrustedProxies = proxies
+	defer func() { app.TrustedProxies = nil }()
+	helpers.NewHelper(&app)
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		forwarded  string
+		expected   string
+	}{
+		{"direct", "203.0.113.5:1234", "", "ip:203.0.113.5"},
+		{"untrusted peer sends a forwarded header", "203.0.113.5:1234", "198.51.100.1", "ip:203.0.113.5"},
+		{"trusted proxy", "192.0.2.7:1234", "198.51.100.1", "ip:198.51.100.1"},
+		{"client spoofs the left of the chain", "192.0.2.7:1234", "1.2.3.4, 198.51.100.1, 10.1.1.1", "ip:198.51.100.1"},
+		{"garbage in the chain", "192.0.2.7:1234", "nope", "ip:192.0.2.7"},
+	}
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", "/make-reservation", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", e.forwarded)
+		}
+		if got := rateLimitKey(req); got != e.expected {
+			t.Errorf("%s: expected %s but got %s", e.name, e.expected, got)
+		}
+	}
+
+	req := httptest.NewRequest("GET", "/api/v1/availability", nil)
+	req = req.WithContext(helpers.WithAPIKey(req.Context(), models.APIKey{ID: 3}))
+	if got := rateLimitKey(req); got != "key:3" {
+		t.Errorf("api caller: expected key:3 but got %s", got)
+	}
+}
+
+func TestRequestIDAndLogRequest(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = logging.New(&buf, true, slog.LevelInfo)
+	proxies, _ := helpers.ParseTrustedProxies("192.0.2.7")
+	app.TrustedProxies = proxies
+	defer func() { app.TrustedProxies = nil }()
+	helpers.NewHelper(&app)
+
+	var tests = []struct {
+		name       string
+		remoteAddr string
+		header     string
+		keepsID    bool
+	}{
+		{"no id", "203.0.113.5:1234", "", false},
+		{"client sends an id", "203.0.113.5:1234", "abc-123", false},
+		{"trusted proxy sends an id", "192.0.2.7:1234", "abc-123", true},
+		{"trusted proxy sends garbage", "192.0.2.7:1234", "abc 123\n", false},
+	}
+
+	for _, e := range tests {
+		buf.Reset()
+		var seen string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			req, _ := logging.RequestFromContext(r.Context())
+			seen = req.ID
+			app.Logger.InfoContext(r.Context(), "inside")
+			w.WriteHeader(http.StatusTeapot)
+		})
+		req := httptest.NewRequest("GET", "/about", nil)
+		req.RemoteAddr = e.remoteAddr
+		if e.header != "" {
+			req.Header.Set("X-Request-ID", e.header)
+		}
+		rr := httptest.NewRecorder()
+		RequestID(LogRequest(next)).ServeHTTP(rr, req)
+
+		id := rr.Header().Get("X-Request-ID")
+		if id == "" || id != seen {
+			t.Errorf("%s: response id %q doesn't match the context %q", e.name, id, seen)
+		}
+		if (id == e.header) != e.keepsID {
+			t.Errorf("%s: got id %q", e.name, id)
+		}
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("%s: expected 2 log lines, got %s", e.name, buf.String())
+		}
+		for _, l := range lines {
+			var line map[string]interface{}
+			if err := json.Unmarshal([]byte(l), &line); err != nil {
+				t.Fatal(err)
+			}
+			if line["request_id"] != id || line["method"] != "GET" || line["path"] != "/about" {
+				t.Errorf("%s: line misses the request: %s", e.name, l)
+			}
+			if line["msg"] == "request" && (line["status"] != float64(http.StatusTeapot) || line["latency"] == nil) {
+				t.Errorf("%s: request line misses status or latency: %s", e.name, l)
+			}
+		}
+	}
+}
+
+func TestRecordMetricsAndMetricsAuth(t *testing.T) {
+	app.Metrics = metrics.New()
+	app.Logger = logging.New(os.Stdout, false, slog.LevelInfo)
+	helpers.NewHelper(&app)
+
+	mux := chi.NewRouter()
+	mux.Use(RecordMetrics)
+	mux.Get("/rooms/{id}", func(w http.ResponseWriter, r *http.Request) {
+		if chi.URLParam(r, "id") == "9" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	})
+	mux.With(MetricsAuth("s3cret")).Get("/metrics", app.Metrics.Registry.Handler().ServeHTTP)
+
+	for _, path := range []string{"/rooms/1", "/rooms/2", "/rooms/9", "/nowhere"} {
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", path, nil))
+	}
+
+	rr := httptest.NewRecorder()
+	mux.ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if rr.Code != http.StatusUnauthorized {
+		t.Fatalf("scrape without the token: expected 401 but got %d", rr.Code)
+	}
+
+	req := httptest.NewRequest("GET", "/metrics", nil)
+	req.Header.Set("Authorization", "Bearer s3cret")
+	rr = httptest.NewRecorder()
+	mux.ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("scrape with the token: expected 200 but got %d", rr.Code)
+	}
+	body := rr.Body.String()
+	for _, line := range []string{
+		`bookings_http_requests_total{route="/rooms/{id}",method="GET",code="200"} 2`,
+		`bookings_http_requests_total{route="/rooms/{id}",method="GET",code="404"} 1`,
+		`bookings_http_requests_total{route="unmatched",method="GET",code="404"} 1`,
+		`bookings_http_requests_total{route="/metrics",method="GET",code="401"} 1`,
+		`bookings_http_request_duration_seconds_count{route="/rooms/{id}",method="GET"} 3`,
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, body)
+		}
+	}
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
 	"github.com/acceleraterA/go_app_udemy/internal/handlers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
 )
@@ -12,10 +13,17 @@ import (
 func routes(app *config.AppConfig) http.Handler {
 	r := chi.NewRouter()
 
+	r.Use(RequestID)
+	r.Use(LogRequest)
+	r.Use(RecordMetrics)
 	r.Use(middleware.Recoverer)
+	r.Use(SecureHeaders)
 	r.Use(NoSurf)
 	r.Use(SessionLoad)
 
+	r.Get("/healthz", app.Health.Live)
+	r.Get("/readyz", app.Health.Ready)
+
 	r.Get("/", handlers.Repo.Home)
 	r.Get("/about", handlers.Repo.About)
 	r.Get("/generals-quarters", handlers.Repo.Generals)
@@ -24,20 +32,98 @@ func routes(app *config.AppConfig) http.Handler {
 	r.Get("/book-room", handlers.Repo.BookRoom)
 
 	r.Get("/search-availability", handlers.Repo.Availability)
-	r.Post("/search-availability", handlers.Repo.PostAvailability)
-	r.Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	r.With(RateLimit("public")).Post("/search-availability", handlers.Repo.PostAvailability)
+	r.With(RateLimit("public")).Post("/search-availability-json", handlers.Repo.AvailabilityJSON)
+	r.Get("/api/openapi.json", handlers.Repo.OpenAPI)
+	r.With(MetricsAuth(app.MetricsToken)).Get("/metrics", app.Metrics.Registry.Handler().ServeHTTP)
 
 	r.Get("/contact", handlers.Repo.Contact)
+	r.With(RateLimit("public")).Post("/contact", handlers.Repo.PostContact)
+	r.Post("/csp-report", handlers.Repo.CSPReport)
 	r.Get("/make-reservation", handlers.Repo.Reservation)
-	r.Post("/make-reservation", handlers.Repo.PostReservation)
+	r.With(RateLimit("public")).Post("/make-reservation", handlers.Repo.PostReservation)
 	r.Get("/reservation-summary", handlers.Repo.ReservationSummary)
 	r.Get("/user/login", handlers.Repo.ShowLogin)
-	r.Post("/user/login", handlers.Repo.PostShowLogin)
+	r.With(RateLimit("public")).Post("/user/login", handlers.Repo.PostShowLogin)
+	r.Get("/user/login/2fa", handlers.Repo.ShowLoginTwoFactor)
+	r.With(RateLimit("public")).Post("/user/login/2fa", handlers.Repo.PostLoginTwoFactor)
 	r.Get("/user/logout", handlers.Repo.Logout)
+	r.Get("/user/invite", handlers.Repo.ShowAcceptInvite)
+	r.Post("/user/invite", handlers.Repo.PostAcceptInvite)
+	r.Get("/user/forgot-password", handlers.Repo.ShowForgotPassword)
+	r.With(RateLimit("public")).Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	r.Get("/user/reset-password", handlers.Repo.ShowResetPassword)
+	r.With(RateLimit("public")).Post("/user/reset-password", handlers.Repo.PostResetPassword)
+	r.Get("/user/register", handlers.Repo.ShowRegister)
+	r.With(RateLimit("public")).Post("/user/register", handlers.Repo.PostRegister)
+	r.Get("/user/verify", handlers.Repo.VerifyEmail)
+	r.Group(func(r chi.Router) {
+		r.Use(Auth)
+		r.Get("/user/profile", handlers.Repo.Profile)
+		r.Post("/user/profile", handlers.Repo.PostProfile)
+		r.Post("/user/password", handlers.Repo.PostPassword)
+		r.Get("/user/2fa", handlers.Repo.TwoFactor)
+		r.Post("/user/2fa/enable", handlers.Repo.PostEnableTwoFactor)
+		r.Post("/user/2fa/disable", handlers.Repo.PostDisableTwoFactor)
+		r.Get("/user/bookings", handlers.Repo.MyBookings)
+	})
 	//redirect to secure page for admin user
 	r.Route("/admin", func(r chi.Router) {
 		r.Use(Auth)
+		r.Use(RequireRole(models.AccessLevelFrontDesk))
 		r.Get("/dashboard", handlers.Repo.AdminDashboard)
+
+		r.Get("/reservations", handlers.Repo.AdminReservations)
+		r.Get("/reservations/held", handlers.Repo.AdminHeldReservations)
+		r.Post("/reservations/{id}/approve", handlers.Repo.AdminApproveReservation)
+		r.Post("/reservations/{id}/reject", handlers.Repo.AdminRejectReservation)
+		r.Get("/reservations/{id}", handlers.Repo.AdminShowReservation)
+		r.Post("/reservations/{id}", handlers.Repo.AdminPostReservation)
+		r.With(RequireRole(models.AccessLevelManager)).Post("/reservations/{id}/cancel", handlers.Repo.AdminCancelReservation)
+		r.Get("/housekeeping", handlers.Repo.AdminHousekeeping)
+		r.Get("/housekeeping/print", handlers.Repo.AdminHousekeepingPrint)
+		r.Post("/housekeeping/{id}", handlers.Repo.AdminPostHousekeeping)
+		r.Get("/messages", handlers.Repo.AdminContactMessages)
+		r.Get("/messages/{id}", handlers.Repo.AdminShowContactMessage)
+		r.Post("/messages/{id}/reply", handlers.Repo.AdminPostContactReply)
+		r.Post("/messages/{id}/status", handlers.Repo.AdminPostContactMessageStatus)
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(models.AccessLevelManager))
+			r.Get("/reservations/export", handlers.Repo.AdminExportReservations)
+			r.Get("/login-attempts", handlers.Repo.AdminLoginAttempts)
+			r.Get("/audit", handlers.Repo.AdminAuditLog)
+			r.Get("/security", handlers.Repo.AdminSecurity)
+			r.Post("/security", handlers.Repo.AdminPostSecurity)
+		})
+
+		//integrations and staff accounts are for owners only
+		r.Group(func(r chi.Router) {
+			r.Use(RequireRole(models.AccessLevelOwner))
+			r.Get("/api-keys", handlers.Repo.AdminAPIKeys)
+			r.Post("/api-keys", handlers.Repo.AdminPostAPIKey)
+			r.Post("/api-keys/{id}/revoke", handlers.Repo.AdminRevokeAPIKey)
+
+			r.Get("/webhooks", handlers.Repo.AdminWebhooks)
+			r.Post("/webhooks", handlers.Repo.AdminPostWebhook)
+			r.Post("/webhooks/{id}/delete", handlers.Repo.AdminDeleteWebhook)
+			r.Post("/webhooks/deliveries/{id}/replay", handlers.Repo.AdminReplayWebhookDelivery)
+
+			r.Get("/users", handlers.Repo.AdminUsers)
+			r.Post("/users", handlers.Repo.AdminPostUser)
+			r.Get("/users/{id}", handlers.Repo.AdminShowUser)
+			r.Post("/users/{id}", handlers.Repo.AdminPostUserUpdate)
+			r.Post("/users/{id}/delete", handlers.Repo.AdminDeleteUser)
+		})
+	})
+	//partner api, authenticated with api keys
+	r.Route("/api/v1", func(r chi.Router) {
+		//guessing keys is limited per address, a valid key is limited on its own
+		r.Use(RateLimit("api-ip"))
+		r.Use(APIKeyAuth)
+		r.Use(RateLimit("api"))
+		r.With(RequireScope(models.ScopeAvailabilityRead)).Get("/availability", handlers.Repo.APIAvailability)
+		r.With(RequireScope(models.ScopeReservationsCreate)).Post("/reservations", handlers.Repo.APIPostReservation)
+		r.With(RequireScope(models.ScopeAdminRead)).Get("/reservations", handlers.Repo.APIReservations)
 	})
 	fileServer := http.FileServer(http.Dir("./static/"))
 	r.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -2,14 +2,24 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
 	"testing"
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/handlers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
+	"github.com/acceleraterA/go_app_udemy/internal/ratelimit"
+	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 )
 
 func TestRoutes(t *testing.T) {
-	var app config.AppConfig
+	app := config.AppConfig{Metrics: metrics.New()}
 	mux := routes(&app)
 
 	switch v := mux.(type) {
@@ -19,3 +29,26 @@ func TestRoutes(t *testing.T) {
 		t.Errorf(fmt.Sprintf("type is not *chi.Mux, but is %T", v))
 	}
 }
+
+func TestRoutes_APIRateLimitedBeforeKeyLookup(t *testing.T) {
+	session = scs.New()
+	app.Session = session
+	app.Logger = logging.New(io.Discard, false, slog.LevelInfo)
+	app.Metrics = metrics.New()
+	app.RateLimits = map[string]ratelimit.Limit{"api-ip": ratelimit.PerMinute(1, 1)}
+	app.RateLimiter = ratelimit.New(ratelimit.NewMemoryStore(), nil)
+	defer func() { app.RateLimits, app.RateLimiter = nil, nil }()
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+	mux := routes(&app)
+
+	//a client guessing keys is stopped even though no key is ever valid
+	for i, want := range []int{http.StatusUnauthorized, http.StatusTooManyRequests} {
+		req := httptest.NewRequest("GET", "/api/v1/availability", nil)
+		req.Header.Set("Authorization", "Bearer guess-"+strconv.Itoa(i))
+		rr := httptest.NewRecorder()
+		mux.ServeHTTP(rr, req)
+		if rr.Code != want {
+			t.Errorf("request %d: expected %d but got %d", i+1, want, rr.Code)
+		}
+	}
+}
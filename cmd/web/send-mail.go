@@ -1,39 +1,109 @@
 package main
 
 import (
+	"bufio"
+	"context"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"net"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 	mail "github.com/xhit/go-simple-mail/v2"
 )
 
-func listenForMail() {
+// the mail server, a dummy one listening on 1025 in development; real mail
+// servers listen on 25, 587 or 465
+const (
+	mailHost = "localhost"
+	mailPort = 1025
+)
+
+// mailDrainTimeout is how long shutdown waits for the queued mail to be sent
+const mailDrainTimeout = 30 * time.Second
+
+// listenForMail sends the mail queued on app.MailChan in the background, the
+// returned channel is closed once the queue is closed and sent
+func listenForMail() <-chan struct{} {
+	done := make(chan struct{})
 	go func() {
-		//infinate for loop
-		for {
-			msg := <-app.MailChan
+		defer close(done)
+		//send until the channel is closed on shutdown
+		for msg := range app.MailChan {
 			sendMsg(msg)
 		}
 	}()
+	return done
+}
 
+// stopMail waits for app.MailSenders, closes the mail queue and waits for the
+// listener to send what is left in it, all within timeout; mail still queued
+// after that is lost and counted
+func stopMail(done <-chan struct{}, timeout time.Duration) {
+	deadline := time.After(timeout)
+	senders := make(chan struct{})
+	go func() {
+		app.MailSenders.Wait()
+		close(senders)
+	}()
+	select {
+	case <-senders:
+	case <-deadline:
+		//closing the queue now would make the late senders panic
+		app.Logger.Error("mail senders still running at shutdown", "dropped", len(app.MailChan))
+		return
+	}
+
+	close(app.MailChan)
+	select {
+	case <-done:
+	case <-deadline:
+		app.Logger.Error("mail not sent before shutdown", "dropped", len(app.MailChan))
+	}
+}
+
+// checkMailServer connects to the mail server and waits for its greeting,
+// for the readiness probe
+func checkMailServer(ctx context.Context) error {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", net.JoinHostPort(mailHost, strconv.Itoa(mailPort)))
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	greeting, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("no greeting from mail server: %w", err)
+	}
+	if !strings.HasPrefix(greeting, "220") {
+		return fmt.Errorf("mail server is not ready: %s", strings.TrimSpace(greeting))
+	}
+	fmt.Fprint(conn, "QUIT\r\n")
+	return nil
 }
 
 func sendMsg(m models.MailData) {
+	//log with the request that sent the mail
+	ctx := logging.WithRequest(context.Background(), m.Request)
 	server := mail.NewSMTPClient()
-	server.Host = "localhost"
-	//real mail server listen to 25, 587 465, use dummy mail server here
-	server.Port = 1025
+	server.Host = mailHost
+	server.Port = mailPort
 	server.KeepAlive = false
 	server.ConnectTimeout = 10 * time.Second
 	server.SendTimeout = 10 * time.Second
 
 	client, err := server.Connect()
 	if err != nil {
-		ErrorLog.Println(err)
+		app.Logger.ErrorContext(ctx, "can't connect to mail server", "to", m.To, "err", err)
+		app.Metrics.MailSent.Inc(metrics.MailFailure)
+		return
 	}
 	email := mail.NewMSG()
 	email.SetFrom(m.From).AddTo(m.To).SetSubject(m.Subject)
@@ -43,7 +113,7 @@ func sendMsg(m models.MailData) {
 		//use the email template
 		data, err := ioutil.ReadFile(fmt.Sprintf("./email-templates/%s", m.Template))
 		if err != nil {
-			app.ErrorLog.Println(err)
+			app.Logger.ErrorContext(ctx, "can't read mail template", "template", m.Template, "err", err)
 		}
 		//convert data in byte to string
 		mailTemplate := string(data)
@@ -54,9 +124,11 @@ func sendMsg(m models.MailData) {
 
 	err = email.Send(client)
 	if err != nil {
-		log.Println(err)
+		app.Logger.ErrorContext(ctx, "can't send mail", "to", m.To, "subject", m.Subject, "err", err)
+		app.Metrics.MailSent.Inc(metrics.MailFailure)
 	} else {
-		log.Println("Email sent!")
+		app.Logger.InfoContext(ctx, "mail sent", "to", m.To, "subject", m.Subject)
+		app.Metrics.MailSent.Inc(metrics.MailSuccess)
 	}
 
 }
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+func TestStopMail(t *testing.T) {
+	var buf bytes.Buffer
+	app.Logger = logging.New(&buf, false, slog.LevelInfo)
+
+	var tests = []struct {
+		name    string
+		sending bool
+		sender  bool
+		logged  string
+	}{
+		{"queue sent", true, false, ""},
+		{"listener stuck", false, false, "dropped=2"},
+		{"sender still running", true, true, "mail senders still running"},
+	}
+
+	for _, e := range tests {
+		buf.Reset()
+		app.MailChan = make(chan models.MailData, 3)
+		app.MailSenders = &sync.WaitGroup{}
+		app.MailChan <- models.MailData{To: "a@here.com"}
+		app.MailChan <- models.MailData{To: "b@here.com"}
+		done := make(chan struct{})
+		if e.sending {
+			go func(queue chan models.MailData) {
+				defer close(done)
+				for range queue {
+				}
+			}(app.MailChan)
+		}
+		if e.sender {
+			app.MailSenders.Add(1)
+		}
+
+		stopMail(done, 50*time.Millisecond)
+		if e.logged == "" && buf.Len() > 0 {
+			t.Errorf("%s: expected nothing logged, got %s", e.name, buf.String())
+		}
+		if e.logged != "" && !strings.Contains(buf.String(), e.logged) {
+			t.Errorf("%s: expected %s in the log, got %s", e.name, e.logged, buf.String())
+		}
+		if e.sender {
+			//a late sender must not panic on a closed queue
+			app.MailChan <- models.MailData{To: "c@here.com"}
+			app.MailSenders.Done()
+			close(app.MailChan)
+		}
+	}
+}
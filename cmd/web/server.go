@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/certreload"
+)
+
+// Server timeouts, slow clients can't hold connections open forever
+const (
+	readHeaderTimeout = 5 * time.Second
+	readTimeout       = 15 * time.Second
+	writeTimeout      = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+)
+
+// defaultTLSAddr is where https is served when TLS_ADDR is not set
+const defaultTLSAddr = ":8443"
+
+// On shutdown the server reports not ready for shutdownDrainDelay before it
+// stops accepting connections, then waits up to shutdownTimeout for requests
+const (
+	shutdownDrainDelay = 5 * time.Second
+	shutdownTimeout    = 20 * time.Second
+)
+
+// certWatchInterval is how often the certificate files are checked for changes
+const certWatchInterval = time.Minute
+
+// newServer returns a server for handler with the timeouts set
+func newServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		ErrorLog:          slog.NewLogLogger(app.Logger.Handler(), slog.LevelError),
+	}
+}
+
+// serve serves handler over plain http on portNumber, or over https when a
+// certificate is configured; the certificate is reloaded when its files change
+// or on SIGHUP, and HTTP_REDIRECT_ADDR sends plain http requests to https.
+// It returns nil once the server was shut down by SIGINT or SIGTERM
+func serve(handler http.Handler) error {
+	if app.TLSCertFile == "" {
+		srv := newServer(portNumber, handler)
+		app.Logger.Info("starting application", "addr", portNumber)
+		return listenAndDrain(srv, srv.ListenAndServe)
+	}
+
+	reloader, err := certreload.New(app.TLSCertFile, app.TLSKeyFile, app.Logger)
+	if err != nil {
+		return err
+	}
+	stop := make(chan struct{})
+	defer close(stop)
+	go reloader.Watch(certWatchInterval, stop)
+	go reloadOnHangup(reloader, stop)
+
+	addr := os.Getenv("TLS_ADDR")
+	if addr == "" {
+		addr = defaultTLSAddr
+	}
+	if redirectAddr := os.Getenv("HTTP_REDIRECT_ADDR"); redirectAddr != "" {
+		redirect := newServer(redirectAddr, redirectToHTTPS(addr))
+		defer redirect.Close()
+		go func() {
+			app.Logger.Info("redirecting http to https", "addr", redirectAddr)
+			err := redirect.ListenAndServe()
+			if !errors.Is(err, http.ErrServerClosed) {
+				app.Logger.Error("http redirect stopped", "err", err)
+			}
+		}()
+	}
+
+	srv := newServer(addr, handler)
+	srv.TLSConfig = reloader.TLSConfig()
+	app.Logger.Info("starting application with https", "addr", addr)
+	return listenAndDrain(srv, func() error { return srv.ListenAndServeTLS("", "") })
+}
+
+// listenAndDrain runs listen until it fails or the process gets SIGINT or
+// SIGTERM. Then /readyz reports not ready for shutdownDrainDelay, so load
+// balancers stop sending requests, and the requests in flight get
+// shutdownTimeout to finish; a second signal skips the delay
+func listenAndDrain(srv *http.Server, listen func() error) error {
+	errs := make(chan error, 1)
+	go func() { errs <- listen() }()
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(quit)
+
+	select {
+	case err := <-errs:
+		return err
+	case sig := <-quit:
+		app.Logger.Info("shutting down", "signal", sig.String(), "drain_delay", shutdownDrainDelay)
+	}
+
+	if app.Health != nil {
+		app.Health.Drain()
+	}
+	select {
+	case <-time.After(shutdownDrainDelay):
+	case <-quit:
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return fmt.Errorf("shutdown: %w", err)
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// reloadOnHangup reloads the certificate every time the process gets SIGHUP
+func reloadOnHangup(reloader *certreload.Reloader, stop <-chan struct{}) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-hup:
+			if err := reloader.Reload(); err != nil {
+				app.Logger.Error("can't reload certificate", "err", err)
+				continue
+			}
+			app.Logger.Info("certificate reloaded")
+		case <-stop:
+			return
+		}
+	}
+}
+
+// redirectToHTTPS permanently redirects every request to the same url on the
+// https address
+func redirectToHTTPS(tlsAddr string) http.Handler {
+	_, port, _ := net.SplitHostPort(tlsAddr)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		}
+		if port != "" && port != "443" {
+			host = net.JoinHostPort(host, port)
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
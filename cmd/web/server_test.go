@@ -0,0 +1,40 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectToHTTPS(t *testing.T) {
+	var tests = []struct {
+		name     string
+		tlsAddr  string
+		url      string
+		expected string
+	}{
+		{"default port", ":443", "http://example.com/rooms?x=1", "https://example.com/rooms?x=1"},
+		{"other port", ":8443", "http://example.com:8080/search-availability", "https://example.com:8443/search-availability"},
+		{"host in addr", "127.0.0.1:8443", "http://localhost:8080/", "https://localhost:8443/"},
+	}
+
+	for _, e := range tests {
+		req := httptest.NewRequest("POST", e.url, nil)
+		rr := httptest.NewRecorder()
+		redirectToHTTPS(e.tlsAddr).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusPermanentRedirect {
+			t.Errorf("%s: expected status %d but got %d", e.name, http.StatusPermanentRedirect, rr.Code)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expected {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expected, loc)
+		}
+	}
+}
+
+func TestNewServer(t *testing.T) {
+	srv := newServer(":0", http.NotFoundHandler())
+	if srv.ReadHeaderTimeout == 0 || srv.ReadTimeout == 0 || srv.WriteTimeout == 0 || srv.IdleTimeout == 0 {
+		t.Errorf("server timeouts are not all set: %+v", srv)
+	}
+}
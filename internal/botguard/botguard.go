@@ -0,0 +1,159 @@
+package botguard
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/encryption"
+)
+
+// Form fields the guard adds to a protected form
+const (
+	// FieldToken carries the sealed time the form was served and the challenge
+	FieldToken = "form_token"
+	// FieldAnswer is the answer to the challenge, filled in by the guest or a script
+	FieldAnswer = "challenge_answer"
+	// FieldHoneypot is hidden from people, only bots fill it in
+	FieldHoneypot = "website"
+)
+
+// Reasons a submission is suspicious
+const (
+	ReasonHoneypot    = "honeypot filled in"
+	ReasonBadToken    = "form token missing or invalid"
+	ReasonTooFast     = "form filled in too fast"
+	ReasonExpired     = "form token expired"
+	ReasonWrongAnswer = "challenge not solved"
+	ReasonReused      = "form token already used"
+)
+
+// Guard checks submissions of a form for the signs of a bot. It needs no
+// outside service: what it has to remember between serving and checking a
+// form travels encrypted in the form itself, it only keeps the tokens already
+// used until they expire
+type Guard struct {
+	// Key seals the form token, see encryption.Encrypt. It is only used for
+	// form tokens, see encryption.DeriveKey
+	Key []byte
+	// MinFillTime is how long a person takes at least to fill in the form
+	MinFillTime time.Duration
+	// MaxAge is how long a served form can be submitted
+	MaxAge time.Duration
+	// Challenge is solved by the guest or the browser, nil for none
+	Challenge Challenge
+
+	mu sync.Mutex
+	// used holds the nonces of the tokens checked, until the tokens expire
+	used map[string]time.Time
+}
+
+// New returns a guard that expects at least 3 seconds spent on a form
+// served within the last 2 hours
+func New(key []byte, challenge Challenge) *Guard {
+	return &Guard{
+		Key:         key,
+		MinFillTime: 3 * time.Second,
+		MaxAge:      2 * time.Hour,
+		Challenge:   challenge,
+	}
+}
+
+// Form is what a template needs to render a protected form
+type Form struct {
+	Token string
+	// Kind, Prompt and Public describe the challenge, Kind is empty without one
+	Kind   string
+	Prompt string
+	Public string
+}
+
+// sealed is the content of the form token
+type sealed struct {
+	// Nonce tells tokens apart so each can only be used once
+	Nonce    string    `json:"nonce"`
+	IssuedAt time.Time `json:"issued_at"`
+	Puzzle   Puzzle    `json:"puzzle"`
+}
+
+// Issue returns the fields for a form served at now
+func (g *Guard) Issue(now time.Time) (Form, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return Form{}, err
+	}
+	s := sealed{Nonce: base64.RawURLEncoding.EncodeToString(nonce), IssuedAt: now}
+	if g.Challenge != nil {
+		p, err := g.Challenge.Issue()
+		if err != nil {
+			return Form{}, err
+		}
+		s.Puzzle = p
+	}
+	b, err := json.Marshal(s)
+	if err != nil {
+		return Form{}, err
+	}
+	token, err := encryption.Encrypt(g.Key, string(b))
+	if err != nil {
+		return Form{}, err
+	}
+	return Form{Token: token, Kind: s.Puzzle.Kind, Prompt: s.Puzzle.Prompt, Public: s.Puzzle.Public}, nil
+}
+
+// Check returns why a submission received at now looks like a bot, no
+// reasons means it passed
+func (g *Guard) Check(form url.Values, now time.Time) []string {
+	var reasons []string
+	if form.Get(FieldHoneypot) != "" {
+		reasons = append(reasons, ReasonHoneypot)
+	}
+
+	plain, err := encryption.Decrypt(g.Key, form.Get(FieldToken))
+	var s sealed
+	if err == nil {
+		err = json.Unmarshal([]byte(plain), &s)
+	}
+	if err != nil || s.Nonce == "" {
+		return append(reasons, ReasonBadToken)
+	}
+
+	age := now.Sub(s.IssuedAt)
+	expired := g.MaxAge > 0 && age > g.MaxAge
+	switch {
+	case age < g.MinFillTime:
+		reasons = append(reasons, ReasonTooFast)
+	case expired:
+		reasons = append(reasons, ReasonExpired)
+	}
+	if !expired && !g.use(s.Nonce, s.IssuedAt.Add(g.MaxAge), now) {
+		reasons = append(reasons, ReasonReused)
+	}
+	if g.Challenge != nil && !g.Challenge.Verify(s.Puzzle, form.Get(FieldAnswer)) {
+		reasons = append(reasons, ReasonWrongAnswer)
+	}
+	return reasons
+}
+
+// use records the token with nonce as used until it expires, it reports
+// false when the token was used before
+func (g *Guard) use(nonce string, expires, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.used == nil {
+		g.used = make(map[string]time.Time)
+	}
+	for n, exp := range g.used {
+		if g.MaxAge > 0 && exp.Before(now) {
+			delete(g.used, n)
+		}
+	}
+	if _, ok := g.used[nonce]; ok {
+		return false
+	}
+	g.used[nonce] = expires
+	return true
+}
@@ -0,0 +1,117 @@
+package botguard
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+// solve answers a puzzle the way a guest or the browser would
+func solve(t *testing.T, f Form) string {
+	switch f.Kind {
+	case KindArithmetic:
+		var a, b int
+		if _, err := fmt.Sscanf(f.Prompt, "What is %d + %d?", &a, &b); err != nil {
+			t.Fatal(err)
+		}
+		return strconv.Itoa(a + b)
+	case KindProofOfWork:
+		difficulty, prefix, _ := strings.Cut(f.Public, ":")
+		d, _ := strconv.Atoi(difficulty)
+		for i := 0; ; i++ {
+			if leadingZeroBits(sha256.Sum256([]byte(prefix+strconv.Itoa(i)))) >= d {
+				return strconv.Itoa(i)
+			}
+		}
+	}
+	return ""
+}
+
+func TestGuard_Check(t *testing.T) {
+	served := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name      string
+		challenge Challenge
+		after     time.Duration
+		tamper    func(v url.Values)
+		expected  []string
+	}{
+		{"person", nil, time.Minute, nil, nil},
+		{"honeypot", nil, time.Minute, func(v url.Values) { v.Set(FieldHoneypot, "http://spam") }, []string{ReasonHoneypot}},
+		{"too fast", nil, time.Second, nil, []string{ReasonTooFast}},
+		{"expired", nil, 3 * time.Hour, nil, []string{ReasonExpired}},
+		{"no token", nil, time.Minute, func(v url.Values) { v.Del(FieldToken) }, []string{ReasonBadToken}},
+		{"forged token", nil, time.Minute, func(v url.Values) { v.Set(FieldToken, "AAAA"+v.Get(FieldToken)[4:]) }, []string{ReasonBadToken}},
+		{"arithmetic solved", Arithmetic{}, time.Minute, nil, nil},
+		{"arithmetic wrong", Arithmetic{}, time.Minute, func(v url.Values) { v.Set(FieldAnswer, "100") }, []string{ReasonWrongAnswer}},
+		{"proof of work solved", ProofOfWork{Difficulty: 8}, time.Minute, nil, nil},
+		{"proof of work missing", ProofOfWork{Difficulty: 8}, time.Minute, func(v url.Values) { v.Del(FieldAnswer) }, []string{ReasonWrongAnswer}},
+	}
+
+	for _, e := range tests {
+		g := New(testKey, e.challenge)
+		f, err := g.Issue(served)
+		if err != nil {
+			t.Fatalf("%s: %s", e.name, err)
+		}
+		v := url.Values{}
+		v.Set(FieldToken, f.Token)
+		v.Set(FieldAnswer, solve(t, f))
+		if e.tamper != nil {
+			e.tamper(v)
+		}
+		reasons := g.Check(v, served.Add(e.after))
+		if !reflect.DeepEqual(reasons, e.expected) {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, reasons)
+		}
+	}
+}
+
+func TestGuard_CheckRejectsReuse(t *testing.T) {
+	served := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	g := New(testKey, nil)
+	f, err := g.Issue(served)
+	if err != nil {
+		t.Fatal(err)
+	}
+	v := url.Values{}
+	v.Set(FieldToken, f.Token)
+
+	if reasons := g.Check(v, served.Add(time.Minute)); reasons != nil {
+		t.Fatalf("first use: expected no reasons but got %v", reasons)
+	}
+	if reasons := g.Check(v, served.Add(2*time.Minute)); !reflect.DeepEqual(reasons, []string{ReasonReused}) {
+		t.Errorf("second use: expected %v but got %v", []string{ReasonReused}, reasons)
+	}
+
+	other, _ := g.Issue(served)
+	v.Set(FieldToken, other.Token)
+	if reasons := g.Check(v, served.Add(time.Minute)); reasons != nil {
+		t.Errorf("another token: expected no reasons but got %v", reasons)
+	}
+
+	//used tokens are forgotten once they expire, they are refused as expired
+	g.Check(url.Values{}, served.Add(3*time.Hour))
+	if reasons := g.Check(v, served.Add(3*time.Hour)); !reflect.DeepEqual(reasons, []string{ReasonExpired}) {
+		t.Errorf("after expiry: expected %v but got %v", []string{ReasonExpired}, reasons)
+	}
+}
+
+func TestNewChallenge(t *testing.T) {
+	for _, kind := range []string{"", "none", KindArithmetic, KindProofOfWork} {
+		if _, err := NewChallenge(kind); err != nil {
+			t.Errorf("%q: %s", kind, err)
+		}
+	}
+	if _, err := NewChallenge("captcha"); err == nil {
+		t.Error("expected an error for an unknown challenge")
+	}
+}
@@ -0,0 +1,121 @@
+package botguard
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Challenge kinds
+const (
+	KindArithmetic  = "arithmetic"
+	KindProofOfWork = "proof-of-work"
+)
+
+// Puzzle is one instance of a challenge. Prompt is shown to the guest and
+// Public is handed to a script, Secret only ever leaves the server sealed
+// in the form token
+type Puzzle struct {
+	Kind   string `json:"kind"`
+	Prompt string `json:"prompt,omitempty"`
+	Public string `json:"public,omitempty"`
+	Secret string `json:"secret,omitempty"`
+}
+
+// Challenge is a puzzle that is cheap to check and costs a bot something to
+// solve, it can be replaced by anything that works without outside services
+type Challenge interface {
+	Issue() (Puzzle, error)
+	Verify(p Puzzle, answer string) bool
+}
+
+// NewChallenge returns the built in challenge of a kind, nil for none
+func NewChallenge(kind string) (Challenge, error) {
+	switch kind {
+	case "", "none":
+		return nil, nil
+	case KindArithmetic:
+		return Arithmetic{}, nil
+	case KindProofOfWork:
+		return ProofOfWork{Difficulty: DefaultDifficulty}, nil
+	}
+	return nil, fmt.Errorf("unknown challenge %q", kind)
+}
+
+// Arithmetic asks the guest to add two small numbers
+type Arithmetic struct{}
+
+// Issue returns a new sum
+func (Arithmetic) Issue() (Puzzle, error) {
+	a, err := rand.Int(rand.Reader, big.NewInt(10))
+	if err != nil {
+		return Puzzle{}, err
+	}
+	b, err := rand.Int(rand.Reader, big.NewInt(10))
+	if err != nil {
+		return Puzzle{}, err
+	}
+	return Puzzle{
+		Kind:   KindArithmetic,
+		Prompt: fmt.Sprintf("What is %d + %d?", a.Int64()+1, b.Int64()+1),
+		Secret: strconv.FormatInt(a.Int64()+b.Int64()+2, 10),
+	}, nil
+}
+
+// Verify checks the sum
+func (Arithmetic) Verify(p Puzzle, answer string) bool {
+	return p.Kind == KindArithmetic && p.Secret != "" && strings.TrimSpace(answer) == p.Secret
+}
+
+// DefaultDifficulty takes a browser well under a second
+const DefaultDifficulty = 14
+
+// ProofOfWork makes the browser find a number that, appended to a random
+// prefix, gives a sha-256 hash starting with Difficulty zero bits. People
+// don't see it, but every submission costs a bot that much work
+type ProofOfWork struct {
+	Difficulty int
+}
+
+// Issue returns a new prefix, Public is "<difficulty>:<prefix>"
+func (w ProofOfWork) Issue() (Puzzle, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return Puzzle{}, err
+	}
+	return Puzzle{
+		Kind:   KindProofOfWork,
+		Public: fmt.Sprintf("%d:%s", w.Difficulty, hex.EncodeToString(b)),
+	}, nil
+}
+
+// Verify checks the hash of the prefix and answer, the difficulty comes from
+// the sealed puzzle so changing it doesn't void forms already served
+func (w ProofOfWork) Verify(p Puzzle, answer string) bool {
+	difficulty, prefix, ok := strings.Cut(p.Public, ":")
+	if p.Kind != KindProofOfWork || !ok || answer == "" {
+		return false
+	}
+	d, err := strconv.Atoi(difficulty)
+	if err != nil {
+		return false
+	}
+	return leadingZeroBits(sha256.Sum256([]byte(prefix+answer))) >= d
+}
+
+// leadingZeroBits counts the zero bits at the start of a hash
+func leadingZeroBits(sum [sha256.Size]byte) int {
+	n := 0
+	for _, b := range sum {
+		if b != 0 {
+			return n + bits.LeadingZeros8(b)
+		}
+		n += 8
+	}
+	return n
+}
@@ -0,0 +1,112 @@
+package certreload
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeCert writes a self signed certificate with the given serial number
+func writeCert(t *testing.T, certFile, keyFile string, serial int64, modTime time.Time) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		DNSNames:     []string{"localhost"},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{certFile, keyFile} {
+		if err := os.Chtimes(name, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serial(t *testing.T, r *Reloader) int64 {
+	t.Helper()
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return leaf.SerialNumber.Int64()
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	start := time.Now().Add(-time.Minute)
+	writeCert(t, certFile, keyFile, 1, start)
+
+	r, err := New(certFile, keyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := serial(t, r); got != 1 {
+		t.Fatalf("expected certificate 1, got %d", got)
+	}
+
+	//a half written pair keeps the old certificate
+	err = os.WriteFile(keyFile, []byte("not a key"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Reload(); err == nil {
+		t.Error("expected an error for a broken key")
+	}
+	if got := serial(t, r); got != 1 {
+		t.Errorf("expected certificate 1 after a failed reload, got %d", got)
+	}
+
+	//the watcher picks up the renewed pair
+	writeCert(t, certFile, keyFile, 2, start.Add(30*time.Second))
+	stop := make(chan struct{})
+	defer close(stop)
+	go r.Watch(10*time.Millisecond, stop)
+	deadline := time.Now().Add(2 * time.Second)
+	for serial(t, r) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("the watcher didn't reload the renewed certificate")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestNewMissingFiles(t *testing.T) {
+	_, err := New("missing.pem", "missing-key.pem", nil)
+	if err == nil {
+		t.Error("expected an error for missing files")
+	}
+}
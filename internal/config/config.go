@@ -2,9 +2,18 @@ package config
 
 import (
 	"html/template"
-	"log"
+	"log/slog"
+	"net"
+	"sync"
 
+	"github.com/acceleraterA/go_app_udemy/internal/botguard"
+	"github.com/acceleraterA/go_app_udemy/internal/health"
+	"github.com/acceleraterA/go_app_udemy/internal/loginguard"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/ratelimit"
+	"github.com/acceleraterA/go_app_udemy/internal/secheaders"
+	"github.com/acceleraterA/go_app_udemy/internal/webhooks"
 	scs "github.com/alexedwards/scs/v2"
 )
 
@@ -14,9 +23,49 @@ import (
 type AppConfig struct {
 	UseCache      bool
 	TemplateCache map[string]*template.Template
-	InfoLog       *log.Logger
-	ErrorLog      *log.Logger
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	Webhooks      *webhooks.Dispatcher
+	LoginPolicy   loginguard.Policy
+	// MailSenders counts the goroutines outliving their request that may
+	// still queue mail, MailChan is closed once they are done
+	MailSenders *sync.WaitGroup
+	// Logger is the one structured logger of the application, lines logged
+	// with a request context carry the request id
+	Logger *slog.Logger
+	// Metrics are served to Prometheus on /metrics, scrapers must send
+	// MetricsToken as a bearer token when it is set
+	Metrics      *metrics.Metrics
+	MetricsToken string
+	// Health answers /healthz and /readyz, it reports not ready once the
+	// server starts shutting down
+	Health *health.Checker
+	// EncryptionKey encrypts secrets stored in the database, like totp secrets
+	EncryptionKey []byte
+	// TLSCertFile and TLSKeyFile are served over https when set, cookies are
+	// secure then
+	TLSCertFile string
+	TLSKeyFile  string
+	// TrustedProxies may set X-Forwarded-For, see helpers.ClientIP
+	TrustedProxies []*net.IPNet
+	// RateLimiter limits each client of the route groups in RateLimits
+	RateLimiter *ratelimit.Limiter
+	RateLimits  map[string]ratelimit.Limit
+	// BotGuard holds suspicious reservations for approval, nil lets all through
+	BotGuard *botguard.Guard
+	// HousekeepingEmail gets the housekeeping schedule every morning, none is
+	// sent when it's empty
+	HousekeepingEmail string
+	// PreArrivalDays is how many days before their arrival guests get a
+	// reminder, none is sent when it's 0
+	PreArrivalDays int
+	// AppBaseURL is the scheme and host links in emails point to, like
+	// https://example.com, never taken from a request
+	AppBaseURL string
+	// ReviewURL is where the thank-you email after a stay asks guests to
+	// leave a review, the email has no link when it's empty
+	ReviewURL string
+	// SecurityHeaders configures the Content-Security-Policy and other security headers
+	SecurityHeaders secheaders.Config
 }
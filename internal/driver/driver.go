@@ -2,6 +2,7 @@ package driver
 
 import (
 	"database/sql"
+	"os"
 	"time"
 
 	_ "github.com/jackc/pgx/v5"
@@ -20,12 +21,22 @@ const maxOpenDbConn = 10
 const maxIdleDbConn = 5
 const maxDbLifetime = 5 * time.Minute
 
+// defaultDSN is the development database, used when DATABASE_URL is not set
+const defaultDSN = "host=localhost port=5432 dbname=bookings user=postgres password="
+
+// DSN returns the database to connect to, DATABASE_URL or the development one
+func DSN() string {
+	if dsn := os.Getenv("DATABASE_URL"); dsn != "" {
+		return dsn
+	}
+	return defaultDSN
+}
+
 // ConnectSQL creates database pool for postgres
 func ConnectSQL(dsn string) (*DB, error) {
 	d, err := NewDatabase(dsn)
 	if err != nil {
-		panic(err)
-
+		return nil, err
 	}
 	d.SetMaxIdleConns(maxIdleDbConn)
 	d.SetConnMaxLifetime(maxDbLifetime)
@@ -54,6 +65,7 @@ func NewDatabase(dsn string) (*sql.DB, error) {
 		return nil, err
 	}
 	if err = db.Ping(); err != nil {
+		db.Close()
 		return nil, err
 	}
 	return db, nil
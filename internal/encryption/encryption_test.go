@@ -0,0 +1,55 @@
+package encryption
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestEncryptDecrypt(t *testing.T) {
+	key, err := ParseKey(strings.Repeat("ab", KeySize))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := Encrypt(key, "JBSWY3DPEHPK3PXP")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(sealed, "JBSWY3DPEHPK3PXP") {
+		t.Error("ciphertext contains the plaintext")
+	}
+	plain, err := Decrypt(key, sealed)
+	if err != nil || plain != "JBSWY3DPEHPK3PXP" {
+		t.Errorf("expected the plaintext back, got %q, %v", plain, err)
+	}
+
+	other, _ := ParseKey(strings.Repeat("cd", KeySize))
+	if _, err := Decrypt(other, sealed); err == nil {
+		t.Error("decrypted with the wrong key")
+	}
+	if _, err := Decrypt(key, sealed[:len(sealed)-4]+"AAAA"); err == nil {
+		t.Error("decrypted a tampered ciphertext")
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Error("accepted a short key")
+	}
+}
+
+func TestDeriveKey(t *testing.T) {
+	secret, _ := ParseKey(strings.Repeat("ab", KeySize))
+	a, err := DeriveKey(secret, "botguard")
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, _ := DeriveKey(secret, "botguard")
+	other, _ := DeriveKey(secret, "other")
+	if len(a) != KeySize {
+		t.Errorf("expected a %d byte key, got %d", KeySize, len(a))
+	}
+	if !bytes.Equal(a, again) {
+		t.Error("the same label gave different keys")
+	}
+	if bytes.Equal(a, other) || bytes.Equal(a, secret) {
+		t.Error("the derived key is not separate")
+	}
+}
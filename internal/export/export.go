@@ -0,0 +1,184 @@
+// Package export writes reservations as CSV or JSON one row at a time, for
+// the admin download and the admin command
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// Format is the file format of an export
+type Format string
+
+const (
+	CSV  Format = "csv"
+	JSON Format = "json"
+)
+
+// ParseFormat returns the format named s, CSV when s is empty
+func ParseFormat(s string) (Format, error) {
+	switch strings.ToLower(s) {
+	case "", "csv":
+		return CSV, nil
+	case "json":
+		return JSON, nil
+	}
+	return "", fmt.Errorf("unknown export format %q, use csv or json", s)
+}
+
+// ContentType returns the media type of the format
+func (f Format) ContentType() string {
+	if f == JSON {
+		return "application/json"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// dateLayout is how dates are exported
+const dateLayout = "2006-01-02"
+
+// Row is a reservation as exported. Rooms have no price in the database, so
+// there is no price column
+type Row struct {
+	ID        int    `json:"id"`
+	RoomID    int    `json:"room_id"`
+	Room      string `json:"room"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	Nights    int    `json:"nights"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+}
+
+// header is the first line of a CSV export, in the order of Row
+var header = []string{"id", "room_id", "room", "first_name", "last_name", "email", "phone",
+	"start_date", "end_date", "nights", "status", "created_at"}
+
+// NewRow returns the exported fields of a reservation
+func NewRow(r models.Reservation) Row {
+	return Row{
+		ID:        r.ID,
+		RoomID:    r.RoomID,
+		Room:      r.Room.RoomName,
+		FirstName: r.FirstName,
+		LastName:  r.LastName,
+		Email:     r.Email,
+		Phone:     r.Phone,
+		StartDate: r.StartDate.Format(dateLayout),
+		EndDate:   r.EndDate.Format(dateLayout),
+		Nights:    Nights(r.StartDate, r.EndDate),
+		Status:    r.Status,
+		CreatedAt: r.CreatedAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// Nights returns the number of nights from arrival to departure
+func Nights(start, end time.Time) int {
+	y, m, d := start.Date()
+	from := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	y, m, d = end.Date()
+	to := time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+	return int(to.Sub(from).Hours() / 24)
+}
+
+// Escape defuses a CSV field a spreadsheet would run as a formula, guests
+// type their own names, by putting a quote before a leading =, +, -, @, tab
+// or carriage return
+func Escape(s string) string {
+	if s != "" && strings.ContainsRune("=+-@\t\r", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// Writer writes the rows of an export as they come, Flush pushes the rows
+// written so far to the underlying writer and Close ends the export
+type Writer interface {
+	Write(Row) error
+	Flush() error
+	Close() error
+}
+
+// NewWriter returns a Writer of the format f to w
+func NewWriter(w io.Writer, f Format) Writer {
+	if f == JSON {
+		return &jsonWriter{w: w}
+	}
+	return &csvWriter{w: csv.NewWriter(w)}
+}
+
+type csvWriter struct {
+	w      *csv.Writer
+	headed bool
+}
+
+func (c *csvWriter) Write(r Row) error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.w.Write([]string{strconv.Itoa(r.ID), strconv.Itoa(r.RoomID), Escape(r.Room),
+		Escape(r.FirstName), Escape(r.LastName), Escape(r.Email), Escape(r.Phone),
+		r.StartDate, r.EndDate, strconv.Itoa(r.Nights), Escape(r.Status), r.CreatedAt})
+}
+
+func (c *csvWriter) writeHeader() error {
+	if c.headed {
+		return nil
+	}
+	c.headed = true
+	return c.w.Write(header)
+}
+
+func (c *csvWriter) Flush() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	if err := c.writeHeader(); err != nil {
+		return err
+	}
+	return c.Flush()
+}
+
+// jsonWriter writes a JSON list, one row per line
+type jsonWriter struct {
+	w    io.Writer
+	rows int
+}
+
+func (j *jsonWriter) Write(r Row) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	sep := ",\n"
+	if j.rows == 0 {
+		sep = "[\n"
+	}
+	j.rows++
+	_, err = io.WriteString(j.w, sep+string(b))
+	return err
+}
+
+func (j *jsonWriter) Flush() error { return nil }
+
+func (j *jsonWriter) Close() error {
+	end := "\n]\n"
+	if j.rows == 0 {
+		end = "[]\n"
+	}
+	_, err := io.WriteString(j.w, end)
+	return err
+}
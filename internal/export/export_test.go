@@ -0,0 +1,121 @@
+package export
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+func TestEscape(t *testing.T) {
+	var tests = []struct {
+		in, want string
+	}{
+		{"Smith", "Smith"},
+		{"", ""},
+		{"=HYPERLINK(\"http://x\")", "'=HYPERLINK(\"http://x\")"},
+		{"+1 555 0100", "'+1 555 0100"},
+		{"-2+3", "'-2+3"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"\tcmd", "'\tcmd"},
+		{"a=b", "a=b"},
+	}
+	for _, e := range tests {
+		if got := Escape(e.in); got != e.want {
+			t.Errorf("Escape(%q) = %q, want %q", e.in, got, e.want)
+		}
+	}
+}
+
+func TestNights(t *testing.T) {
+	start := time.Date(2050, 3, 28, 0, 0, 0, 0, time.UTC)
+	if n := Nights(start, start.AddDate(0, 0, 4)); n != 4 {
+		t.Errorf("got %d nights, want 4", n)
+	}
+	if n := Nights(start, start); n != 0 {
+		t.Errorf("got %d nights, want 0", n)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for in, want := range map[string]Format{"": CSV, "csv": CSV, "JSON": JSON} {
+		if got, err := ParseFormat(in); err != nil || got != want {
+			t.Errorf("%q: got %q, %v", in, got, err)
+		}
+	}
+	if _, err := ParseFormat("xlsx"); err == nil {
+		t.Error("xlsx: expected an error")
+	}
+}
+
+var testReservation = models.Reservation{
+	ID:        1,
+	FirstName: "=cmd|' /C calc'!A0",
+	LastName:  "Smith",
+	Email:     "john@smith.com",
+	Phone:     "+1 555 0100",
+	StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+	EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+	RoomID:    1,
+	Status:    "confirmed",
+	Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+}
+
+func TestCSVWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewWriter(&buf, CSV)
+	if err := w.Write(NewRow(testReservation)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || len(records[1]) != len(header) {
+		t.Fatalf("unexpected records %q", records)
+	}
+	row := records[1]
+	if row[3] != "'=cmd|' /C calc'!A0" || row[6] != "'+1 555 0100" || row[9] != "2" {
+		t.Errorf("unexpected row %q", row)
+	}
+
+	buf.Reset()
+	if err := NewWriter(&buf, CSV).Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "id,room_id,room,first_name,last_name,email,phone,start_date,end_date,nights,status,created_at\n" {
+		t.Errorf("empty export is %q, want the header", buf.String())
+	}
+}
+
+func TestJSONWriter(t *testing.T) {
+	for _, n := range []int{0, 1, 3} {
+		var buf bytes.Buffer
+		w := NewWriter(&buf, JSON)
+		for i := 0; i < n; i++ {
+			if err := w.Write(NewRow(testReservation)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		var rows []Row
+		if err := json.Unmarshal(buf.Bytes(), &rows); err != nil {
+			t.Fatalf("%d rows: not a JSON list: %s", n, buf.String())
+		}
+		if len(rows) != n {
+			t.Errorf("got %d rows, want %d", len(rows), n)
+		}
+		//JSON isn't opened by spreadsheets, it is not escaped
+		if n > 0 && rows[0].FirstName != testReservation.FirstName {
+			t.Errorf("unexpected row %+v", rows[0])
+		}
+	}
+}
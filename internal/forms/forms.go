@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/asaskevich/govalidator"
 )
@@ -61,3 +62,12 @@ func (f *Form) IsEmail(field string) {
 		f.Errors.Add(field, "Invalid email address.")
 	}
 }
+
+// MaxLength checks for string maximum length in characters
+func (f *Form) MaxLength(field string, length int) bool {
+	if utf8.RuneCountInString(f.Get(field)) > length {
+		f.Errors.Add(field, fmt.Sprintf("this field must be at most %d characters long", length))
+		return false
+	}
+	return true
+}
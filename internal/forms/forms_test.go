@@ -84,6 +84,27 @@ func TestForm_MinLength(t *testing.T) {
 	}
 }
 
+func TestForm_MaxLength(t *testing.T) {
+	postdata := url.Values{}
+	form := New(postdata)
+	if !form.MaxLength("a", 3) || !form.Valid() {
+		t.Error("form shows maxlength error for non-existent field")
+	}
+
+	postdata = url.Values{}
+	postdata.Add("a", "héé")
+	form = New(postdata)
+	if !form.MaxLength("a", 3) {
+		t.Error("field of 3 characters is longer than 3")
+	}
+	if form.MaxLength("a", 2) {
+		t.Error("field length larger than maxlength but got true")
+	}
+	if form.Errors.Get("a") == "" {
+		t.Error("field has error msg but return no error")
+	}
+}
+
 func TestForm_IsEmail(t *testing.T) {
 	postdata := url.Values{}
 	form := New(postdata)
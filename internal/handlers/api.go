@@ -0,0 +1,192 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+type apiRoom struct {
+	ID       int    `json:"id"`
+	RoomName string `json:"room_name"`
+}
+
+type apiAvailabilityResponse struct {
+	StartDate string    `json:"start_date"`
+	EndDate   string    `json:"end_date"`
+	Rooms     []apiRoom `json:"rooms"`
+}
+
+type apiReservationRequest struct {
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+}
+
+type apiReservation struct {
+	ID        int    `json:"id"`
+	FirstName string `json:"first_name"`
+	LastName  string `json:"last_name"`
+	Email     string `json:"email"`
+	Phone     string `json:"phone"`
+	StartDate string `json:"start_date"`
+	EndDate   string `json:"end_date"`
+	RoomID    int    `json:"room_id"`
+	RoomName  string `json:"room_name"`
+	Status    string `json:"status"`
+}
+
+func toAPIReservation(res models.Reservation) apiReservation {
+	return apiReservation{
+		ID:        res.ID,
+		FirstName: res.FirstName,
+		LastName:  res.LastName,
+		Email:     res.Email,
+		Phone:     res.Phone,
+		StartDate: res.StartDate.Format("2006-01-02"),
+		EndDate:   res.EndDate.Format("2006-01-02"),
+		RoomID:    res.RoomID,
+		RoomName:  res.Room.RoomName,
+		Status:    res.Status,
+	}
+}
+
+// parseDateRange reads start and end from the query string
+func parseDateRange(q url.Values) (time.Time, time.Time, bool) {
+	layout := "2006-01-02"
+	start, err := time.Parse(layout, q.Get("start"))
+	if err != nil {
+		return start, start, false
+	}
+	end, err := time.Parse(layout, q.Get("end"))
+	if err != nil || !end.After(start) {
+		return start, end, false
+	}
+	return start, end, true
+}
+
+// APIAvailability returns the rooms that are free for a date range
+func (m *Repository) APIAvailability(w http.ResponseWriter, r *http.Request) {
+	start, end, ok := parseDateRange(r.URL.Query())
+	if !ok {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "start and end must be yyyy-mm-dd dates with end after start")
+		return
+	}
+	rooms, err := m.DB.SearchAvalibilityForAllRooms(r.Context(), start, end)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	m.App.Metrics.Searches.Inc("api")
+
+	resp := apiAvailabilityResponse{
+		StartDate: start.Format("2006-01-02"),
+		EndDate:   end.Format("2006-01-02"),
+		Rooms:     []apiRoom{},
+	}
+	for _, room := range rooms {
+		resp.Rooms = append(resp.Rooms, apiRoom{ID: room.ID, RoomName: room.RoomName})
+	}
+	helpers.WriteJSON(w, http.StatusOK, resp)
+}
+
+// APIPostReservation books a room for a partner and sends the usual notifications
+func (m *Repository) APIPostReservation(w http.ResponseWriter, r *http.Request) {
+	var req apiReservationRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		helpers.ErrorJSON(w, http.StatusBadRequest, "request body must be a JSON reservation")
+		return
+	}
+
+	//validate with the same rules as the reservation form
+	form := forms.New(url.Values{
+		"first_name": {req.FirstName},
+		"last_name":  {req.LastName},
+		"email":      {req.Email},
+	})
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 2)
+	form.IsEmail("email")
+	start, end, ok := parseDateRange(url.Values{"start": {req.StartDate}, "end": {req.EndDate}})
+	if !ok {
+		form.Errors.Add("end_date", "start_date and end_date must be yyyy-mm-dd dates with end_date after start_date")
+	}
+	if !form.Valid() {
+		helpers.WriteJSON(w, http.StatusBadRequest, helpers.APIError{
+			Error:  "invalid reservation",
+			Fields: form.Errors,
+		})
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(r.Context(), req.RoomID)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusNotFound, "room not found")
+		return
+	}
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), start, end, req.RoomID)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	if !available {
+		helpers.ErrorJSON(w, http.StatusConflict, "room is not available for these dates")
+		return
+	}
+
+	reservation := models.Reservation{
+		FirstName: req.FirstName,
+		LastName:  req.LastName,
+		Email:     req.Email,
+		Phone:     req.Phone,
+		StartDate: start,
+		EndDate:   end,
+		RoomID:    req.RoomID,
+		Status:    models.ReservationConfirmed,
+		Room:      room,
+	}
+	reservation.ID, err = m.DB.InsertReservation(r.Context(), m.actor(r), reservation)
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "can't insert reservation")
+		return
+	}
+	err = m.DB.InsertRoomRestriction(r.Context(), m.actor(r), models.RoomRestriction{
+		StartDate:     reservation.StartDate,
+		EndDate:       reservation.EndDate,
+		RoomID:        reservation.RoomID,
+		ReservationID: reservation.ID,
+		RestrictionID: 1,
+	})
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "can't insert room restriction")
+		return
+	}
+
+	m.sendReservationEmails(r, reservation)
+	m.App.Metrics.ReservationsCreated.Inc()
+	m.publish(r, models.EventReservationCreated, reservation)
+	helpers.WriteJSON(w, http.StatusCreated, toAPIReservation(reservation))
+}
+
+// APIReservations lists all reservations for admin integrations
+func (m *Repository) APIReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ErrorJSON(w, http.StatusInternalServerError, "Error connecting to database")
+		return
+	}
+	out := []apiReservation{}
+	for _, res := range reservations {
+		out = append(out, toAPIReservation(res))
+	}
+	helpers.WriteJSON(w, http.StatusOK, out)
+}
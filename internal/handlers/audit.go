@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+)
+
+// auditPageSize is how many entries the audit viewer shows
+const auditPageSize = 200
+
+// actor returns who is making the request for the audit log: the api key,
+// the logged in user or an anonymous guest
+func (m *Repository) actor(r *http.Request) models.Actor {
+	if key, ok := helpers.APIKeyFromContext(r.Context()); ok {
+		return models.Actor{Type: models.ActorAPIKey, ID: key.ID, Label: key.Name}
+	}
+	if m.App.Session.GetInt(r.Context(), "user_id") != 0 {
+		if user, err := m.currentUser(r); err == nil {
+			return userActor(user)
+		}
+	}
+	return models.Actor{Type: models.ActorGuest}
+}
+
+// userActor returns the audit actor for a user, guest accounts are logged as guests
+func userActor(user models.User) models.Actor {
+	if user.IsGuest() {
+		return models.Actor{Type: models.ActorGuest, ID: user.ID, Label: user.Email}
+	}
+	return models.Actor{Type: models.ActorUser, ID: user.ID, Label: user.Email}
+}
+
+// tokenActor returns the actor for a change made with a mailed token, which
+// only the user it was issued to can have
+func (m *Repository) tokenActor(r *http.Request, id int) models.Actor {
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		return models.Actor{Type: models.ActorUser, ID: id}
+	}
+	return userActor(user)
+}
+
+// AdminAuditLog shows the audit log, filtered by the query string
+func (m *Repository) AdminAuditLog(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := forms.New(q)
+	filter := models.AuditFilter{
+		ActorType:  q.Get("actor_type"),
+		Action:     q.Get("action"),
+		EntityType: q.Get("entity_type"),
+		Limit:      auditPageSize,
+	}
+	if v := q.Get("actor_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			form.Errors.Add("actor_id", "Must be a number")
+		}
+		filter.ActorID = id
+	}
+	if v := q.Get("entity_id"); v != "" {
+		id, err := strconv.Atoi(v)
+		if err != nil {
+			form.Errors.Add("entity_id", "Must be a number")
+		}
+		filter.EntityID = id
+	}
+	if v := q.Get("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("from", "Use the format YYYY-MM-DD")
+		}
+		filter.From = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("to", "Use the format YYYY-MM-DD")
+		}
+		//the to date is inclusive
+		filter.To = to.AddDate(0, 0, 1)
+	}
+
+	var entries []models.AuditEntry
+	if form.Valid() {
+		var err error
+		entries, err = m.DB.AuditLog(r.Context(), filter)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	data := make(map[string]interface{})
+	data["entries"] = entries
+	data["actor_types"] = []string{models.ActorUser, models.ActorAPIKey, models.ActorGuest, models.ActorOperator}
+	data["actions"] = []string{models.AuditCreate, models.AuditUpdate, models.AuditCancel, models.AuditDelete,
+		models.AuditPasswordChange, models.AuditVerifyEmail, models.AuditReply, models.AuditEnableTOTP, models.AuditDisableTOTP}
+	data["entity_types"] = []string{models.EntityReservation, models.EntityRoomRestriction, models.EntityRoom, models.EntityUser,
+		models.EntityHousekeepingTask, models.EntityContactMessage, models.EntityRoleSettings}
+
+	render.Template(w, "admin-audit-log.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/go-chi/chi"
+)
+
+// Longest subject, message and reply the contact forms take, in characters
+const (
+	maxContactSubject = 200
+	maxContactMessage = 5000
+)
+
+// Contact renders the contact page and displays form
+func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
+	var msg models.ContactMessage
+	if guest, ok := m.currentGuest(r); ok {
+		msg.Name = guest.FirstName + " " + guest.LastName
+		msg.Email = guest.Email
+	}
+	m.renderContact(w, r, forms.New(nil), msg)
+}
+
+func (m *Repository) renderContact(w http.ResponseWriter, r *http.Request, form *forms.Form, msg models.ContactMessage) {
+	data := make(map[string]interface{})
+	data["message"] = msg
+	if err := m.addBotGuard(data); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	render.Template(w, "contact.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// PostContact stores a message sent with the contact form and notifies the
+// owner, messages that look like spam are filed as spam without a notification
+func (m *Repository) PostContact(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("name", "email", "message")
+	form.IsEmail("email")
+	form.MaxLength("subject", maxContactSubject)
+	form.MaxLength("message", maxContactMessage)
+
+	//name and subject end up in mail headers, they are kept on one line
+	msg := models.ContactMessage{
+		Name:    strings.Join(strings.Fields(form.Get("name")), " "),
+		Email:   strings.TrimSpace(form.Get("email")),
+		Subject: strings.Join(strings.Fields(form.Get("subject")), " "),
+		Message: strings.TrimSpace(form.Get("message")),
+		Status:  models.ContactInbox,
+	}
+	if !form.Valid() {
+		m.renderContact(w, r, form, msg)
+		return
+	}
+
+	//the sender is told the same either way so bots can't learn what gave
+	//them away
+	msg.HoldReason = m.holdReason(r)
+	if msg.HoldReason != "" {
+		msg.Status = models.ContactSpam
+	}
+	msg.ID, err = m.DB.InsertContactMessage(r.Context(), msg)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if msg.Status == models.ContactSpam {
+		m.App.Logger.InfoContext(r.Context(), "contact message filed as spam", "contact_message_id", msg.ID, "reason", msg.HoldReason)
+	} else {
+		m.sendMail(r.Context(), models.MailData{
+			To:      "owner@here.com",
+			From:    "me@here.com",
+			Subject: "New message from " + msg.Name,
+			Content: contactNotification(msg),
+		})
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Thanks for your message, we'll get back to you soon")
+	http.Redirect(w, r, "/contact", http.StatusSeeOther)
+}
+
+// contactNotification returns the html body of the mail telling the owner
+// about a new message
+func contactNotification(msg models.ContactMessage) string {
+	return fmt.Sprintf(`
+	<strong>New Message</strong><br>
+	From: %s &lt;%s&gt;<br>
+	Subject: %s<br>
+	%s<br>
+	Read and reply to it in the admin inbox.
+	`, template.HTMLEscapeString(msg.Name), template.HTMLEscapeString(msg.Email),
+		template.HTMLEscapeString(msg.Subject), paragraphs(msg.Message))
+}
+
+// paragraphs escapes plain text for an html mail, keeping its line breaks
+func paragraphs(s string) string {
+	s = template.HTMLEscapeString(strings.ReplaceAll(s, "\r\n", "\n"))
+	return strings.ReplaceAll(s, "\n", "<br>\n")
+}
+
+// validContactStatus reports whether status is one of the inbox folders
+func validContactStatus(status string) bool {
+	for _, s := range models.ContactStatuses {
+		if s == status {
+			return true
+		}
+	}
+	return false
+}
+
+// AdminContactMessages lists the contact messages of a folder, the inbox by
+// default
+func (m *Repository) AdminContactMessages(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status == "" {
+		status = models.ContactInbox
+	}
+	if !validContactStatus(status) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	messages, err := m.DB.ContactMessages(r.Context(), status)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["messages"] = messages
+	data["statuses"] = models.ContactStatuses
+	stringMap := make(map[string]string)
+	stringMap["status"] = status
+	render.Template(w, "admin-messages.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	}, r)
+}
+
+// contactMessage returns the contact message in the url, or writes the error
+func (m *Repository) contactMessage(w http.ResponseWriter, r *http.Request) (models.ContactMessage, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.ContactMessage{}, false
+	}
+	msg, err := m.DB.GetContactMessageByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.ContactMessage{}, false
+	}
+	return msg, true
+}
+
+// AdminShowContactMessage shows a contact message with its replies and the
+// reply form, and marks it read
+func (m *Repository) AdminShowContactMessage(w http.ResponseWriter, r *http.Request) {
+	msg, ok := m.contactMessage(w, r)
+	if !ok {
+		return
+	}
+	if msg.ReadAt.IsZero() {
+		if err := m.DB.MarkContactMessageRead(r.Context(), msg.ID); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	m.renderContactMessage(w, r, forms.New(nil), msg)
+}
+
+func (m *Repository) renderContactMessage(w http.ResponseWriter, r *http.Request, form *forms.Form, msg models.ContactMessage) {
+	data := make(map[string]interface{})
+	data["message"] = msg
+	data["statuses"] = models.ContactStatuses
+	render.Template(w, "admin-message-show.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// AdminPostContactReply mails a reply to the sender of a contact message
+func (m *Repository) AdminPostContactReply(w http.ResponseWriter, r *http.Request) {
+	msg, ok := m.contactMessage(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("body")
+	form.MaxLength("body", maxContactMessage)
+	if !form.Valid() {
+		m.renderContactMessage(w, r, form, msg)
+		return
+	}
+
+	reply := models.ContactReply{ContactMessageID: msg.ID, Body: strings.TrimSpace(form.Get("body"))}
+	if _, err := m.DB.InsertContactReply(r.Context(), m.actor(r), reply); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	subject := "Your message to us"
+	if msg.Subject != "" {
+		subject = "Re: " + msg.Subject
+	}
+	m.sendMail(r.Context(), models.MailData{
+		To:       msg.Email,
+		From:     "me@here.com",
+		Subject:  subject,
+		Content:  contactReplyMail(msg, reply),
+		Template: "basic.html",
+	})
+
+	m.App.Session.Put(r.Context(), "flash", "Reply sent to "+msg.Email)
+	http.Redirect(w, r, fmt.Sprintf("/admin/messages/%d", msg.ID), http.StatusSeeOther)
+}
+
+// contactReplyMail returns the html body of a reply, quoting the message
+func contactReplyMail(msg models.ContactMessage, reply models.ContactReply) string {
+	return fmt.Sprintf(`
+	Dear %s, <br>
+	%s<br>
+	<br>
+	On %s you wrote:<br>
+	<blockquote>%s</blockquote>
+	`, template.HTMLEscapeString(msg.Name), paragraphs(reply.Body),
+		msg.CreatedAt.Format("January 2, 2006"), paragraphs(msg.Message))
+}
+
+// AdminPostContactMessageStatus moves a contact message to the archive, the
+// inbox or spam
+func (m *Repository) AdminPostContactMessageStatus(w http.ResponseWriter, r *http.Request) {
+	msg, ok := m.contactMessage(w, r)
+	if !ok {
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	status := r.Form.Get("status")
+	if !validContactStatus(status) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if err := m.DB.SetContactMessageStatus(r.Context(), m.actor(r), msg.ID, status); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Message moved to %s", status))
+	http.Redirect(w, r, "/admin/messages?status="+msg.Status, http.StatusSeeOther)
+}
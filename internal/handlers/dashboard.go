@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/reports"
+)
+
+// maxReportDays is the longest period the dashboard reports on
+const maxReportDays = 366
+
+// AdminDashboard shows the occupancy of the rooms from the from to the to
+// date, this month by default, and today's arrivals and departures
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	form := forms.New(q)
+	today := dateOf(time.Now())
+	period := reports.Period{Start: time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)}
+	period.End = period.Start.AddDate(0, 1, 0)
+	if v := q.Get("from"); v != "" {
+		from, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("from", "Use the format YYYY-MM-DD")
+		}
+		period.Start = from
+	}
+	if v := q.Get("to"); v != "" {
+		to, err := time.Parse("2006-01-02", v)
+		if err != nil {
+			form.Errors.Add("to", "Use the format YYYY-MM-DD")
+		}
+		//the to date is the last night reported
+		period.End = to.AddDate(0, 0, 1)
+	}
+	if days := period.Days(); form.Valid() && (days <= 0 || days > maxReportDays) {
+		form.Errors.Add("to", fmt.Sprintf("Must be after from and at most %d days later", maxReportDays-1))
+	}
+	if !form.Valid() {
+		period.Start = time.Date(today.Year(), today.Month(), 1, 0, 0, 0, 0, time.UTC)
+		period.End = period.Start.AddDate(0, 1, 0)
+	}
+
+	rooms, err := m.DB.AllRooms(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	restrictions, err := m.DB.RoomRestrictionsBetween(r.Context(), period.Start, period.End)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	//the day before too, for the stays ending today
+	around, err := m.DB.RoomRestrictionsBetween(r.Context(), today.AddDate(0, 0, -1), today.AddDate(0, 0, 1))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	stats := reports.Compute(period, rooms, restrictions)
+	movements := reports.Today(today, around)
+	names := make(map[int]string)
+	for _, room := range rooms {
+		names[room.ID] = room.RoomName
+	}
+	for _, list := range [][]models.RoomRestriction{movements.Arrivals, movements.Departures} {
+		for i := range list {
+			list[i].Room.RoomName = names[list[i].RoomID]
+		}
+	}
+
+	var bars []reports.Bar
+	for _, rs := range append(stats.Rooms, stats.Total) {
+		bars = append(bars, reports.Bar{
+			Label: rs.Room.RoomName,
+			Value: rs.Occupancy,
+			Text:  fmt.Sprintf("%.0f%%", rs.Percent()),
+		})
+	}
+
+	if !form.Has("from") {
+		form.Set("from", period.Start.Format("2006-01-02"))
+	}
+	if !form.Has("to") {
+		form.Set("to", period.End.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+	data := make(map[string]interface{})
+	data["stats"] = stats
+	data["movements"] = movements
+	data["occupancy_chart"] = reports.BarChart("Occupancy per room", bars, 1)
+	data["nights_chart"] = reports.DailyChart("Rooms sold and blocked per night", stats.Days, len(rooms))
+
+	render.Template(w, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// dateOf returns the date of t at midnight UTC, like dates from the database
+func dateOf(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
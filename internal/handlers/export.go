@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/export"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// exportWriteTimeout replaces the server's write timeout for an export,
+// exportFlushRows is how many rows are sent at a time
+const (
+	exportWriteTimeout = 10 * time.Minute
+	exportFlushRows    = 100
+)
+
+// AdminExportReservations streams the reservations arriving from the from to
+// the to date as CSV, or JSON with format=json. The rows go out as they are
+// read, an error after the first one aborts the response so a truncated
+// file can't pass for a complete one
+func (m *Repository) AdminExportReservations(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	from, errFrom := time.Parse("2006-01-02", q.Get("from"))
+	to, errTo := time.Parse("2006-01-02", q.Get("to"))
+	format, errFormat := export.ParseFormat(q.Get("format"))
+	if errFrom != nil || errTo != nil || errFormat != nil || to.Before(from) {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	actor := m.actor(r)
+	m.App.Logger.InfoContext(r.Context(), "reservations export",
+		"actor_type", actor.Type, "actor_id", actor.ID, "from", q.Get("from"), "to", q.Get("to"), "format", format)
+
+	rc := http.NewResponseController(w)
+	//an error means the writer has no deadline to extend
+	_ = rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	filename := fmt.Sprintf("reservations-%s-%s.%s", q.Get("from"), q.Get("to"), format)
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	w.Header().Set("Cache-Control", "no-store")
+
+	out := export.NewWriter(w, format)
+	rows := 0
+	err := m.DB.EachReservationArriving(r.Context(), from, to, func(res models.Reservation) error {
+		if err := out.Write(export.NewRow(res)); err != nil {
+			return err
+		}
+		rows++
+		if rows%exportFlushRows != 0 {
+			return nil
+		}
+		if err := out.Flush(); err != nil {
+			return err
+		}
+		_ = rc.Flush()
+		return nil
+	})
+	if err == nil {
+		err = out.Close()
+	}
+	if err != nil && rows == 0 {
+		w.Header().Del("Content-Disposition")
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "reservations export aborted", "rows", rows, "error", err)
+		panic(http.ErrAbortHandler)
+	}
+}
@@ -0,0 +1,99 @@
+package handlers
+
+import (
+	"context"
+	"html/template"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// postStayDays is how long after their departure guests may still get the
+// thank-you email, so days missed while the site was down are caught up
+const postStayDays = 7
+
+// guestMailSubjects are the subjects of the guest emails by kind
+var guestMailSubjects = map[string]string{
+	models.GuestEmailPreArrival: "Your stay is coming up",
+	models.GuestEmailPostStay:   "Thank you for staying with us",
+}
+
+// guestMailTemplates are the bodies of the guest emails by kind, sent in
+// basic.html
+var guestMailTemplates = template.Must(template.New("guest-mail").Parse(`
+{{define "pre_arrival"}}
+<strong>Your stay is coming up</strong><br>
+Dear {{.Reservation.FirstName}}, <br>
+We look forward to welcoming you in the {{.Reservation.Room.RoomName}}
+from {{.Reservation.StartDate.Format "Monday, January 2"}} to {{.Reservation.EndDate.Format "Monday, January 2, 2006"}}.<br>
+Check-in is from 3pm. Just reply to this email if you have any questions before you arrive.
+{{end}}
+{{define "post_stay"}}
+<strong>Thank you for staying with us</strong><br>
+Dear {{.Reservation.FirstName}}, <br>
+Thank you for staying in the {{.Reservation.Room.RoomName}}, we hope you enjoyed it.<br>
+{{if .ReviewURL}}Would you tell others about your stay? <a href="{{.ReviewURL}}">Leave us a review</a>.<br>{{end}}
+We hope to see you again soon.
+{{end}}
+`))
+
+// SendGuestEmails sends the pre-arrival reminders to guests arriving in the
+// next PreArrivalDays days and the thank-you emails to guests who left in the
+// last week, each at most once per reservation
+func (m *Repository) SendGuestEmails(ctx context.Context, now time.Time) error {
+	today := dateOf(now)
+	if m.App.PreArrivalDays > 0 {
+		err := m.sendGuestEmails(ctx, models.GuestEmailPreArrival, today.AddDate(0, 0, 1), today.AddDate(0, 0, m.App.PreArrivalDays))
+		if err != nil {
+			return err
+		}
+	}
+	return m.sendGuestEmails(ctx, models.GuestEmailPostStay, today.AddDate(0, 0, -postStayDays), today.AddDate(0, 0, -1))
+}
+
+// sendGuestEmails sends one kind of guest email to the reservations due for
+// it from start to end, an email is recorded as sent before it's queued so a
+// failed delivery is never retried rather than sent twice
+func (m *Repository) sendGuestEmails(ctx context.Context, kind string, start, end time.Time) error {
+	due, err := m.DB.GuestEmailsDue(ctx, kind, start, end)
+	if err != nil {
+		return err
+	}
+	sent := 0
+	for _, res := range due {
+		content, err := guestMail(kind, res, m.App.ReviewURL)
+		if err != nil {
+			return err
+		}
+		claimed, err := m.DB.MarkGuestEmailSent(ctx, res.ID, kind)
+		if err != nil {
+			return err
+		}
+		if !claimed {
+			continue
+		}
+		m.sendMail(ctx, models.MailData{
+			To:       res.Email,
+			From:     "me@here.com",
+			Subject:  guestMailSubjects[kind],
+			Content:  content,
+			Template: "basic.html",
+		})
+		sent++
+	}
+	if sent > 0 {
+		m.App.Logger.InfoContext(ctx, "guest emails sent", "kind", kind, "count", sent)
+	}
+	return nil
+}
+
+// guestMail returns the html body of a kind of guest email
+func guestMail(kind string, res models.Reservation, reviewURL string) (string, error) {
+	var b strings.Builder
+	err := guestMailTemplates.ExecuteTemplate(&b, kind, map[string]interface{}{
+		"Reservation": res,
+		"ReviewURL":   reviewURL,
+	})
+	return b.String(), err
+}
@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// verifyLifetime is how long an email verification link stays valid
+const verifyLifetime = 48 * time.Hour
+
+// currentGuest returns the logged in user when it is a guest account
+func (m *Repository) currentGuest(r *http.Request) (models.User, bool) {
+	if m.App.Session.GetInt(r.Context(), "user_id") == 0 {
+		return models.User{}, false
+	}
+	user, err := m.currentUser(r)
+	if err != nil || !user.IsGuest() {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ShowRegister shows the guest sign up form
+func (m *Repository) ShowRegister(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, "register.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	}, r)
+}
+
+// PostRegister creates an inactive guest account and mails the link to
+// verify its email, the response doesn't tell whether the email was taken
+func (m *Repository) PostRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	checkNewPassword(form, "password", "password_confirm")
+	if !form.Valid() {
+		render.Template(w, "register.page.tmpl", &models.TemplateData{
+			Form: form,
+		}, r)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("password")), bcryptCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	user := models.User{
+		FirstName:   form.Get("first_name"),
+		LastName:    form.Get("last_name"),
+		Email:       form.Get("email"),
+		Password:    string(hash),
+		AccessLevel: models.AccessLevelGuest,
+	}
+	user.ID, err = m.DB.InsertUser(r.Context(), models.Actor{Type: models.ActorGuest, Label: user.Email}, user)
+	switch {
+	case isDuplicateEmail(err):
+		m.sendAccountExists(r, user)
+	case err != nil:
+		helpers.ServerError(w, r, err)
+		return
+	default:
+		if err := m.sendEmailVerification(r, user); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Check your email to verify your account")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// verificationLink stores a single use token that verifies the email of user
+// and returns the link to mail them
+func (m *Repository) verificationLink(r *http.Request, user models.User) (string, error) {
+	token, hash, err := helpers.GenerateToken()
+	if err != nil {
+		return "", err
+	}
+	err = m.DB.InsertUserToken(r.Context(), models.UserToken{
+		UserID:    user.ID,
+		Scope:     models.TokenScopeVerifyEmail,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(verifyLifetime),
+	})
+	if err != nil {
+		return "", err
+	}
+	return m.appURL("/user/verify?token=" + token), nil
+}
+
+// sendEmailVerification mails a single use link that activates a guest account
+func (m *Repository) sendEmailVerification(r *http.Request, user models.User) error {
+	link, err := m.verificationLink(r, user)
+	if err != nil {
+		return err
+	}
+	m.sendMail(r.Context(), models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: "Verify your email",
+		Content: fmt.Sprintf(`
+	<strong>Welcome</strong><br>
+	Dear %s, <br>
+	Thank you for signing up. Verify your email within %d hours to log in:<br>
+	<a href="%s">%s</a>
+	`, user.FirstName, int(verifyLifetime.Hours()), link, link),
+		Template: "basic.html",
+	})
+	return nil
+}
+
+// sendEmailChangeVerification mails a single use link to the new email of a
+// user, bookings made under it are only shown once it is followed
+func (m *Repository) sendEmailChangeVerification(r *http.Request, user models.User) error {
+	link, err := m.verificationLink(r, user)
+	if err != nil {
+		return err
+	}
+	m.sendMail(r.Context(), models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: "Verify your new email",
+		Content: fmt.Sprintf(`
+	<strong>Email Changed</strong><br>
+	Dear %s, <br>
+	You changed the email of your account. Verify it within %d hours to see the bookings made under it:<br>
+	<a href="%s">%s</a>
+	`, user.FirstName, int(verifyLifetime.Hours()), link, link),
+		Template: "basic.html",
+	})
+	return nil
+}
+
+// sendAccountExists tells the owner of an email that somebody tried to sign up with it
+func (m *Repository) sendAccountExists(r *http.Request, user models.User) {
+	link := m.appURL("/user/forgot-password")
+	m.sendMail(r.Context(), models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: "You already have an account",
+		Content: fmt.Sprintf(`
+	<strong>Sign Up</strong><br>
+	Somebody tried to sign up with your email, but you already have an account.
+	If you forgot your password, you can choose a new one here:<br>
+	<a href="%s">%s</a>
+	`, link, link),
+		Template: "basic.html",
+	})
+}
+
+// VerifyEmail uses up a verification token and activates the guest account
+func (m *Repository) VerifyEmail(w http.ResponseWriter, r *http.Request) {
+	id, err := m.DB.ConsumeUserToken(r.Context(), models.TokenScopeVerifyEmail, helpers.HashToken(r.URL.Query().Get("token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This verification link is invalid or has expired, sign up again")
+		http.Redirect(w, r, "/user/register", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.VerifyEmail(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Email verified, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// MyBookings lists the upcoming and past reservations of the logged in user,
+// those made under their email only once it is verified
+func (m *Repository) MyBookings(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	email := ""
+	if user.EmailVerified {
+		email = user.Email
+	}
+	reservations, err := m.DB.GuestReservations(r.Context(), user.ID, email)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	upcoming, past := splitStays(reservations, time.Now())
+
+	data := make(map[string]interface{})
+	data["upcoming"] = upcoming
+	data["past"] = past
+	render.Template(w, "my-bookings.page.tmpl", &models.TemplateData{
+		Data: data,
+	}, r)
+}
+
+// splitStays splits reservations sorted by latest arrival into upcoming stays,
+// soonest first, and past ones; a stay is upcoming until its departure day is over
+func splitStays(reservations []models.Reservation, now time.Time) (upcoming, past []models.Reservation) {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	for _, res := range reservations {
+		if res.EndDate.Before(today) {
+			past = append(past, res)
+		} else {
+			upcoming = append([]models.Reservation{res}, upcoming...)
+		}
+	}
+	return upcoming, past
+}
@@ -1,10 +1,11 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
+	"net/url"
 	"strconv"
 	"time"
 
@@ -12,10 +13,12 @@ import (
 	"github.com/acceleraterA/go_app_udemy/internal/driver"
 	"github.com/acceleraterA/go_app_udemy/internal/forms"
 	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 	"github.com/acceleraterA/go_app_udemy/internal/render"
 	"github.com/acceleraterA/go_app_udemy/internal/repository"
 	"github.com/acceleraterA/go_app_udemy/internal/repository/dbrepo"
+	"github.com/acceleraterA/go_app_udemy/internal/webhooks"
 	"github.com/go-chi/chi"
 )
 
@@ -74,7 +77,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
-	room, err := m.DB.GetRoomByID(res.RoomID)
+	room, err := m.DB.GetRoomByID(r.Context(), res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't find room.")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -82,6 +85,12 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 	}
 	//populate room name by id and save to session
 	res.Room.RoomName = room.RoomName
+	//returning guests don't have to type their details again
+	if guest, ok := m.currentGuest(r); ok && res.Email == "" {
+		res.FirstName = guest.FirstName
+		res.LastName = guest.LastName
+		res.Email = guest.Email
+	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
 	//reverse the date format for teml and add to templatedata
@@ -93,6 +102,10 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	if err := m.addBotGuard(data); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
 
 	render.Template(w, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -105,7 +118,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	// reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	// if !ok {
-	// 	helpers.ServerError(w, errors.New("can't get from session"))
+	// 	helpers.ServerError(w, r, errors.New("can't get from session"))
 	// 	return
 	// }
 
@@ -152,6 +165,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		StartDate: startDate,
 		EndDate:   endDate,
 		RoomID:    roomID,
+		Status:    models.ReservationConfirmed,
+	}
+	if guest, ok := m.currentGuest(r); ok {
+		reservation.UserID = guest.ID
 	}
 	//form validation
 	form := forms.New(r.PostForm)
@@ -163,6 +180,10 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 
 		data := make(map[string]interface{})
 		data["reservation"] = reservation
+		if err := m.addBotGuard(data); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
 		http.Error(w, "my own error message", http.StatusSeeOther)
 		render.Template(w, "make-reservation.page.tmpl", &models.TemplateData{
 			Form: form,
@@ -170,14 +191,32 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		}, r)
 		return
 	}
+	//a guest booking without an account is known by the email they gave
+	actor := m.actor(r)
+	if actor.Type == models.ActorGuest && actor.ID == 0 {
+		actor.Label = reservation.Email
+	}
+	//a reservation that looks like spam waits for an admin and doesn't block the room,
+	//the guest is told the same as anybody else so bots can't learn what gave them away
+	reservation.HoldReason = m.holdReason(r)
+	if reservation.HoldReason != "" {
+		reservation.Status = models.ReservationHeld
+	}
 	//insert reservation
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	newReservationID, err := m.DB.InsertReservation(r.Context(), actor, reservation)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
+	if reservation.Status == models.ReservationHeld {
+		m.App.Logger.InfoContext(r.Context(), "reservation held for approval", "reservation_id", newReservationID, "reason", reservation.HoldReason)
+		reservation.ID = newReservationID
+		m.App.Session.Put(r.Context(), "reservation", reservation)
+		http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+		return
+	}
 
 	//insert a restriction
 	restriction := models.RoomRestriction{
@@ -187,13 +226,31 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		ReservationID: newReservationID,
 		RestrictionID: 1,
 	}
-	err = m.DB.InsertRoomRestriction(restriction)
+	err = m.DB.InsertRoomRestriction(r.Context(), actor, restriction)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 		return
 	}
 
+	reservation.ID = newReservationID
+	m.sendReservationEmails(r, reservation)
+	m.App.Metrics.ReservationsCreated.Inc()
+	m.publish(r, models.EventReservationCreated, reservation)
+	// take the reservation object to reservation summary page
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	//redirect the page
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
+
+// sendMail queues a mail for the mail worker
+func (m *Repository) sendMail(ctx context.Context, msg models.MailData) {
+	msg.Request, _ = logging.RequestFromContext(ctx)
+	m.App.MailChan <- msg
+}
+
+// sendReservationEmails queues the confirmation to the guest and the notification to the owner
+func (m *Repository) sendReservationEmails(r *http.Request, reservation models.Reservation) {
 	//send notifications-first to guest
 	//create msg and add to mailchan
 	htmlMessage := fmt.Sprintf(`
@@ -209,7 +266,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		Content:  htmlMessage,
 		Template: "basic.html",
 	}
-	m.App.MailChan <- msg
+	m.sendMail(r.Context(), msg)
 	//send notifications-to owner
 	//create msg and add to mailchan
 	htmlMessage = fmt.Sprintf(`
@@ -223,18 +280,25 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		Subject: "Reservation Notification",
 		Content: htmlMessage,
 	}
-	m.App.MailChan <- msg
-	// take the reservation object to reservation summary page
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	//redirect the page
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	m.sendMail(r.Context(), msg)
+}
+
+// publish sends a reservation lifecycle event to the webhook endpoints
+func (m *Repository) publish(r *http.Request, event string, res models.Reservation) {
+	if m.App.Webhooks == nil {
+		return
+	}
+	err := m.App.Webhooks.Publish(r.Context(), event, toAPIReservation(res))
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't publish webhook event", "event", event, "err", err)
+	}
 }
 
 // ReservationSummary displays the reservation summary page
 func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request) {
 	reservation, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		m.App.ErrorLog.Println("Can't get error from session")
+		m.App.Logger.ErrorContext(r.Context(), "can't get reservation from session")
 		//error msg and redirect to home page
 		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
 		http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
@@ -285,7 +349,7 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	//parse the date
@@ -294,22 +358,23 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	start, err := time.Parse(layout, sd)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	end, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	rooms, err := m.DB.SearchAvalibilityForAllRooms(start, end)
+	rooms, err := m.DB.SearchAvalibilityForAllRooms(r.Context(), start, end)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
+	m.App.Metrics.Searches.Inc("web")
 
 	for _, i := range rooms {
-		m.App.InfoLog.Println("room:", i.ID, i.RoomName)
+		m.App.Logger.DebugContext(r.Context(), "room available", "room_id", i.ID, "room", i.RoomName)
 	}
 	if len(rooms) == 0 {
 		//no availability
@@ -335,13 +400,13 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
 	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
 	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
 	if !ok {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	res.RoomID = roomID
@@ -359,9 +424,9 @@ func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, _ := time.Parse(layout, sd)
 	endDate, _ := time.Parse(layout, ed)
-	room, err := m.DB.GetRoomByID(roomID)
+	room, err := m.DB.GetRoomByID(r.Context(), roomID)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 
@@ -403,15 +468,15 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 	layout := "2006-01-02"
 	startDate, err := time.Parse(layout, sd)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
 	endDate, err := time.Parse(layout, ed)
 	if err != nil {
-		helpers.ServerError(w, err)
+		helpers.ServerError(w, r, err)
 		return
 	}
-	available, err := m.DB.SearchAvailabilityByDatesByRoomID(startDate, endDate, roomID)
+	available, err := m.DB.SearchAvailabilityByDatesByRoomID(r.Context(), startDate, endDate, roomID)
 	if err != nil {
 		resp := jsonResponse{
 			Ok:      false,
@@ -422,6 +487,7 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 		w.Write(out)
 		return
 	}
+	m.App.Metrics.Searches.Inc("json")
 	resp := jsonResponse{
 		Ok:        available,
 		Message:   "",
@@ -437,9 +503,30 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 }
 
-// Contact renders the contact page and displays form
-func (m *Repository) Contact(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "contact.page.tmpl", &models.TemplateData{}, r)
+// maxCSPReportSize is the largest violation report CSPReport reads
+const maxCSPReportSize = 64 << 10
+
+// CSPReport logs a Content-Security-Policy violation reported by a browser
+func (m *Repository) CSPReport(w http.ResponseWriter, r *http.Request) {
+	var report struct {
+		Body struct {
+			DocumentURI        string `json:"document-uri"`
+			BlockedURI         string `json:"blocked-uri"`
+			ViolatedDirective  string `json:"violated-directive"`
+			EffectiveDirective string `json:"effective-directive"`
+			SourceFile         string `json:"source-file"`
+			LineNumber         int    `json:"line-number"`
+		} `json:"csp-report"`
+	}
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxCSPReportSize)).Decode(&report)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	v := report.Body
+	m.App.Logger.InfoContext(r.Context(), "csp violation", "directive", v.ViolatedDirective, "blocked", v.BlockedURI,
+		"document", v.DocumentURI, "source", v.SourceFile, "line", v.LineNumber)
+	w.WriteHeader(http.StatusNoContent)
 }
 
 // Showlogin renders the contact page and displays form
@@ -456,7 +543,7 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 
 	err := r.ParseForm()
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.ErrorContext(r.Context(), "can't parse login form", "err", err)
 	}
 	email := r.Form.Get("email")
 	password := r.Form.Get("password")
@@ -470,20 +557,90 @@ func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(email, password)
+	attempt := models.LoginAttempt{Email: email, IPAddress: helpers.ClientIP(r)}
+	if m.loginThrottled(w, r, attempt, "/user/login") {
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(r.Context(), email, password)
 	if err != nil {
-		log.Println(err)
+		m.App.Logger.InfoContext(r.Context(), "login failed", "err", err)
+		m.recordLoginAttempt(r, attempt)
 
 		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
 		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 		return
 	}
-	m.App.Session.Put(r.Context(), "user_id", id)
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if user.TOTPEnabled {
+		//the password was right, the login finishes after the second step
+		m.App.Session.Put(r.Context(), "pending_user_id", id)
+		m.App.Session.Put(r.Context(), "pending_at", time.Now().Unix())
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	attempt.Success = true
+	m.recordLoginAttempt(r, attempt)
+	http.Redirect(w, r, m.logIn(r, user), http.StatusSeeOther)
+}
+
+// loginThrottled slows down and locks out repeated failures for the account
+// and the ip, it redirects to back and returns true when the attempt is refused
+func (m *Repository) loginThrottled(w http.ResponseWriter, r *http.Request, attempt models.LoginAttempt, back string) bool {
+	failures, err := m.DB.LoginFailures(r.Context(), attempt.Email, attempt.IPAddress, time.Now().Add(-m.App.LoginPolicy.Window))
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't count login failures", "err", err)
+	}
+	wait, locked := m.App.LoginPolicy.Check(failures, time.Now())
+	if wait <= 0 {
+		return false
+	}
+	attempt.Locked = true
+	m.recordLoginAttempt(r, attempt)
+	if locked {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed logins, try again in %d minutes", int(wait.Minutes())+1))
+	} else {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Please wait %d seconds before trying again", int(wait.Seconds())+1))
+	}
+	http.Redirect(w, r, back, http.StatusSeeOther)
+	return true
+}
+
+// logIn stores a fully authenticated user in the session and returns where to
+// send them, users whose role requires two factor authentication are sent to
+// enrol first
+func (m *Repository) logIn(r *http.Request, user models.User) string {
+	m.App.Session.Put(r.Context(), "user_id", user.ID)
+	m.App.Session.Put(r.Context(), "access_level", user.AccessLevel)
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion)
+
+	if !user.TOTPEnabled {
+		policy, err := m.DB.TwoFactorPolicy(r.Context())
+		if err != nil {
+			m.App.Logger.ErrorContext(r.Context(), "can't load two factor policy", "err", err)
+		}
+		if policy[user.AccessLevel] {
+			m.App.Session.Put(r.Context(), "must_enroll_2fa", true)
+			m.App.Session.Put(r.Context(), "warning", "Your role requires two factor authentication, set it up to continue")
+			return "/user/2fa"
+		}
+	}
 	m.App.Session.Put(r.Context(), "flash", "Logged in successfully")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+	if user.IsGuest() {
+		return "/user/bookings"
+	}
+	return "/"
+}
 
-	//user, err := m.DB.GetUserByID(id)
-	//err = m.DB.UpdateUser(user)
+// recordLoginAttempt stores a login attempt, failing to do so must not block the login
+func (m *Repository) recordLoginAttempt(r *http.Request, a models.LoginAttempt) {
+	if err := m.DB.InsertLoginAttempt(r.Context(), a); err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't record login attempt", "err", err)
+	}
 }
 
 // logs a user out
@@ -496,6 +653,363 @@ func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, "admin-dashboard.page.tmpl", &models.TemplateData{}, r)
+// AdminLoginAttempts shows the latest failed and locked out logins
+func (m *Repository) AdminLoginAttempts(w http.ResponseWriter, r *http.Request) {
+	attempts, err := m.DB.RecentLoginAttempts(r.Context(), 200)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["attempts"] = attempts
+
+	render.Template(w, "admin-login-attempts.page.tmpl", &models.TemplateData{
+		Data: data,
+	}, r)
+}
+
+// AdminAPIKeys shows the partner api keys and the form to create one
+func (m *Repository) AdminAPIKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := m.DB.AllAPIKeys(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["api_keys"] = keys
+	data["scopes"] = models.APIScopes
+
+	//the plain key is only shown once, right after it was created
+	stringMap := make(map[string]string)
+	stringMap["new_key"] = m.App.Session.PopString(r.Context(), "new_api_key")
+
+	render.Template(w, "admin-api-keys.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	}, r)
+}
+
+// AdminPostAPIKey creates a new api key with the chosen scopes
+func (m *Repository) AdminPostAPIKey(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("name")
+	var scopes []string
+	for _, scope := range models.APIScopes {
+		if r.Form.Get(scope) != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		form.Errors.Add("scopes", "Choose at least one scope")
+	}
+	if !form.Valid() {
+		keys, _ := m.DB.AllAPIKeys(r.Context())
+		data := make(map[string]interface{})
+		data["api_keys"] = keys
+		data["scopes"] = models.APIScopes
+		render.Template(w, "admin-api-keys.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		}, r)
+		return
+	}
+
+	key, prefix, hash, err := helpers.GenerateAPIKey()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	_, err = m.DB.InsertAPIKey(r.Context(), models.APIKey{
+		Name:    r.Form.Get("name"),
+		Prefix:  prefix,
+		KeyHash: hash,
+		Scopes:  scopes,
+		UserID:  m.App.Session.GetInt(r.Context(), "user_id"),
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "new_api_key", key)
+	m.App.Session.Put(r.Context(), "flash", "API key created")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminRevokeAPIKey revokes an api key so it can no longer be used
+func (m *Repository) AdminRevokeAPIKey(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = m.DB.RevokeAPIKey(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "API key revoked")
+	http.Redirect(w, r, "/admin/api-keys", http.StatusSeeOther)
+}
+
+// AdminReservations lists all reservations
+func (m *Repository) AdminReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.AllReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	//the export form defaults to last month, what the bookkeeper asks for
+	y, mo, _ := time.Now().Date()
+	monthStart := time.Date(y, mo, 1, 0, 0, 0, 0, time.UTC)
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["export_from"] = monthStart.AddDate(0, -1, 0).Format("2006-01-02")
+	data["export_to"] = monthStart.AddDate(0, 0, -1).Format("2006-01-02")
+
+	render.Template(w, "admin-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	}, r)
+}
+
+// AdminShowReservation shows a reservation with the form to edit it
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	render.Template(w, "admin-reservation-show.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+	}, r)
+}
+
+// AdminPostReservation saves changes to a reservation
+func (m *Repository) AdminPostReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if res.Status == models.ReservationCancelled {
+		m.App.Session.Put(r.Context(), "error", "Cancelled reservations can't be changed")
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations/%d", id), http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "start_date", "end_date")
+	form.MinLength("first_name", 2)
+	form.IsEmail("email")
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil || !endDate.After(startDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+	if form.Valid() && (!startDate.Equal(res.StartDate) || !endDate.Equal(res.EndDate)) {
+		available, err := m.DB.RoomAvailableForReservation(r.Context(), startDate, endDate, res.RoomID, res.ID)
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		if !available {
+			form.Errors.Add("start_date", "The room is not available for these dates")
+		}
+	}
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["reservation"] = res
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = r.Form.Get("start_date")
+		stringMap["end_date"] = r.Form.Get("end_date")
+		render.Template(w, "admin-reservation-show.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+		}, r)
+		return
+	}
+
+	res.StartDate = startDate
+	res.EndDate = endDate
+	err = m.DB.UpdateReservation(r.Context(), m.actor(r), res)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.publish(r, models.EventReservationModified, res)
+	m.App.Session.Put(r.Context(), "flash", "Reservation saved")
+	http.Redirect(w, r, "/admin/reservations", http.StatusSeeOther)
+}
+
+// AdminCancelReservation cancels a reservation and frees the room
+func (m *Repository) AdminCancelReservation(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	if res.Status == models.ReservationCancelled {
+		m.App.Session.Put(r.Context(), "warning", "Reservation was already cancelled")
+		http.Redirect(w, r, "/admin/reservations", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.CancelReservation(r.Context(), m.actor(r), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res.Status = models.ReservationCancelled
+	m.App.Metrics.ReservationsCancelled.Inc()
+	m.publish(r, models.EventReservationCancelled, res)
+	m.App.Session.Put(r.Context(), "flash", "Reservation cancelled")
+	http.Redirect(w, r, "/admin/reservations", http.StatusSeeOther)
+}
+
+// AdminWebhooks shows the webhook endpoints and the delivery log
+func (m *Repository) AdminWebhooks(w http.ResponseWriter, r *http.Request) {
+	m.renderWebhooks(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderWebhooks(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	endpoints, err := m.DB.AllWebhookEndpoints(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	deliveries, err := m.DB.RecentWebhookDeliveries(r.Context(), 50)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["endpoints"] = endpoints
+	data["deliveries"] = deliveries
+	data["events"] = models.WebhookEvents
+
+	render.Template(w, "admin-webhooks.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// AdminPostWebhook adds a webhook endpoint with a new signing secret
+func (m *Repository) AdminPostWebhook(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("url")
+	u, err := url.Parse(r.Form.Get("url"))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		form.Errors.Add("url", "Enter an http or https url")
+	}
+	var events []string
+	for _, event := range models.WebhookEvents {
+		if r.Form.Get(event) != "" {
+			events = append(events, event)
+		}
+	}
+	if len(events) == 0 {
+		form.Errors.Add("events", "Choose at least one event")
+	}
+	if !form.Valid() {
+		m.renderWebhooks(w, r, form)
+		return
+	}
+
+	secret, err := webhooks.NewSecret()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	_, err = m.DB.InsertWebhookEndpoint(r.Context(), models.WebhookEndpoint{
+		URL:    r.Form.Get("url"),
+		Secret: secret,
+		Events: events,
+		Active: true,
+	})
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Webhook endpoint added")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminDeleteWebhook removes a webhook endpoint
+func (m *Repository) AdminDeleteWebhook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = m.DB.DeleteWebhookEndpoint(r.Context(), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Webhook endpoint deleted")
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
+}
+
+// AdminReplayWebhookDelivery sends a delivery again
+func (m *Repository) AdminReplayWebhookDelivery(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = m.App.Webhooks.Replay(r.Context(), id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't replay delivery: "+err.Error())
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Delivery queued")
+	}
+	http.Redirect(w, r, "/admin/webhooks", http.StatusSeeOther)
 }
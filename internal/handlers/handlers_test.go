@@ -7,10 +7,19 @@ import (
 	"log"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/acceleraterA/go_app_udemy/internal/botguard"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/openapi"
+	"github.com/acceleraterA/go_app_udemy/internal/repository/dbrepo"
+	"github.com/acceleraterA/go_app_udemy/internal/totp"
+	"github.com/go-chi/chi"
 )
 
 type postData struct {
@@ -30,6 +39,43 @@ var theTests = []struct {
 	{"ms", "/majors-suite", "GET", http.StatusOK},
 	{"sa", "/search-availability", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
+	{"dashboard bad period", "/admin/dashboard?from=2050-02-01&to=2050-01-01", "GET", http.StatusOK},
+	{"dashboard database error", "/admin/dashboard?from=2060-01-01&to=2060-01-31", "GET", http.StatusInternalServerError},
+	{"api keys", "/admin/api-keys", "GET", http.StatusOK},
+	{"admin reservations", "/admin/reservations", "GET", http.StatusOK},
+	{"admin held reservations", "/admin/reservations/held", "GET", http.StatusOK},
+	{"admin reservation", "/admin/reservations/1", "GET", http.StatusOK},
+	{"admin export", "/admin/reservations/export?from=2050-01-01&to=2050-01-31", "GET", http.StatusOK},
+	{"admin export bad format", "/admin/reservations/export?from=2050-01-01&to=2050-01-31&format=xls", "GET", http.StatusBadRequest},
+	{"admin export without dates", "/admin/reservations/export", "GET", http.StatusBadRequest},
+	{"admin export database error", "/admin/reservations/export?from=2060-01-01&to=2060-01-31", "GET", http.StatusInternalServerError},
+	{"admin reservation not found", "/admin/reservations/99", "GET", http.StatusNotFound},
+	{"admin reservation bad id", "/admin/reservations/x", "GET", http.StatusBadRequest},
+	{"housekeeping", "/admin/housekeeping", "GET", http.StatusOK},
+	{"housekeeping on a date", "/admin/housekeeping?date=2050-01-03", "GET", http.StatusOK},
+	{"housekeeping bad date", "/admin/housekeeping?date=tomorrow", "GET", http.StatusBadRequest},
+	{"housekeeping database error", "/admin/housekeeping?date=2060-01-03", "GET", http.StatusInternalServerError},
+	{"housekeeping print", "/admin/housekeeping/print?date=2050-01-03", "GET", http.StatusOK},
+	{"messages", "/admin/messages", "GET", http.StatusOK},
+	{"spam messages", "/admin/messages?status=spam", "GET", http.StatusOK},
+	{"messages unknown folder", "/admin/messages?status=trash", "GET", http.StatusBadRequest},
+	{"message", "/admin/messages/1", "GET", http.StatusOK},
+	{"message not found", "/admin/messages/99", "GET", http.StatusNotFound},
+	{"message bad id", "/admin/messages/x", "GET", http.StatusBadRequest},
+	{"webhooks", "/admin/webhooks", "GET", http.StatusOK},
+	{"users", "/admin/users", "GET", http.StatusOK},
+	{"login attempts", "/admin/login-attempts", "GET", http.StatusOK},
+	{"security", "/admin/security", "GET", http.StatusOK},
+	{"user", "/admin/users/2", "GET", http.StatusOK},
+	{"user not found", "/admin/users/99", "GET", http.StatusNotFound},
+	{"guest user", "/admin/users/5", "GET", http.StatusNotFound},
+	{"accept invite", "/user/invite?token=abc", "GET", http.StatusOK},
+	{"forgot password", "/user/forgot-password", "GET", http.StatusOK},
+	{"reset password", "/user/reset-password?token=abc", "GET", http.StatusOK},
+	{"register", "/user/register", "GET", http.StatusOK},
+	{"audit log", "/admin/audit?entity_type=reservation&entity_id=1&from=2050-01-01&to=2050-01-31", "GET", http.StatusOK},
+	{"audit log bad filter", "/admin/audit?entity_id=x&from=yesterday", "GET", http.StatusOK},
 	//{"rs", "/reservation-summary", "GET", http.StatusOK},
 
 	// {"sap", "/search-availability", "POST", []postData{
@@ -115,6 +161,19 @@ func TestRepository_Reservation(t *testing.T) {
 		t.Errorf("Reservation handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusTemporaryRedirect)
 	}
 
+	//test the form is pre-filled for a logged in guest
+	req, _ = http.NewRequest("GET", "/make-reservation", nil)
+	ctx = getCtx(req)
+	req = req.WithContext(ctx)
+	rr = httptest.NewRecorder()
+	reservation.RoomID = 1
+	session.Put(ctx, "reservation", reservation)
+	session.Put(ctx, "user_id", 5)
+	handler.ServeHTTP(rr, req)
+
+	if res, _ := session.Get(ctx, "reservation").(models.Reservation); res.Email != "guest@here.com" || res.FirstName != "Test" {
+		t.Errorf("Reservation handler did not pre-fill the guest's details, got %q %q", res.FirstName, res.Email)
+	}
 }
 
 func TestRepository_PostReservation(t *testing.T) {
@@ -417,6 +476,13 @@ func TestRepository_PostAvailability(t *testing.T) {
 	handler.ServeHTTP(rr, req)
 }
 
+// withURLParam sets a url param the way the router would
+func withURLParam(req *http.Request, key, value string) *http.Request {
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add(key, value)
+	return req.WithContext(context.WithValue(req.Context(), chi.RouteCtxKey, rctx))
+}
+
 // getCtx returns the ctx with header
 func getCtx(req *http.Request) context.Context {
 	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
@@ -425,3 +491,1276 @@ func getCtx(req *http.Request) context.Context {
 	}
 	return ctx
 }
+
+func TestRepository_OpenAPI(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/api/openapi.json", nil)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.OpenAPI)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("OpenAPI handler returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+	var spec openapi.Document
+	if err := json.Unmarshal(rr.Body.Bytes(), &spec); err != nil {
+		t.Fatal("failed to parse openapi document", err)
+	}
+	if spec.OpenAPI == "" || len(spec.Paths) == 0 {
+		t.Error("openapi document has no version or paths")
+	}
+
+	//every documented operation must be served by the router
+	registered := map[string]bool{}
+	chi.Walk(getRoutes().(chi.Routes), func(method, route string, handler http.Handler, middlewares ...func(http.Handler) http.Handler) error {
+		registered[method+" "+route] = true
+		return nil
+	})
+	for path, item := range spec.Paths {
+		for method, op := range map[string]*openapi.Operation{"GET": item.Get, "POST": item.Post, "PUT": item.Put, "DELETE": item.Delete} {
+			if op != nil && !registered[method+" "+path] {
+				t.Errorf("%s %s is documented but not routed", method, path)
+			}
+		}
+	}
+}
+
+// TestAPISpecMatchesHandlers records real responses of the JSON handlers and
+// validates them against the documented schema, so the spec can't drift
+func TestAPISpecMatchesHandlers(t *testing.T) {
+	spec := apiSpec()
+
+	var tests = []struct {
+		name    string
+		method  string
+		url     string
+		body    string
+		handler http.HandlerFunc
+	}{
+		{"available", "POST", "/search-availability-json", "start=2040-01-01&end=2040-01-02&room_id=1", Repo.AvailabilityJSON},
+		{"not available", "POST", "/search-availability-json", "start=2050-01-01&end=2050-01-02&room_id=1", Repo.AvailabilityJSON},
+		{"database error", "POST", "/search-availability-json", "start=2060-01-01&end=2060-01-02&room_id=1", Repo.AvailabilityJSON},
+		{"no body", "POST", "/search-availability-json", "", Repo.AvailabilityJSON},
+		{"bad date", "POST", "/search-availability-json", "start=invalid&end=2040-01-02&room_id=1", Repo.AvailabilityJSON},
+		{"spec", "GET", "/api/openapi.json", "", Repo.OpenAPI},
+		{"api availability", "GET", "/api/v1/availability?start=2040-01-01&end=2040-01-02", "", Repo.APIAvailability},
+		{"api availability bad dates", "GET", "/api/v1/availability?start=2040-01-02&end=2040-01-01", "", Repo.APIAvailability},
+		{"api reservations", "GET", "/api/v1/reservations", "", Repo.APIReservations},
+		{"api reserve", "POST", "/api/v1/reservations", `{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2040-01-01","end_date":"2040-01-03","room_id":1}`, Repo.APIPostReservation},
+		{"api reserve invalid", "POST", "/api/v1/reservations", `{"first_name":"J","last_name":"","email":"john","start_date":"2040-01-01","end_date":"2040-01-03","room_id":1}`, Repo.APIPostReservation},
+		{"api reserve not json", "POST", "/api/v1/reservations", `first_name=John`, Repo.APIPostReservation},
+		{"api reserve no room", "POST", "/api/v1/reservations", `{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2040-01-01","end_date":"2040-01-03","room_id":100}`, Repo.APIPostReservation},
+		{"api reserve taken", "POST", "/api/v1/reservations", `{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2050-01-01","end_date":"2050-01-03","room_id":1}`, Repo.APIPostReservation},
+		{"api reserve insert fails", "POST", "/api/v1/reservations", `{"first_name":"John","last_name":"Smith","email":"john@smith.com","start_date":"2040-01-01","end_date":"2040-01-03","room_id":3}`, Repo.APIPostReservation},
+	}
+
+	for _, e := range tests {
+		var req *http.Request
+		if e.body == "" {
+			req, _ = http.NewRequest(e.method, e.url, nil)
+		} else {
+			req, _ = http.NewRequest(e.method, e.url, strings.NewReader(e.body))
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		path, _, _ := strings.Cut(e.url, "?")
+		op := spec.Lookup(e.method, path)
+		if op == nil {
+			t.Errorf("%s: %s %s is not documented", e.name, e.method, path)
+			continue
+		}
+		resp, ok := op.Responses[strconv.Itoa(rr.Code)]
+		if !ok {
+			t.Errorf("%s: status %d is not documented", e.name, rr.Code)
+			continue
+		}
+		contentType, _, _ := strings.Cut(rr.Header().Get("Content-Type"), ";")
+		media, ok := resp.Content[contentType]
+		if !ok {
+			t.Errorf("%s: content type %q is not documented for status %d", e.name, contentType, rr.Code)
+			continue
+		}
+		if contentType != "application/json" {
+			continue
+		}
+		if err := spec.ValidateJSON(media.Schema, rr.Body.Bytes()); err != nil {
+			t.Errorf("%s: response does not match the spec: %s", e.name, err)
+		}
+	}
+}
+
+func TestRepository_AdminPostAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectNewKey       bool
+	}{
+		{"valid", "name=partner&availability:read=1&reservations:create=1", http.StatusSeeOther, true},
+		{"no scopes", "name=partner", http.StatusOK, false},
+		{"no name", "availability:read=1", http.StatusOK, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-keys", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostAPIKey)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostAPIKey returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		key := session.GetString(ctx, "new_api_key")
+		if e.expectNewKey && !strings.HasPrefix(key, "bk_") {
+			t.Errorf("%s: expected the new key in the session, got %q", e.name, key)
+		}
+		if !e.expectNewKey && key != "" {
+			t.Errorf("%s: did not expect a new key in the session", e.name)
+		}
+	}
+}
+
+func TestRepository_AdminRevokeAPIKey(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid", "1", http.StatusSeeOther},
+		{"invalid id", "x", http.StatusBadRequest},
+		{"database error", "100", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/api-keys/"+e.id+"/revoke", nil)
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminRevokeAPIKey)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminRevokeAPIKey returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostReservation(t *testing.T) {
+	valid := "first_name=John&last_name=Smith&email=john@smith.com&phone=123"
+	var tests = []struct {
+		name               string
+		id                 string
+		body               string
+		expectedStatusCode int
+	}{
+		{"same dates", "1", valid + "&start_date=2040-01-01&end_date=2040-01-03", http.StatusSeeOther},
+		{"new dates", "1", valid + "&start_date=2040-02-01&end_date=2040-02-03", http.StatusSeeOther},
+		{"dates taken", "1", valid + "&start_date=2050-02-01&end_date=2050-02-03", http.StatusOK},
+		{"end before start", "1", valid + "&start_date=2040-02-03&end_date=2040-02-01", http.StatusOK},
+		{"invalid email", "1", "first_name=John&last_name=Smith&email=john&start_date=2040-01-01&end_date=2040-01-03", http.StatusOK},
+		{"cancelled", "2", valid + "&start_date=2040-01-01&end_date=2040-01-03", http.StatusSeeOther},
+		{"database error", "3", valid + "&start_date=2040-01-01&end_date=2040-01-03", http.StatusInternalServerError},
+		{"not found", "99", valid + "&start_date=2040-01-01&end_date=2040-01-03", http.StatusNotFound},
+		{"bad id", "x", valid, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.id, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminCancelReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+	}{
+		{"valid", "1", http.StatusSeeOther},
+		{"already cancelled", "2", http.StatusSeeOther},
+		{"database error", "3", http.StatusInternalServerError},
+		{"not found", "99", http.StatusNotFound},
+		{"bad id", "x", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.id+"/cancel", nil)
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminCancelReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminCancelReservation returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostReservationBotGuard(t *testing.T) {
+	app.BotGuard = botguard.New(app.EncryptionKey, nil)
+	defer func() { app.BotGuard = nil }()
+	person, err := app.BotGuard.Issue(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bot, err := app.BotGuard.Issue(time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fast, err := app.BotGuard.Issue(time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name           string
+		fields         string
+		expectedStatus string
+	}{
+		{"person", "form_token=" + url.QueryEscape(person.Token), models.ReservationConfirmed},
+		{"token reused", "form_token=" + url.QueryEscape(person.Token), models.ReservationHeld},
+		{"honeypot", "form_token=" + url.QueryEscape(bot.Token) + "&website=spam.example", models.ReservationHeld},
+		{"too fast", "form_token=" + url.QueryEscape(fast.Token), models.ReservationHeld},
+		{"no token", "", models.ReservationHeld},
+	}
+
+	for _, e := range tests {
+		body := "start_date=2050-01-01&end_date=2050-01-03&first_name=John&last_name=Smith&email=john@smith.com&room_id=1&" + e.fields
+		req, _ := http.NewRequest("POST", "/make-reservation", strings.NewReader(body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther || rr.Header().Get("Location") != "/reservation-summary" {
+			t.Errorf("%s: expected the summary for every guest, got %d to %s", e.name, rr.Code, rr.Header().Get("Location"))
+		}
+		res, _ := session.Get(ctx, "reservation").(models.Reservation)
+		if res.Status != e.expectedStatus {
+			t.Errorf("%s: expected status %s but got %s", e.name, e.expectedStatus, res.Status)
+		}
+		if (res.Status == models.ReservationHeld) == (res.HoldReason == "") {
+			t.Errorf("%s: hold reason %q doesn't match status %s", e.name, res.HoldReason, res.Status)
+		}
+	}
+}
+
+func TestRepository_AdminApproveAndRejectReservation(t *testing.T) {
+	var tests = []struct {
+		name               string
+		handler            http.HandlerFunc
+		id                 string
+		expectedStatusCode int
+		expectedMessage    string
+	}{
+		{"approve", Repo.AdminApproveReservation, "4", http.StatusSeeOther, "flash"},
+		{"approve booked dates", Repo.AdminApproveReservation, "5", http.StatusSeeOther, "error"},
+		{"approve confirmed", Repo.AdminApproveReservation, "1", http.StatusSeeOther, "warning"},
+		{"approve not found", Repo.AdminApproveReservation, "99", http.StatusNotFound, ""},
+		{"approve bad id", Repo.AdminApproveReservation, "x", http.StatusBadRequest, ""},
+		{"reject", Repo.AdminRejectReservation, "4", http.StatusSeeOther, "flash"},
+		{"reject cancelled", Repo.AdminRejectReservation, "2", http.StatusSeeOther, "warning"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/reservations/"+e.id+"/approve", nil)
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if e.expectedMessage != "" && session.GetString(ctx, e.expectedMessage) == "" {
+			t.Errorf("%s: expected a %s message", e.name, e.expectedMessage)
+		}
+	}
+}
+
+func TestRepository_AdminPostWebhook(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{"valid", "url=https://example.com/hook&reservation.created=1", http.StatusSeeOther},
+		{"not http", "url=ftp://example.com/hook&reservation.created=1", http.StatusOK},
+		{"no url", "reservation.created=1", http.StatusOK},
+		{"no events", "url=https://example.com/hook", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostWebhook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostWebhook returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminReplayWebhookDelivery(t *testing.T) {
+	var tests = []struct {
+		name          string
+		id            string
+		expectedFlash bool
+	}{
+		{"failed delivery", "1", true},
+		{"unknown delivery", "2", false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/webhooks/deliveries/"+e.id+"/replay", nil)
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminReplayWebhookDelivery)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: AdminReplayWebhookDelivery returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if flash := session.GetString(ctx, "flash"); (flash != "") != e.expectedFlash {
+			t.Errorf("%s: unexpected flash %q", e.name, flash)
+		}
+	}
+}
+
+func TestRepository_AdminPostUser(t *testing.T) {
+	valid := "first_name=Jane&last_name=Doe&access_level=1"
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{"invite", valid + "&email=jane@here.com", http.StatusSeeOther},
+		{"with password", valid + "&email=jane@here.com&password=secret123&password_confirm=secret123", http.StatusSeeOther},
+		{"short password", valid + "&email=jane@here.com&password=short&password_confirm=short", http.StatusOK},
+		{"passwords differ", valid + "&email=jane@here.com&password=secret123&password_confirm=secret124", http.StatusOK},
+		{"bad role", "first_name=Jane&last_name=Doe&access_level=9&email=jane@here.com", http.StatusOK},
+		{"email taken", valid + "&email=taken@here.com", http.StatusOK},
+		{"missing name", "access_level=1&email=jane@here.com", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostUser returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminPostUserUpdate(t *testing.T) {
+	valid := "first_name=Jane&last_name=Doe&email=jane@here.com"
+	var tests = []struct {
+		name               string
+		id                 string
+		body               string
+		expectedStatusCode int
+	}{
+		{"valid", "1", valid + "&access_level=2&active=1", http.StatusSeeOther},
+		{"deactivate", "1", valid + "&access_level=1", http.StatusSeeOther},
+		{"deactivate self", "3", valid + "&access_level=3", http.StatusOK},
+		{"demote self", "3", valid + "&access_level=2&active=1", http.StatusOK},
+		{"bad role", "1", valid + "&access_level=0&active=1", http.StatusOK},
+		{"invalid email", "1", "first_name=Jane&last_name=Doe&email=jane&access_level=1&active=1", http.StatusOK},
+		{"not found", "99", valid + "&access_level=1&active=1", http.StatusNotFound},
+		{"guest account", "5", valid + "&access_level=0&active=1", http.StatusNotFound},
+		{"bad id", "x", valid, http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 3)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostUserUpdate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostUserUpdate returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_AdminDeleteUser(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		expectedStatusCode int
+		expectedFlash      bool
+	}{
+		{"valid", "1", http.StatusSeeOther, true},
+		{"self", "3", http.StatusSeeOther, false},
+		{"database error", "100", http.StatusInternalServerError, false},
+		{"bad id", "x", http.StatusBadRequest, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id+"/delete", nil)
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 3)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminDeleteUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminDeleteUser returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); (flash != "") != e.expectedFlash {
+			t.Errorf("%s: unexpected flash %q", e.name, flash)
+		}
+	}
+}
+
+func TestRepository_PostAcceptInvite(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedFlash      bool
+	}{
+		{"valid", "token=valid-token&password=secret123&password_confirm=secret123", http.StatusSeeOther, true},
+		{"used or expired token", "token=old-token&password=secret123&password_confirm=secret123", http.StatusSeeOther, false},
+		{"short password", "token=valid-token&password=short&password_confirm=short", http.StatusOK, false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/invite", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostAcceptInvite)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostAcceptInvite returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if flash := session.GetString(ctx, "flash"); (flash != "") != e.expectedFlash {
+			t.Errorf("%s: unexpected flash %q", e.name, flash)
+		}
+	}
+}
+
+func TestRepository_Profile(t *testing.T) {
+	var tests = []struct {
+		name               string
+		url                string
+		body               string
+		handler            http.HandlerFunc
+		expectedStatusCode int
+	}{
+		{"show", "/user/profile", "", Repo.Profile, http.StatusOK},
+		{"save", "/user/profile", "first_name=Jane&last_name=Doe&email=jane@here.com", Repo.PostProfile, http.StatusSeeOther},
+		{"save invalid", "/user/profile", "first_name=Jane&last_name=Doe&email=jane", Repo.PostProfile, http.StatusOK},
+		{"change password", "/user/password", "current_password=secret&new_password=secret123&new_password_confirm=secret123", Repo.PostPassword, http.StatusSeeOther},
+		{"wrong current password", "/user/password", "current_password=wrong&new_password=secret123&new_password_confirm=secret123", Repo.PostPassword, http.StatusOK},
+		{"passwords differ", "/user/password", "current_password=secret&new_password=secret123&new_password_confirm=secret12", Repo.PostPassword, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostProfileEmailChange(t *testing.T) {
+	//its own config, the mail listener of the tests drains app.MailChan
+	cfg := app
+	repo := &Repository{App: &cfg, DB: Repo.DB}
+
+	var tests = []struct {
+		name     string
+		email    string
+		verifies bool
+	}{
+		{"same email", "user@here.com", false},
+		{"same email other case", "User@Here.com", false},
+		{"new email", "jane@here.com", true},
+	}
+
+	for _, e := range tests {
+		cfg.MailChan = make(chan models.MailData, 10)
+		req, _ := http.NewRequest("POST", "/user/profile", strings.NewReader("first_name=Jane&last_name=Doe&email="+url.QueryEscape(e.email)))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(repo.PostProfile).ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+		if n := len(cfg.MailChan); (n == 1) != e.verifies {
+			t.Errorf("%s: got %d verification mails", e.name, n)
+			continue
+		}
+		if e.verifies {
+			msg := <-cfg.MailChan
+			if msg.To != e.email || !strings.Contains(msg.Content, "/user/verify?token=") {
+				t.Errorf("%s: expected a verification link sent to %s, got %+v", e.name, e.email, msg)
+			}
+		}
+	}
+}
+
+func TestRepository_PostForgotPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{"known email", "email=user@here.com", http.StatusSeeOther},
+		{"unknown email", "email=nobody@here.com", http.StatusSeeOther},
+		{"invalid email", "email=nobody", http.StatusOK},
+	}
+
+	var flashes []string
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostForgotPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			flashes = append(flashes, session.GetString(ctx, "flash"))
+		}
+	}
+	//known and unknown emails must be indistinguishable
+	if len(flashes) != 2 || flashes[0] != flashes[1] || flashes[0] == "" {
+		t.Errorf("expected the same flash for known and unknown emails, got %q", flashes)
+	}
+}
+
+func TestRepository_EmailedLinksIgnoreHost(t *testing.T) {
+	//its own config, the mail listener of the tests drains app.MailChan
+	cfg := app
+	repo := &Repository{App: &cfg, DB: Repo.DB}
+
+	var tests = []struct {
+		name    string
+		handler http.HandlerFunc
+		url     string
+		body    string
+		link    string
+	}{
+		{"password reset", repo.PostForgotPassword, "/user/forgot-password", "email=user@here.com", "https://fortsmythe.example/user/reset-password?token="},
+		{"verify email", repo.PostRegister, "/user/register", "first_name=Jane&last_name=Doe&email=jane@here.com&password=secret123&password_confirm=secret123", "https://fortsmythe.example/user/verify?token="},
+		{"account exists", repo.PostRegister, "/user/register", "first_name=Jane&last_name=Doe&email=taken@here.com&password=secret123&password_confirm=secret123", "https://fortsmythe.example/user/forgot-password"},
+	}
+
+	for _, e := range tests {
+		cfg.MailChan = make(chan models.MailData, 10)
+		req, _ := http.NewRequest("POST", e.url, strings.NewReader(e.body))
+		req.Host = "attacker.example"
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		e.handler.ServeHTTP(rr, req)
+
+		select {
+		case msg := <-cfg.MailChan:
+			if !strings.Contains(msg.Content, e.link) || strings.Contains(msg.Content, "attacker.example") {
+				t.Errorf("%s: expected a link starting with %s, got %s", e.name, e.link, msg.Content)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%s: no mail sent", e.name)
+		}
+	}
+}
+
+func TestRepository_PostResetPassword(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "token=valid-token&password=secret123&password_confirm=secret123", http.StatusSeeOther, "/user/login"},
+		{"used or expired token", "token=old-token&password=secret123&password_confirm=secret123", http.StatusSeeOther, "/user/forgot-password"},
+		{"passwords differ", "token=valid-token&password=secret123&password_confirm=secret12", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/reset-password", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostResetPassword returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+}
+
+func TestRepository_PostRegister(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"valid", "first_name=Jane&last_name=Doe&email=jane@here.com&password=secret123&password_confirm=secret123", http.StatusSeeOther, "/user/login"},
+		{"email taken", "first_name=Jane&last_name=Doe&email=taken@here.com&password=secret123&password_confirm=secret123", http.StatusSeeOther, "/user/login"},
+		{"invalid email", "first_name=Jane&last_name=Doe&email=jane&password=secret123&password_confirm=secret123", http.StatusOK, ""},
+		{"short password", "first_name=Jane&last_name=Doe&email=jane@here.com&password=secret&password_confirm=secret", http.StatusOK, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/register", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostRegister)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostRegister returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+}
+
+func TestRepository_VerifyEmail(t *testing.T) {
+	var tests = []struct {
+		name             string
+		token            string
+		expectedLocation string
+	}{
+		{"valid", "valid-token", "/user/login"},
+		{"used or expired token", "old-token", "/user/register"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/user/verify?token="+e.token, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.VerifyEmail)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, loc)
+		}
+	}
+}
+
+func TestRepository_MyBookings(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/user/bookings", nil)
+	ctx := getCtx(req)
+	session.Put(ctx, "user_id", 5)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(Repo.MyBookings)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Errorf("MyBookings returned wrong response code: got %d, wanted %d", rr.Code, http.StatusOK)
+	}
+}
+
+func TestSplitStays(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC) }
+	reservations := []models.Reservation{
+		{ID: 3, StartDate: day(20), EndDate: day(22)},
+		{ID: 2, StartDate: day(10), EndDate: day(12)},
+		{ID: 1, StartDate: day(1), EndDate: day(3)},
+	}
+
+	upcoming, past := splitStays(reservations, day(12).Add(15*time.Hour))
+	if len(upcoming) != 2 || upcoming[0].ID != 2 || upcoming[1].ID != 3 {
+		t.Errorf("expected stays 2 and 3 upcoming, soonest first, got %+v", upcoming)
+	}
+	if len(past) != 1 || past[0].ID != 1 {
+		t.Errorf("expected stay 1 in the past, got %+v", past)
+	}
+}
+
+func TestRepository_actor(t *testing.T) {
+	var tests = []struct {
+		name     string
+		userID   int
+		apiKey   *models.APIKey
+		expected models.Actor
+	}{
+		{"anonymous guest", 0, nil, models.Actor{Type: models.ActorGuest}},
+		{"staff", 1, nil, models.Actor{Type: models.ActorUser, ID: 1, Label: "user@here.com"}},
+		{"guest account", 5, nil, models.Actor{Type: models.ActorGuest, ID: 5, Label: "guest@here.com"}},
+		{"api key", 0, &models.APIKey{ID: 7, Name: "channel manager"}, models.Actor{Type: models.ActorAPIKey, ID: 7, Label: "channel manager"}},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/", nil)
+		ctx := getCtx(req)
+		if e.userID != 0 {
+			session.Put(ctx, "user_id", e.userID)
+		}
+		if e.apiKey != nil {
+			ctx = helpers.WithAPIKey(ctx, *e.apiKey)
+		}
+		req = req.WithContext(ctx)
+
+		if got := Repo.actor(req); got != e.expected {
+			t.Errorf("%s: expected actor %+v but got %+v", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRepository_CSPReport(t *testing.T) {
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+	}{
+		{"report", `{"csp-report":{"document-uri":"http://localhost/","blocked-uri":"inline","violated-directive":"script-src"}}`, http.StatusNoContent},
+		{"not json", "report", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/csp-report", strings.NewReader(e.body))
+		req.Header.Set("Content-Type", "application/csp-report")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.CSPReport)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: CSPReport returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRepository_PostShowLogin(t *testing.T) {
+	var tests = []struct {
+		name             string
+		email            string
+		password         string
+		remoteAddr       string
+		expectedLocation string
+		expectedError    string
+	}{
+		{"valid", "user@here.com", "secret", "192.0.2.1:1234", "/", ""},
+		{"wrong password", "user@here.com", "wrong", "192.0.2.1:1234", "/user/login", "Invalid login credentials"},
+		{"recent failures", "slow@here.com", "secret", "192.0.2.1:1234", "/user/login", "Please wait"},
+		{"account locked", "locked@here.com", "secret", "192.0.2.1:1234", "/user/login", "Too many failed logins"},
+		{"ip locked", "user@here.com", "secret", "10.0.0.66:1234", "/user/login", "Too many failed logins"},
+		{"two factor enrolled", "manager@here.com", "secret", "192.0.2.1:1234", "/user/login/2fa", ""},
+		{"two factor required", "owner@here.com", "secret", "192.0.2.1:1234", "/user/2fa", ""},
+		{"guest", "guest@here.com", "secret", "192.0.2.1:1234", "/user/bookings", ""},
+	}
+
+	for _, e := range tests {
+		body := "email=" + e.email + "&password=" + e.password
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RemoteAddr = e.remoteAddr
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, loc)
+		}
+		if msg := session.GetString(ctx, "error"); !strings.HasPrefix(msg, e.expectedError) || (e.expectedError == "") != (msg == "") {
+			t.Errorf("%s: expected error starting with %q, got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+func TestRepository_PostLoginTwoFactor(t *testing.T) {
+	code, _ := totp.Code(dbrepo.TestTOTPSecret, time.Now())
+	var tests = []struct {
+		name             string
+		code             string
+		pendingUserID    int
+		pendingAt        time.Time
+		expectedLocation string
+		expectLoggedIn   bool
+	}{
+		{"valid code", code, 2, time.Now(), "/", true},
+		{"recovery code", "RECO-VERY-CODE", 2, time.Now(), "/", true},
+		{"wrong code", "000000", 2, time.Now(), "/user/login/2fa", false},
+		{"no pending login", code, 0, time.Now(), "/user/login", false},
+		{"pending login expired", code, 2, time.Now().Add(-time.Hour), "/user/login", false},
+		{"user without two factor", code, 1, time.Now(), "/user/login", false},
+	}
+
+	for _, e := range tests {
+		if e.code == "000000" && code == "000000" {
+			continue
+		}
+		req, _ := http.NewRequest("POST", "/user/login/2fa", strings.NewReader("code="+e.code))
+		ctx := getCtx(req)
+		session.Put(ctx, "pending_user_id", e.pendingUserID)
+		session.Put(ctx, "pending_at", e.pendingAt.Unix())
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostLoginTwoFactor)
+		handler.ServeHTTP(rr, req)
+
+		if loc := rr.Header().Get("Location"); loc != e.expectedLocation {
+			t.Errorf("%s: expected redirect to %q but got %q", e.name, e.expectedLocation, loc)
+		}
+		if loggedIn := session.GetInt(ctx, "user_id") != 0; loggedIn != e.expectLoggedIn {
+			t.Errorf("%s: expected logged in %v but got %v", e.name, e.expectLoggedIn, loggedIn)
+		}
+	}
+}
+
+func TestRepository_PostEnableTwoFactor(t *testing.T) {
+	secret, _ := totp.GenerateSecret()
+	code, _ := totp.Code(secret, time.Now())
+	var tests = []struct {
+		name               string
+		secret             string
+		code               string
+		expectedStatusCode int
+	}{
+		{"valid code", secret, code, http.StatusSeeOther},
+		{"wrong code", secret, "12345", http.StatusOK},
+		{"no secret in session", "", code, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/2fa/enable", strings.NewReader("code="+e.code))
+		ctx := getCtx(req)
+		session.Put(ctx, "user_id", 1)
+		session.Put(ctx, "must_enroll_2fa", true)
+		if e.secret != "" {
+			session.Put(ctx, "totp_setup_secret", e.secret)
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostEnableTwoFactor)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostEnableTwoFactor returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther {
+			codes, _ := session.Get(ctx, "recovery_codes").([]string)
+			if len(codes) != recoveryCodeCount {
+				t.Errorf("%s: expected %d recovery codes in the session, got %d", e.name, recoveryCodeCount, len(codes))
+			}
+			if session.GetBool(ctx, "must_enroll_2fa") {
+				t.Errorf("%s: enrolment requirement was not cleared", e.name)
+			}
+		}
+	}
+}
+
+func TestRepository_PostDisableTwoFactor(t *testing.T) {
+	var tests = []struct {
+		name               string
+		userID             int
+		email              string
+		password           string
+		expectedStatusCode int
+	}{
+		{"valid", 2, "manager@here.com", "secret", http.StatusSeeOther},
+		{"wrong password", 2, "manager@here.com", "wrong", http.StatusOK},
+		{"required by role", 3, "owner@here.com", "secret", http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/user/2fa/disable", strings.NewReader("password="+e.password))
+		ctx := getCtx(req)
+		req = req.WithContext(helpers.WithUser(ctx, models.User{ID: e.userID, Email: e.email, AccessLevel: e.userID, TOTPEnabled: true}))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostDisableTwoFactor)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostDisableTwoFactor returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := map[string]bool{}
+	for i, code := range codes {
+		if seen[code] {
+			t.Errorf("recovery code %s was generated twice", code)
+		}
+		seen[code] = true
+		if helpers.HashToken(normalizeRecoveryCode(strings.ToUpper(code))) != hashes[i] {
+			t.Errorf("recovery code %s does not match its hash once normalized", code)
+		}
+	}
+}
+
+func TestRepository_AdminExportReservations(t *testing.T) {
+	var tests = []struct {
+		name        string
+		query       string
+		contentType string
+		want        string
+	}{
+		{"csv", "from=2050-01-01&to=2050-01-31", "text/csv; charset=utf-8",
+			"1,1,General's Quarters,John,Smith,john@smith.com,,2050-01-01,2050-01-03,2,"},
+		{"json", "from=2050-01-01&to=2050-01-31&format=json", "application/json", `"nights":2`},
+		{"empty", "from=2040-01-01&to=2040-01-31&format=json", "application/json", "[]"},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/reservations/export?"+e.query, nil)
+		req = req.WithContext(getCtx(req))
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminExportReservations)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Errorf("%s: got status %d", e.name, rr.Code)
+		}
+		if got := rr.Header().Get("Content-Type"); got != e.contentType {
+			t.Errorf("%s: got content type %q", e.name, got)
+		}
+		if !strings.Contains(rr.Header().Get("Content-Disposition"), "attachment") {
+			t.Errorf("%s: not sent as an attachment", e.name)
+		}
+		if !strings.Contains(rr.Body.String(), e.want) {
+			t.Errorf("%s: missing %q in %s", e.name, e.want, rr.Body.String())
+		}
+	}
+}
+
+func TestRepository_AdminPostHousekeeping(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		body               string
+		expectedStatusCode int
+	}{
+		{"valid", "1", "date=2050-01-03&status=clean", http.StatusSeeOther},
+		{"unknown status", "1", "date=2050-01-03&status=sparkling", http.StatusBadRequest},
+		{"bad date", "1", "date=today&status=clean", http.StatusBadRequest},
+		{"bad id", "x", "date=2050-01-03&status=clean", http.StatusBadRequest},
+		{"database error", "3", "date=2050-01-03&status=clean", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/housekeeping/"+e.id, strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostHousekeeping)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostHousekeeping returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/admin/housekeeping?date=2050-01-03" {
+			t.Errorf("%s: redirected to %s", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestHousekeepingMail(t *testing.T) {
+	day := time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	schedule, err := Repo.housekeepingSchedule(context.Background(), day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := housekeepingMail(day, schedule)
+	for _, want := range []string{"Monday, January 3, 2050", "<td>General&#39;s Quarters</td><td>check-out</td><td>cleaning</td>",
+		"<td>Major&#39;s Suite</td><td>owner block</td><td>clean</td>"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
+
+func TestRepository_SendGuestEmails(t *testing.T) {
+	//its own config, the mail listener of the tests drains app.MailChan
+	cfg := app
+	cfg.PreArrivalDays = 3
+	repo := &Repository{App: &cfg, DB: Repo.DB}
+
+	var tests = []struct {
+		name     string
+		now      time.Time
+		subjects []string
+		err      bool
+	}{
+		{"pre-arrival", time.Date(2049, 12, 29, 10, 0, 0, 0, time.UTC), []string{"Your stay is coming up"}, false},
+		{"too early", time.Date(2049, 12, 28, 10, 0, 0, 0, time.UTC), nil, false},
+		{"arrival day", time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC), nil, false},
+		{"post-stay", time.Date(2050, 1, 4, 10, 0, 0, 0, time.UTC), []string{"Thank you for staying with us"}, false},
+		{"a week later", time.Date(2050, 1, 10, 10, 0, 0, 0, time.UTC), []string{"Thank you for staying with us"}, false},
+		{"too late", time.Date(2050, 1, 11, 10, 0, 0, 0, time.UTC), nil, false},
+		{"database error", time.Date(2060, 1, 1, 10, 0, 0, 0, time.UTC), nil, true},
+	}
+	for _, e := range tests {
+		cfg.MailChan = make(chan models.MailData, 10)
+		err := repo.SendGuestEmails(context.Background(), e.now)
+		if (err != nil) != e.err {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		close(cfg.MailChan)
+		var subjects []string
+		for msg := range cfg.MailChan {
+			//reservation 2 was already sent its email
+			if msg.To != "john@smith.com" || msg.Template != "basic.html" {
+				t.Errorf("%s: unexpected mail %+v", e.name, msg)
+			}
+			subjects = append(subjects, msg.Subject)
+		}
+		if strings.Join(subjects, ", ") != strings.Join(e.subjects, ", ") {
+			t.Errorf("%s: got mails %q, want %q", e.name, subjects, e.subjects)
+		}
+	}
+}
+
+func TestGuestMail(t *testing.T) {
+	res := models.Reservation{FirstName: "<John>", Room: models.Room{RoomName: "General's Quarters"},
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)}
+
+	body, err := guestMail(models.GuestEmailPreArrival, res, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"Dear &lt;John&gt;", "General&#39;s Quarters", "Saturday, January 1", "Monday, January 3, 2050"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	body, err = guestMail(models.GuestEmailPostStay, res, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(body, "review") {
+		t.Errorf("review link without a review url:\n%s", body)
+	}
+	body, err = guestMail(models.GuestEmailPostStay, res, "https://example.com/review?site=1&x=2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(body, `<a href="https://example.com/review?site=1&amp;x=2">`) {
+		t.Errorf("missing the review link in:\n%s", body)
+	}
+
+	if _, err := guestMail("birthday", res, ""); err == nil {
+		t.Error("expected an error for an unknown kind")
+	}
+}
+
+func TestRepository_PostContact(t *testing.T) {
+	//its own config, the mail listener of the tests drains app.MailChan
+	cfg := app
+	cfg.BotGuard = botguard.New(app.EncryptionKey, nil)
+	repo := &Repository{App: &cfg, DB: Repo.DB}
+	//tokens are single use, each case gets a fresh one in place of {token}
+	token := "&form_token={token}"
+
+	var tests = []struct {
+		name               string
+		body               string
+		expectedStatusCode int
+		notified           bool
+	}{
+		{"valid", "name=John&email=john@smith.com&subject=Parking&message=Is+there+parking%3F" + token, http.StatusSeeOther, true},
+		{"no subject", "name=John&email=john@smith.com&message=Hello" + token, http.StatusSeeOther, true},
+		{"missing message", "name=John&email=john@smith.com&message=+" + token, http.StatusOK, false},
+		{"invalid email", "name=John&email=john&message=Hello" + token, http.StatusOK, false},
+		{"message too long", "name=John&email=john@smith.com&message=" + strings.Repeat("a", maxContactMessage+1) + token, http.StatusOK, false},
+		{"honeypot", "name=John&email=john@smith.com&message=Hello&website=spam.example" + token, http.StatusSeeOther, false},
+		{"no token", "name=John&email=john@smith.com&message=Hello", http.StatusSeeOther, false},
+		{"database error", "name=John&email=error@here.com&message=Hello" + token, http.StatusInternalServerError, false},
+	}
+
+	for _, e := range tests {
+		cfg.MailChan = make(chan models.MailData, 10)
+		person, err := cfg.BotGuard.Issue(time.Now().Add(-time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := strings.Replace(e.body, "{token}", url.QueryEscape(person.Token), 1)
+		req, _ := http.NewRequest("POST", "/contact", strings.NewReader(body))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(repo.PostContact)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: PostContact returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		//spam gets the same answer as everybody else
+		if rr.Code == http.StatusSeeOther && rr.Header().Get("Location") != "/contact" {
+			t.Errorf("%s: redirected to %s", e.name, rr.Header().Get("Location"))
+		}
+		if n := len(cfg.MailChan); (n == 1) != e.notified {
+			t.Errorf("%s: got %d notifications", e.name, n)
+		}
+	}
+}
+
+func TestRepository_AdminPostContactReply(t *testing.T) {
+	cfg := app
+	repo := &Repository{App: &cfg, DB: Repo.DB}
+
+	var tests = []struct {
+		name               string
+		id                 string
+		body               string
+		expectedStatusCode int
+	}{
+		{"valid", "1", "body=Yes%2C+for+two+cars.", http.StatusSeeOther},
+		{"empty reply", "1", "body=+", http.StatusOK},
+		{"not found", "99", "body=Hello", http.StatusNotFound},
+		{"bad id", "x", "body=Hello", http.StatusBadRequest},
+		{"database error", "3", "body=Hello", http.StatusInternalServerError},
+	}
+
+	for _, e := range tests {
+		cfg.MailChan = make(chan models.MailData, 10)
+		req, _ := http.NewRequest("POST", "/admin/messages/"+e.id+"/reply", strings.NewReader(e.body))
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(repo.AdminPostContactReply)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostContactReply returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Code != http.StatusSeeOther {
+			if len(cfg.MailChan) != 0 {
+				t.Errorf("%s: a reply was mailed", e.name)
+			}
+			continue
+		}
+		if rr.Header().Get("Location") != "/admin/messages/"+e.id {
+			t.Errorf("%s: redirected to %s", e.name, rr.Header().Get("Location"))
+		}
+		msg := <-cfg.MailChan
+		if msg.To != "john@smith.com" || msg.Subject != "Re: Parking" || !strings.Contains(msg.Content, "Yes, for two cars.") {
+			t.Errorf("%s: unexpected mail %+v", e.name, msg)
+		}
+	}
+}
+
+func TestRepository_AdminPostContactMessageStatus(t *testing.T) {
+	var tests = []struct {
+		name               string
+		id                 string
+		status             string
+		expectedStatusCode int
+		expectedLocation   string
+	}{
+		{"archive", "1", models.ContactArchived, http.StatusSeeOther, "/admin/messages?status=inbox"},
+		{"not spam", "2", models.ContactInbox, http.StatusSeeOther, "/admin/messages?status=spam"},
+		{"unknown status", "1", "trash", http.StatusBadRequest, ""},
+		{"not found", "99", models.ContactArchived, http.StatusNotFound, ""},
+		{"database error", "3", models.ContactInbox, http.StatusInternalServerError, ""},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", "/admin/messages/"+e.id+"/status", strings.NewReader("status="+e.status))
+		ctx := getCtx(req)
+		req = withURLParam(req.WithContext(ctx), "id", e.id)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.AdminPostContactMessageStatus)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s: AdminPostContactMessageStatus returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+		if rr.Header().Get("Location") != e.expectedLocation {
+			t.Errorf("%s: redirected to %q", e.name, rr.Header().Get("Location"))
+		}
+	}
+}
+
+func TestContactMail(t *testing.T) {
+	msg := models.ContactMessage{Name: "<John>", Email: "john@smith.com", Subject: "A & B",
+		Message: "line one\r\n<b>line two</b>", CreatedAt: time.Date(2050, 1, 1, 9, 0, 0, 0, time.UTC)}
+
+	body := contactNotification(msg)
+	for _, want := range []string{"&lt;John&gt; &lt;john@smith.com&gt;", "Subject: A &amp; B", "line one<br>\n&lt;b&gt;line two&lt;/b&gt;"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+
+	body = contactReplyMail(msg, models.ContactReply{Body: "Sure\nsee you"})
+	for _, want := range []string{"Dear &lt;John&gt;", "Sure<br>\nsee you", "On January 1, 2050 you wrote:", "&lt;b&gt;line two"} {
+		if !strings.Contains(body, want) {
+			t.Errorf("missing %q in:\n%s", want, body)
+		}
+	}
+}
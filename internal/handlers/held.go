@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/repository/dbrepo"
+	"github.com/go-chi/chi"
+)
+
+// addBotGuard adds the bot guard fields of a newly served form to data
+func (m *Repository) addBotGuard(data map[string]interface{}) error {
+	if m.App.BotGuard == nil {
+		return nil
+	}
+	guard, err := m.App.BotGuard.Issue(time.Now())
+	if err != nil {
+		return err
+	}
+	data["guard"] = guard
+	return nil
+}
+
+// holdReason returns why a reservation or contact form looks like it was
+// sent by a bot, empty when it passed the bot guard
+func (m *Repository) holdReason(r *http.Request) string {
+	if m.App.BotGuard == nil {
+		return ""
+	}
+	return strings.Join(m.App.BotGuard.Check(r.PostForm, time.Now()), ", ")
+}
+
+// AdminHeldReservations shows the reservations held as possible spam
+func (m *Repository) AdminHeldReservations(w http.ResponseWriter, r *http.Request) {
+	reservations, err := m.DB.HeldReservations(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	render.Template(w, "admin-held-reservations.page.tmpl", &models.TemplateData{
+		Data: data,
+	}, r)
+}
+
+// heldReservation returns the held reservation in the url, or writes the error
+func (m *Repository) heldReservation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return models.Reservation{}, false
+	}
+	res, err := m.DB.GetReservationByID(r.Context(), id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return models.Reservation{}, false
+	}
+	if res.Status != models.ReservationHeld {
+		m.App.Session.Put(r.Context(), "warning", "Reservation is not waiting for approval")
+		http.Redirect(w, r, "/admin/reservations/held", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+	return res, true
+}
+
+// AdminApproveReservation confirms a held reservation if its room is still
+// free and sends the emails a reservation normally gets
+func (m *Repository) AdminApproveReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.heldReservation(w, r)
+	if !ok {
+		return
+	}
+	//the room is checked in the same transaction, so nothing books it in between
+	err := m.DB.ApproveReservation(r.Context(), m.actor(r), res.ID)
+	if errors.Is(err, dbrepo.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "The room has been booked for these dates since, reject the reservation")
+		http.Redirect(w, r, "/admin/reservations/held", http.StatusSeeOther)
+		return
+	}
+	if errors.Is(err, dbrepo.ErrReservationNotHeld) {
+		m.App.Session.Put(r.Context(), "warning", "Reservation is not waiting for approval")
+		http.Redirect(w, r, "/admin/reservations/held", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	res.Status = models.ReservationConfirmed
+	res.HoldReason = ""
+	m.sendReservationEmails(r, res)
+	m.App.Metrics.ReservationsCreated.Inc()
+	m.publish(r, models.EventReservationCreated, res)
+	m.App.Session.Put(r.Context(), "flash", "Reservation approved")
+	http.Redirect(w, r, "/admin/reservations/held", http.StatusSeeOther)
+}
+
+// AdminRejectReservation cancels a held reservation, nobody is told
+func (m *Repository) AdminRejectReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.heldReservation(w, r)
+	if !ok {
+		return
+	}
+	err := m.DB.CancelReservation(r.Context(), m.actor(r), res.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Reservation rejected")
+	http.Redirect(w, r, "/admin/reservations/held", http.StatusSeeOther)
+}
@@ -0,0 +1,152 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/reports"
+	"github.com/go-chi/chi"
+)
+
+// housekeepingDay returns the date asked for, today by default
+func housekeepingDay(r *http.Request) (time.Time, error) {
+	v := r.URL.Query().Get("date")
+	if v == "" {
+		return dateOf(time.Now()), nil
+	}
+	return time.Parse("2006-01-02", v)
+}
+
+// housekeepingSchedule returns what housekeeping has to do in each room on day
+func (m *Repository) housekeepingSchedule(ctx context.Context, day time.Time) ([]reports.RoomDay, error) {
+	rooms, err := m.DB.AllRooms(ctx)
+	if err != nil {
+		return nil, err
+	}
+	//the day before too, for the stays ending on day
+	restrictions, err := m.DB.RoomRestrictionsBetween(ctx, day.AddDate(0, 0, -1), day.AddDate(0, 0, 1))
+	if err != nil {
+		return nil, err
+	}
+	tasks, err := m.DB.HousekeepingTasks(ctx, day)
+	if err != nil {
+		return nil, err
+	}
+	return reports.Housekeeping(day, rooms, restrictions, tasks), nil
+}
+
+// AdminHousekeeping shows the check-outs, check-ins and stay-overs of each
+// room on the date asked for, with the form to set its cleaning status
+func (m *Repository) AdminHousekeeping(w http.ResponseWriter, r *http.Request) {
+	m.renderHousekeeping(w, r, "admin-housekeeping.page.tmpl")
+}
+
+// AdminHousekeepingPrint is AdminHousekeeping without the site around it,
+// for printing
+func (m *Repository) AdminHousekeepingPrint(w http.ResponseWriter, r *http.Request) {
+	m.renderHousekeeping(w, r, "admin-housekeeping-print.page.tmpl")
+}
+
+func (m *Repository) renderHousekeeping(w http.ResponseWriter, r *http.Request, tmpl string) {
+	day, err := housekeepingDay(r)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	schedule, err := m.housekeepingSchedule(r.Context(), day)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["schedule"] = schedule
+	data["statuses"] = models.HousekeepingStatuses
+	data["mail_to"] = m.App.HousekeepingEmail
+	stringMap := make(map[string]string)
+	stringMap["date"] = day.Format("2006-01-02")
+	stringMap["day"] = day.Format("Monday, January 2, 2006")
+	stringMap["previous"] = day.AddDate(0, 0, -1).Format("2006-01-02")
+	stringMap["next"] = day.AddDate(0, 0, 1).Format("2006-01-02")
+
+	render.Template(w, tmpl, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	}, r)
+}
+
+// AdminPostHousekeeping sets the cleaning status of a room on a date
+func (m *Repository) AdminPostHousekeeping(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	day, err := time.Parse("2006-01-02", r.Form.Get("date"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	status := r.Form.Get("status")
+	valid := false
+	for _, s := range models.HousekeepingStatuses {
+		valid = valid || s == status
+	}
+	if !valid {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+
+	task := models.HousekeepingTask{RoomID: roomID, Date: day, Status: status}
+	if err := m.DB.SetHousekeepingStatus(r.Context(), m.actor(r), task); err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Room %d is %s", roomID, status))
+	http.Redirect(w, r, "/admin/housekeeping?date="+day.Format("2006-01-02"), http.StatusSeeOther)
+}
+
+// SendHousekeepingSchedule mails the schedule of day to the housekeeping
+// address, it does nothing when none is configured
+func (m *Repository) SendHousekeepingSchedule(ctx context.Context, day time.Time) error {
+	if m.App.HousekeepingEmail == "" {
+		return nil
+	}
+	schedule, err := m.housekeepingSchedule(ctx, day)
+	if err != nil {
+		return err
+	}
+	m.sendMail(ctx, models.MailData{
+		To:      m.App.HousekeepingEmail,
+		From:    "me@here.com",
+		Subject: "Housekeeping for " + day.Format("Monday, January 2"),
+		Content: housekeepingMail(day, schedule),
+	})
+	return nil
+}
+
+// housekeepingMail returns the schedule as the html body of a mail
+func housekeepingMail(day time.Time, schedule []reports.RoomDay) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "<strong>Housekeeping for %s</strong><br>\n", day.Format("Monday, January 2, 2006"))
+	b.WriteString("<table>\n<tr><th align=\"left\">Room</th><th align=\"left\">Today</th><th align=\"left\">Status</th></tr>\n")
+	for _, d := range schedule {
+		fmt.Fprintf(&b, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+			template.HTMLEscapeString(d.Room.RoomName), d.Activity(), d.Task.Status)
+	}
+	b.WriteString("</table>\n")
+	return b.String()
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/openapi"
+)
+
+// apiSpec builds the OpenAPI document for the JSON endpoints, response
+// schemas are generated from the types the handlers encode
+func apiSpec() *openapi.Document {
+	availability := openapi.SchemaOf(jsonResponse{})
+	availability.Description = "ok is true when the room is free for the whole date range"
+	availability.Properties["start_date"] = openapi.Date("arrival date, empty on error")
+	availability.Properties["end_date"] = openapi.Date("departure date, empty on error")
+	availability.Properties["room_id"] = openapi.String("id of the room that was checked, empty on error")
+
+	reservation := openapi.SchemaOf(apiReservation{})
+	reservation.Properties["start_date"] = openapi.Date("arrival date")
+	reservation.Properties["end_date"] = openapi.Date("departure date")
+	reservationRequest := openapi.SchemaOf(apiReservationRequest{})
+	reservationRequest.Properties["start_date"] = openapi.Date("arrival date")
+	reservationRequest.Properties["end_date"] = openapi.Date("departure date")
+	reservationRequest.Required = []string{"first_name", "last_name", "email", "start_date", "end_date", "room_id"}
+	apiAvailability := openapi.SchemaOf(apiAvailabilityResponse{})
+	apiAvailability.Properties["start_date"] = openapi.Date("arrival date")
+	apiAvailability.Properties["end_date"] = openapi.Date("departure date")
+	apiAvailability.Properties["rooms"] = &openapi.Schema{Type: "array", Items: openapi.SchemaOf(apiRoom{})}
+
+	return &openapi.Document{
+		OpenAPI: openapi.Version,
+		Info: openapi.Info{
+			Title:       "Bookings API",
+			Description: "JSON endpoints of the bookings application.",
+			Version:     "1.0.0",
+		},
+		Paths: map[string]*openapi.PathItem{
+			"/search-availability-json": {
+				Post: &openapi.Operation{
+					OperationID: "searchAvailabilityJSON",
+					Summary:     "Check if a room is available for a date range",
+					Tags:        []string{"availability"},
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content: openapi.Form(&openapi.Schema{
+							Type:     "object",
+							Required: []string{"csrf_token", "start", "end", "room_id"},
+							Properties: map[string]*openapi.Schema{
+								"csrf_token": openapi.String("token from the csrf_token cookie, required by the CSRF middleware"),
+								"start":      openapi.Date("arrival date"),
+								"end":        openapi.Date("departure date"),
+								"room_id":    openapi.Integer("id of the room to check"),
+							},
+						}),
+					},
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "Availability result. Database and form errors are reported with ok=false and a message.",
+							Content:     openapi.JSON(openapi.Ref("AvailabilityResponse")),
+						},
+						"400": {
+							Description: "Missing or invalid CSRF token",
+							Content:     openapi.Text(),
+						},
+						"500": {
+							Description: "start or end is not a yyyy-mm-dd date",
+							Content:     openapi.Text(),
+						},
+					},
+				},
+			},
+			"/api/v1/availability": {
+				Get: &openapi.Operation{
+					OperationID: "getAvailability",
+					Summary:     "List the rooms that are free for a date range",
+					Tags:        []string{"partner"},
+					Security:    apiKeySecurity(models.ScopeAvailabilityRead),
+					Parameters: []openapi.Parameter{
+						{Name: "start", In: "query", Required: true, Schema: openapi.Date("arrival date")},
+						{Name: "end", In: "query", Required: true, Schema: openapi.Date("departure date, after start")},
+					},
+					Responses: apiResponses(map[string]*openapi.Response{
+						"200": {Description: "Available rooms", Content: openapi.JSON(openapi.Ref("Availability"))},
+						"400": {Description: "start or end is missing or invalid", Content: openapi.JSON(openapi.Ref("Error"))},
+					}),
+				},
+			},
+			"/api/v1/reservations": {
+				Get: &openapi.Operation{
+					OperationID: "listReservations",
+					Summary:     "List all reservations",
+					Tags:        []string{"partner"},
+					Security:    apiKeySecurity(models.ScopeAdminRead),
+					Responses: apiResponses(map[string]*openapi.Response{
+						"200": {Description: "Reservations", Content: openapi.JSON(&openapi.Schema{Type: "array", Items: openapi.Ref("Reservation")})},
+					}),
+				},
+				Post: &openapi.Operation{
+					OperationID: "createReservation",
+					Summary:     "Book a room",
+					Description: "Sends the same confirmation emails as a booking made on the website.",
+					Tags:        []string{"partner"},
+					Security:    apiKeySecurity(models.ScopeReservationsCreate),
+					RequestBody: &openapi.RequestBody{
+						Required: true,
+						Content:  openapi.JSON(reservationRequest),
+					},
+					Responses: apiResponses(map[string]*openapi.Response{
+						"201": {Description: "The new reservation", Content: openapi.JSON(openapi.Ref("Reservation"))},
+						"400": {Description: "Invalid body, fields holds the message for each invalid field", Content: openapi.JSON(openapi.Ref("Error"))},
+						"404": {Description: "room_id does not exist", Content: openapi.JSON(openapi.Ref("Error"))},
+						"409": {Description: "The room is not available for these dates", Content: openapi.JSON(openapi.Ref("Error"))},
+					}),
+				},
+			},
+			"/api/openapi.json": {
+				Get: &openapi.Operation{
+					OperationID: "getOpenAPI",
+					Summary:     "This document",
+					Tags:        []string{"meta"},
+					Responses: map[string]*openapi.Response{
+						"200": {
+							Description: "OpenAPI document",
+							Content:     openapi.JSON(&openapi.Schema{Type: "object"}),
+						},
+					},
+				},
+			},
+		},
+		Components: &openapi.Components{
+			Schemas: map[string]*openapi.Schema{
+				"AvailabilityResponse": availability,
+				"Availability":         apiAvailability,
+				"Reservation":          reservation,
+				"Error":                openapi.SchemaOf(helpers.APIError{}),
+			},
+			SecuritySchemes: map[string]*openapi.SecurityScheme{
+				"apiKey": {
+					Type:        "http",
+					Scheme:      "bearer",
+					Description: "Partner api key created by an admin, sent as Authorization: Bearer <key>",
+				},
+			},
+		},
+	}
+}
+
+// apiKeySecurity requires an api key granted scope
+func apiKeySecurity(scope string) []map[string][]string {
+	return []map[string][]string{{"apiKey": {scope}}}
+}
+
+// apiResponses adds the errors every partner api operation can return
+func apiResponses(responses map[string]*openapi.Response) map[string]*openapi.Response {
+	responses["401"] = &openapi.Response{Description: "Missing, unknown or revoked api key", Content: openapi.JSON(openapi.Ref("Error"))}
+	responses["403"] = &openapi.Response{Description: "The api key lacks the required scope", Content: openapi.JSON(openapi.Ref("Error"))}
+	responses["500"] = &openapi.Response{Description: "Database error", Content: openapi.JSON(openapi.Ref("Error"))}
+	return responses
+}
+
+// OpenAPI serves the OpenAPI document describing the JSON endpoints
+func (m *Repository) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	out, err := json.MarshalIndent(apiSpec(), "", "  ")
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(out)
+}
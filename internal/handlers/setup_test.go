@@ -5,15 +5,22 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/loginguard"
+	"github.com/acceleraterA/go_app_udemy/internal/metrics"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/webhooks"
 	"github.com/alexedwards/scs/v2"
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -30,10 +37,11 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Reservation{})
 	//change this to true when in production
 	app.InProduction = false
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	app.InfoLog = infoLog
-	ErrorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	app.ErrorLog = ErrorLog
+	app.LoginPolicy = loginguard.DefaultPolicy()
+	app.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	app.AppBaseURL = "https://fortsmythe.example"
+	app.Logger = logging.New(os.Stdout, false, slog.LevelInfo)
+	app.Metrics = metrics.New()
 	// Initialize a new session manager and configure the session lifetime.
 	session = scs.New()
 	session.Lifetime = 24 * time.Hour
@@ -52,6 +60,7 @@ func TestMain(m *testing.M) {
 	*/
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
+	app.MailSenders = &sync.WaitGroup{}
 	defer close(app.MailChan)
 	fmt.Println("starting mail listener...")
 	//duplicate the function in actual application
@@ -66,8 +75,10 @@ func TestMain(m *testing.M) {
 	// give render access to app
 
 	repo := NewTestRepo(&app)
+	app.Webhooks = webhooks.New(repo.DB, app.Logger)
 	NewHandler(repo)
 	render.NewRenderer(&app)
+	helpers.NewHelper(&app)
 
 	os.Exit(m.Run())
 
@@ -88,12 +99,37 @@ func getRoutes() http.Handler {
 	r.Get("/search-availability", Repo.Availability)
 	r.Post("/search-availability", Repo.PostAvailability)
 	r.Post("/search-availability-json", Repo.AvailabilityJSON)
+	r.Get("/api/openapi.json", Repo.OpenAPI)
+	r.Get("/api/v1/availability", Repo.APIAvailability)
+	r.Post("/api/v1/reservations", Repo.APIPostReservation)
+	r.Get("/api/v1/reservations", Repo.APIReservations)
 
 	r.Get("/contact", Repo.Contact)
 	r.Get("/make-reservation", Repo.Reservation)
 	r.Post("/make-reservation", Repo.PostReservation)
 	r.Get("/reservation-summary", Repo.ReservationSummary)
 
+	r.Get("/admin/dashboard", Repo.AdminDashboard)
+	r.Get("/admin/api-keys", Repo.AdminAPIKeys)
+	r.Get("/admin/reservations", Repo.AdminReservations)
+	r.Get("/admin/reservations/held", Repo.AdminHeldReservations)
+	r.Get("/admin/reservations/export", Repo.AdminExportReservations)
+	r.Get("/admin/reservations/{id}", Repo.AdminShowReservation)
+	r.Get("/admin/housekeeping", Repo.AdminHousekeeping)
+	r.Get("/admin/housekeeping/print", Repo.AdminHousekeepingPrint)
+	r.Get("/admin/messages", Repo.AdminContactMessages)
+	r.Get("/admin/messages/{id}", Repo.AdminShowContactMessage)
+	r.Get("/admin/webhooks", Repo.AdminWebhooks)
+	r.Get("/admin/users", Repo.AdminUsers)
+	r.Get("/admin/login-attempts", Repo.AdminLoginAttempts)
+	r.Get("/admin/audit", Repo.AdminAuditLog)
+	r.Get("/admin/security", Repo.AdminSecurity)
+	r.Get("/admin/users/{id}", Repo.AdminShowUser)
+	r.Get("/user/invite", Repo.ShowAcceptInvite)
+	r.Get("/user/forgot-password", Repo.ShowForgotPassword)
+	r.Get("/user/reset-password", Repo.ShowResetPassword)
+	r.Get("/user/register", Repo.ShowRegister)
+
 	fileServer := http.FileServer(http.Dir("./static/"))
 	r.Handle("/static/*", http.StripPrefix("/static", fileServer))
 	return r
@@ -0,0 +1,290 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/encryption"
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/acceleraterA/go_app_udemy/internal/totp"
+)
+
+// totpIssuer is the account name authenticator apps show
+const totpIssuer = "Fort Smythe Bookings"
+
+// pendingLoginLifetime is how long the second login step may take
+const pendingLoginLifetime = 5 * time.Minute
+
+// recoveryCodeCount is how many recovery codes a user gets on enrolment
+const recoveryCodeCount = 10
+
+// generateRecoveryCodes returns new recovery codes to show once and their hashes to store
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	enc := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for i := 0; i < recoveryCodeCount; i++ {
+		b := make([]byte, 5)
+		if _, err = rand.Read(b); err != nil {
+			return nil, nil, err
+		}
+		code := strings.ToLower(enc.EncodeToString(b))
+		codes = append(codes, code[:4]+"-"+code[4:])
+		hashes = append(hashes, helpers.HashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode strips what users tend to add when typing a code
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// checkSecondFactor accepts a current totp code that wasn't used before or an unused recovery code
+func (m *Repository) checkSecondFactor(r *http.Request, user models.User, code string) bool {
+	secret, err := encryption.Decrypt(m.App.EncryptionKey, user.TOTPSecret)
+	if err != nil {
+		m.App.Logger.ErrorContext(r.Context(), "can't decrypt totp secret", "user_id", user.ID, "err", err)
+		return false
+	}
+	if counter, ok := totp.Validate(secret, code, time.Now()); ok {
+		return m.DB.UseTOTPCounter(r.Context(), user.ID, counter) == nil
+	}
+	return m.DB.UseRecoveryCode(r.Context(), user.ID, helpers.HashToken(normalizeRecoveryCode(code))) == nil
+}
+
+// pendingUser returns the user who passed the password step of the login
+func (m *Repository) pendingUser(r *http.Request) (models.User, bool) {
+	id := m.App.Session.GetInt(r.Context(), "pending_user_id")
+	started := time.Unix(m.App.Session.GetInt64(r.Context(), "pending_at"), 0)
+	if id == 0 || time.Since(started) > pendingLoginLifetime {
+		return models.User{}, false
+	}
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err != nil || !user.TOTPEnabled {
+		return models.User{}, false
+	}
+	return user, true
+}
+
+// ShowLoginTwoFactor shows the second login step
+func (m *Repository) ShowLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	if _, ok := m.pendingUser(r); !ok {
+		m.App.Session.Put(r.Context(), "error", "Log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	render.Template(w, "login-2fa.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	}, r)
+}
+
+// PostLoginTwoFactor checks the code of the second login step and finishes the login
+func (m *Repository) PostLoginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, ok := m.pendingUser(r)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Log in again")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	//wrong codes count as failed logins of the account
+	attempt := models.LoginAttempt{Email: user.Email, IPAddress: helpers.ClientIP(r)}
+	if m.loginThrottled(w, r, attempt, "/user/login/2fa") {
+		return
+	}
+	if !m.checkSecondFactor(r, user, r.Form.Get("code")) {
+		m.recordLoginAttempt(r, attempt)
+		m.App.Session.Put(r.Context(), "error", "Invalid code")
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+	attempt.Success = true
+	m.recordLoginAttempt(r, attempt)
+
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "pending_user_id")
+	m.App.Session.Remove(r.Context(), "pending_at")
+	http.Redirect(w, r, m.logIn(r, user), http.StatusSeeOther)
+}
+
+// TwoFactor shows the two factor status of the logged in user, with the
+// secret and provisioning uri to enrol when it is off
+func (m *Repository) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.renderTwoFactor(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderTwoFactor(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	policy, err := m.DB.TwoFactorPolicy(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["required"] = policy[user.AccessLevel]
+	//the codes are only shown once, right after enrolment
+	if codes, ok := m.App.Session.Pop(r.Context(), "recovery_codes").([]string); ok {
+		data["recovery_codes"] = codes
+	}
+
+	stringMap := make(map[string]string)
+	if !user.TOTPEnabled {
+		//keep the secret in the session until the user proves they saved it
+		secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+		if secret == "" {
+			secret, err = totp.GenerateSecret()
+			if err != nil {
+				helpers.ServerError(w, r, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_setup_secret", secret)
+		}
+		stringMap["secret"] = secret
+		stringMap["uri"] = totp.ProvisioningURI(totpIssuer, user.Email, secret)
+	}
+
+	render.Template(w, "two-factor.page.tmpl", &models.TemplateData{
+		Form:      form,
+		Data:      data,
+		StringMap: stringMap,
+	}, r)
+}
+
+// PostEnableTwoFactor turns two factor authentication on once the user
+// entered a code from the new secret
+func (m *Repository) PostEnableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+	counter, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if secret == "" || !ok {
+		form.Errors.Add("code", "That code is not right, check the time on your device and try again")
+		m.renderTwoFactor(w, r, user, form)
+		return
+	}
+
+	sealed, err := encryption.Encrypt(m.App.EncryptionKey, secret)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = m.DB.EnableTOTP(r.Context(), m.actor(r), user.ID, sealed, hashes)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	//the code used to enrol can't log in again
+	_ = m.DB.UseTOTPCounter(r.Context(), user.ID, counter)
+
+	m.App.Session.Remove(r.Context(), "totp_setup_secret")
+	m.App.Session.Remove(r.Context(), "must_enroll_2fa")
+	m.App.Session.Put(r.Context(), "recovery_codes", codes)
+	m.App.Session.Put(r.Context(), "flash", "Two factor authentication is on")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// PostDisableTwoFactor turns two factor authentication off after checking
+// the password, unless the user's role requires it
+func (m *Repository) PostDisableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	if id, _, err := m.DB.Authenticate(r.Context(), user.Email, form.Get("password")); err != nil || id != user.ID {
+		form.Errors.Add("password", "Password is incorrect")
+	}
+	policy, err := m.DB.TwoFactorPolicy(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	if policy[user.AccessLevel] {
+		form.Errors.Add("password", "Your role requires two factor authentication")
+	}
+	if !form.Valid() {
+		m.renderTwoFactor(w, r, user, form)
+		return
+	}
+
+	err = m.DB.DisableTOTP(r.Context(), m.actor(r), user.ID)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Two factor authentication is off")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+}
+
+// AdminSecurity shows which roles must use two factor authentication
+func (m *Repository) AdminSecurity(w http.ResponseWriter, r *http.Request) {
+	policy, err := m.DB.TwoFactorPolicy(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["roles"] = models.Roles
+	data["require_2fa"] = policy
+
+	render.Template(w, "admin-security.page.tmpl", &models.TemplateData{
+		Data: data,
+	}, r)
+}
+
+// AdminPostSecurity saves which roles must use two factor authentication,
+// users of a role are asked to enrol the next time they log in
+func (m *Repository) AdminPostSecurity(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	for level := range models.Roles {
+		err = m.DB.SetTwoFactorRequired(r.Context(), m.actor(r), level, r.Form.Get("require_2fa_"+strconv.Itoa(level)) != "")
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	m.App.Session.Put(r.Context(), "flash", "Security settings saved")
+	http.Redirect(w, r, "/admin/security", http.StatusSeeOther)
+}
@@ -0,0 +1,597 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/forms"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/render"
+	"github.com/go-chi/chi"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// bcryptCost is the work factor for every password hash the app writes
+const bcryptCost = 12
+
+// inviteLifetime is how long an invitation link stays valid
+const inviteLifetime = 72 * time.Hour
+
+// resetLifetime is how long a password reset link stays valid
+const resetLifetime = time.Hour
+
+// minPasswordLength applies to every password a user chooses
+const minPasswordLength = 8
+
+// currentUser returns the logged in user, from the request context when the
+// Auth middleware ran and from the database otherwise
+func (m *Repository) currentUser(r *http.Request) (models.User, error) {
+	if user, ok := helpers.UserFromContext(r.Context()); ok {
+		return user, nil
+	}
+	id := m.App.Session.GetInt(r.Context(), "user_id")
+	if id == 0 {
+		return models.User{}, errors.New("not logged in")
+	}
+	return m.DB.GetUserByID(r.Context(), id)
+}
+
+// checkNewPassword validates a new password and its confirmation
+func checkNewPassword(form *forms.Form, field, confirm string) {
+	form.Required(field, confirm)
+	form.MinLength(field, minPasswordLength)
+	if form.Get(field) != form.Get(confirm) {
+		form.Errors.Add(confirm, "Passwords do not match")
+	}
+}
+
+// checkAccessLevel validates the access_level field and returns its value
+func checkAccessLevel(form *forms.Form) int {
+	level, err := strconv.Atoi(form.Get("access_level"))
+	if _, ok := models.Roles[level]; err != nil || !ok {
+		form.Errors.Add("access_level", "Choose a role")
+	}
+	return level
+}
+
+// isDuplicateEmail reports whether an insert or update failed on the unique email index
+func isDuplicateEmail(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "unique")
+}
+
+// appURL returns the link to path for an email, on the configured base url
+// and never the host of the request, which the client chooses
+func (m *Repository) appURL(path string) string {
+	return m.App.AppBaseURL + path
+}
+
+// AdminUsers lists the staff accounts with the form to add one
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	m.renderUsers(w, r, forms.New(nil))
+}
+
+func (m *Repository) renderUsers(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	users, err := m.DB.AllUsers(r.Context())
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	data := make(map[string]interface{})
+	data["users"] = users
+	data["roles"] = models.Roles
+
+	render.Template(w, "admin-users.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// AdminPostUser creates a staff account, when no password is given the user
+// is invited by email to choose one
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	level := checkAccessLevel(form)
+	password := form.Get("password")
+	invite := password == ""
+	if !invite {
+		checkNewPassword(form, "password", "password_confirm")
+	}
+	if !form.Valid() {
+		m.renderUsers(w, r, form)
+		return
+	}
+
+	if invite {
+		//nobody knows this password, the invitation replaces it
+		password, _, err = helpers.GenerateToken()
+		if err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcryptCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	user := models.User{
+		FirstName:   form.Get("first_name"),
+		LastName:    form.Get("last_name"),
+		Email:       form.Get("email"),
+		Password:    string(hash),
+		AccessLevel: level,
+		Active:      true,
+	}
+	user.ID, err = m.DB.InsertUser(r.Context(), m.actor(r), user)
+	if isDuplicateEmail(err) {
+		form.Errors.Add("email", "A user with this email already exists")
+		m.renderUsers(w, r, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	if invite {
+		if err := m.sendInvite(r, user); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", "Invitation sent to "+user.Email)
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "User created")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// sendInvite mails a single use link the user can set their password with
+func (m *Repository) sendInvite(r *http.Request, user models.User) error {
+	token, hash, err := helpers.GenerateToken()
+	if err != nil {
+		return err
+	}
+	err = m.DB.InsertUserToken(r.Context(), models.UserToken{
+		UserID:    user.ID,
+		Scope:     models.TokenScopeInvite,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(inviteLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := m.appURL("/user/invite?token=" + token)
+	m.sendMail(r.Context(), models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: "You have been invited to Fort Smythe Bed & Breakfast",
+		Content: fmt.Sprintf(`
+	<strong>Welcome</strong><br>
+	Dear %s, <br>
+	An account has been created for you. Choose your password here within %d hours:<br>
+	<a href="%s">%s</a>
+	`, user.FirstName, int(inviteLifetime.Hours()), link, link),
+	})
+	return nil
+}
+
+// staffUser returns the staff account with this id, guest accounts have no
+// role to edit and aren't managed on the users pages
+func (m *Repository) staffUser(r *http.Request, id int) (models.User, error) {
+	user, err := m.DB.GetUserByID(r.Context(), id)
+	if err == nil && user.AccessLevel == models.AccessLevelGuest {
+		err = errors.New("not a staff account")
+	}
+	return user, err
+}
+
+// AdminShowUser shows the form to edit a staff account
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	user, err := m.staffUser(r, id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	m.renderUser(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderUser(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	data["roles"] = models.Roles
+	render.Template(w, "admin-user-show.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// AdminPostUserUpdate saves the name, email, role and active flag of a staff account
+func (m *Repository) AdminPostUserUpdate(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	user, err := m.staffUser(r, id)
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusNotFound)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+	level := checkAccessLevel(form)
+	active := form.Has("active")
+	//owners can't lock themselves out
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		if level < user.AccessLevel {
+			form.Errors.Add("access_level", "You can't lower your own role")
+		}
+		if !active {
+			form.Errors.Add("active", "You can't deactivate yourself")
+		}
+	}
+
+	user.FirstName = form.Get("first_name")
+	user.LastName = form.Get("last_name")
+	user.Email = form.Get("email")
+	user.AccessLevel = level
+	user.Active = active
+	if !form.Valid() {
+		m.renderUser(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), m.actor(r), user)
+	if isDuplicateEmail(err) {
+		form.Errors.Add("email", "A user with this email already exists")
+		m.renderUser(w, r, user, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "User saved")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminDeleteUser deletes a staff account
+func (m *Repository) AdminDeleteUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ClientError(w, r, http.StatusBadRequest)
+		return
+	}
+	if id == m.App.Session.GetInt(r.Context(), "user_id") {
+		m.App.Session.Put(r.Context(), "error", "You can't delete yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.DeleteUser(r.Context(), m.actor(r), id)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "User deleted")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// ShowAcceptInvite shows the form to choose a password for an invitation
+func (m *Repository) ShowAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = r.URL.Query().Get("token")
+	render.Template(w, "accept-invite.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	}, r)
+}
+
+// PostAcceptInvite sets the password of an invited user and uses up the token
+func (m *Repository) PostAcceptInvite(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	checkNewPassword(form, "password", "password_confirm")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = form.Get("token")
+		render.Template(w, "accept-invite.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		}, r)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("password")), bcryptCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	id, err := m.DB.ConsumeUserToken(r.Context(), models.TokenScopeInvite, helpers.HashToken(form.Get("token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This invitation is invalid or has expired, ask for a new one")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.UpdateUserPassword(r.Context(), m.tokenActor(r, id), id, string(hash))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Password set, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Profile shows the logged in user's details and the change password form
+func (m *Repository) Profile(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	m.renderProfile(w, r, user, forms.New(nil))
+}
+
+func (m *Repository) renderProfile(w http.ResponseWriter, r *http.Request, user models.User, form *forms.Form) {
+	data := make(map[string]interface{})
+	data["user"] = user
+	render.Template(w, "profile.page.tmpl", &models.TemplateData{
+		Form: form,
+		Data: data,
+	}, r)
+}
+
+// PostProfile saves the logged in user's name and email
+func (m *Repository) PostProfile(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	emailChanged := !strings.EqualFold(user.Email, form.Get("email"))
+	user.FirstName = form.Get("first_name")
+	user.LastName = form.Get("last_name")
+	user.Email = form.Get("email")
+	if !form.Valid() {
+		m.renderProfile(w, r, user, form)
+		return
+	}
+
+	err = m.DB.UpdateUser(r.Context(), m.actor(r), user)
+	if isDuplicateEmail(err) {
+		form.Errors.Add("email", "A user with this email already exists")
+		m.renderProfile(w, r, user, form)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	//the new address is unverified, bookings made under it stay hidden
+	//until the user proves it is theirs
+	if emailChanged {
+		if err := m.sendEmailChangeVerification(r, user); err != nil {
+			helpers.ServerError(w, r, err)
+			return
+		}
+		m.App.Session.Put(r.Context(), "flash", "Profile saved, check your email to verify your new address")
+		http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+		return
+	}
+	m.App.Session.Put(r.Context(), "flash", "Profile saved")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// PostPassword changes the logged in user's password after checking the current one
+func (m *Repository) PostPassword(w http.ResponseWriter, r *http.Request) {
+	user, err := m.currentUser(r)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("current_password")
+	checkNewPassword(form, "new_password", "new_password_confirm")
+	if form.Get("current_password") != "" {
+		id, _, err := m.DB.Authenticate(r.Context(), user.Email, form.Get("current_password"))
+		if err != nil || id != user.ID {
+			form.Errors.Add("current_password", "Current password is incorrect")
+		}
+	}
+	if !form.Valid() {
+		m.renderProfile(w, r, user, form)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("new_password")), bcryptCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	err = m.DB.UpdateUserPassword(r.Context(), userActor(user), user.ID, string(hash))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	//the new password logs out every other session, keep this one
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "session_version", user.SessionVersion+1)
+	m.App.Session.Put(r.Context(), "flash", "Password changed")
+	http.Redirect(w, r, "/user/profile", http.StatusSeeOther)
+}
+
+// ShowForgotPassword shows the form to request a password reset link
+func (m *Repository) ShowForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	}, r)
+}
+
+// PostForgotPassword mails a reset link if the email belongs to an active user,
+// the response is the same either way so it can't be used to find accounts
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+	if !form.Valid() {
+		render.Template(w, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		}, r)
+		return
+	}
+
+	//look the user up in the background so the response time doesn't tell either
+	email := form.Get("email")
+	//the request is over by the time this runs, keep only its id for the logs
+	req, _ := logging.RequestFromContext(r.Context())
+	//shutdown waits for it before closing the mail queue
+	m.App.MailSenders.Add(1)
+	go func() {
+		defer m.App.MailSenders.Done()
+		ctx := logging.WithRequest(context.Background(), req)
+		if err := m.sendPasswordReset(ctx, email); err != nil {
+			m.App.Logger.ErrorContext(ctx, "can't send password reset", "err", err)
+		}
+	}()
+
+	m.App.Session.Put(r.Context(), "flash", "If that email belongs to an account, a reset link is on its way")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// sendPasswordReset stores a reset token for the user with this email and mails the link
+func (m *Repository) sendPasswordReset(ctx context.Context, email string) error {
+	user, err := m.DB.GetUserByEmail(ctx, email)
+	if err != nil || !user.Active {
+		return nil
+	}
+	token, hash, err := helpers.GenerateToken()
+	if err != nil {
+		return err
+	}
+	err = m.DB.InsertUserToken(ctx, models.UserToken{
+		UserID:    user.ID,
+		Scope:     models.TokenScopePasswordReset,
+		TokenHash: hash,
+		ExpiresAt: time.Now().Add(resetLifetime),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := m.appURL("/user/reset-password?token=" + token)
+	m.sendMail(ctx, models.MailData{
+		To:      user.Email,
+		From:    "me@here.com",
+		Subject: "Reset your password",
+		Content: fmt.Sprintf(`
+	<strong>Password Reset</strong><br>
+	Dear %s, <br>
+	Somebody asked to reset your password. If it was you, choose a new one within %d minutes:<br>
+	<a href="%s">%s</a><br>
+	If it wasn't you, you can ignore this email.
+	`, user.FirstName, int(resetLifetime.Minutes()), link, link),
+		Template: "basic.html",
+	})
+	return nil
+}
+
+// ShowResetPassword shows the form to choose a new password
+func (m *Repository) ShowResetPassword(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["token"] = r.URL.Query().Get("token")
+	render.Template(w, "reset-password.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	}, r)
+}
+
+// PostResetPassword uses up a reset token and sets the new password, which
+// logs out every session of that user
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	checkNewPassword(form, "password", "password_confirm")
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["token"] = form.Get("token")
+		render.Template(w, "reset-password.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		}, r)
+		return
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(form.Get("password")), bcryptCost)
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	id, err := m.DB.ConsumeUserToken(r.Context(), models.TokenScopePasswordReset, helpers.HashToken(form.Get("token")))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "This reset link is invalid or has expired, request a new one")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+	err = m.DB.UpdateUserPassword(r.Context(), m.tokenActor(r, id), id, string(hash))
+	if err != nil {
+		helpers.ServerError(w, r, err)
+		return
+	}
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "flash", "Password changed, you can log in now")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
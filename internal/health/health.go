@@ -0,0 +1,160 @@
+// Package health serves the liveness and readiness probes of load balancers
+// and orchestrators
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultTimeout is how long each readiness check may take
+const DefaultTimeout = 2 * time.Second
+
+// Check statuses
+const (
+	StatusOK          = "ok"
+	StatusError       = "error"
+	StatusUnavailable = "unavailable"
+	StatusDraining    = "draining"
+)
+
+// Check returns an error when a dependency can't be used, it must give up
+// when ctx is done
+type Check func(ctx context.Context) error
+
+// Checker runs the readiness checks, Drain makes it report not ready while
+// the server shuts down
+type Checker struct {
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	names    []string
+	checks   map[string]Check
+	draining atomic.Bool
+}
+
+// Result is the outcome of one check
+type Result struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the body of /readyz
+type Report struct {
+	Status string            `json:"status"`
+	Checks map[string]Result `json:"checks,omitempty"`
+}
+
+// New returns a checker without checks, timeout 0 means DefaultTimeout
+func New(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	return &Checker{Timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add adds a readiness check, a check added twice replaces the first one
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.checks[name]; !ok {
+		c.names = append(c.names, name)
+	}
+	c.checks[name] = check
+}
+
+// Drain makes the checker report not ready from now on
+func (c *Checker) Drain() {
+	c.draining.Store(true)
+}
+
+// Draining reports whether Drain was called
+func (c *Checker) Draining() bool {
+	return c.draining.Load()
+}
+
+// Run runs every check at the same time, each with the timeout; the report
+// is ok only when all of them passed and the checker isn't draining
+func (c *Checker) Run(ctx context.Context) Report {
+	if c.Draining() {
+		return Report{Status: StatusDraining}
+	}
+	c.mu.Lock()
+	names := append([]string(nil), c.names...)
+	checks := make([]Check, len(names))
+	for i, name := range names {
+		checks[i] = c.checks[name]
+	}
+	c.mu.Unlock()
+
+	results := make([]Result, len(names))
+	var wg sync.WaitGroup
+	for i := range checks {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = c.run(ctx, checks[i])
+		}(i)
+	}
+	wg.Wait()
+
+	report := Report{Status: StatusOK, Checks: make(map[string]Result, len(names))}
+	for i, name := range names {
+		report.Checks[name] = results[i]
+		if results[i].Status != StatusOK {
+			report.Status = StatusUnavailable
+		}
+	}
+	return report
+}
+
+// run runs one check, a check that ignores its context still fails on time
+func (c *Checker) run(ctx context.Context, check Check) Result {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	start := time.Now()
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	res := Result{Status: StatusOK, Duration: time.Since(start).Round(time.Microsecond).String()}
+	if err != nil {
+		res.Status = StatusError
+		res.Error = err.Error()
+	}
+	return res
+}
+
+// Live answers the liveness probe, the process is alive when it can answer
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	writeReport(w, http.StatusOK, Report{Status: StatusOK})
+}
+
+// Ready answers the readiness probe with the result of every check, and 503
+// when one failed or the server is draining
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Run(r.Context())
+	status := http.StatusOK
+	if report.Status != StatusOK {
+		status = http.StatusServiceUnavailable
+	}
+	writeReport(w, status, report)
+}
+
+func writeReport(w http.ResponseWriter, status int, report Report) {
+	out, _ := json.Marshal(report)
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(out)
+}
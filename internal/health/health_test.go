@@ -0,0 +1,76 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func ready(t *testing.T, c *Checker) (int, Report) {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	c.Ready(rr, httptest.NewRequest("GET", "/readyz", nil))
+	var report Report
+	if err := json.Unmarshal(rr.Body.Bytes(), &report); err != nil {
+		t.Fatalf("body is not json: %s", rr.Body.String())
+	}
+	return rr.Code, report
+}
+
+func TestChecker_Ready(t *testing.T) {
+	c := New(50 * time.Millisecond)
+	c.Add("database", func(ctx context.Context) error { return nil })
+	c.Add("templates", func(ctx context.Context) error { return nil })
+
+	code, report := ready(t, c)
+	if code != http.StatusOK || report.Status != StatusOK {
+		t.Fatalf("all checks pass: got %d %+v", code, report)
+	}
+	if len(report.Checks) != 2 || report.Checks["database"].Status != StatusOK {
+		t.Errorf("missing check results: %+v", report.Checks)
+	}
+
+	c.Add("mail", func(ctx context.Context) error { return errors.New("connection refused") })
+	code, report = ready(t, c)
+	if code != http.StatusServiceUnavailable || report.Status != StatusUnavailable {
+		t.Fatalf("failing check: got %d %+v", code, report)
+	}
+	if mail := report.Checks["mail"]; mail.Status != StatusError || mail.Error != "connection refused" {
+		t.Errorf("failing check not reported: %+v", mail)
+	}
+	if report.Checks["database"].Status != StatusOK {
+		t.Errorf("passing check reported as %+v", report.Checks["database"])
+	}
+
+	//a check that ignores its context still times out
+	c.Add("mail", func(ctx context.Context) error { time.Sleep(time.Second); return nil })
+	start := time.Now()
+	code, report = ready(t, c)
+	if code != http.StatusServiceUnavailable || report.Checks["mail"].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("slow check: got %d %+v", code, report)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Errorf("slow check held the probe for %s", time.Since(start))
+	}
+}
+
+func TestChecker_Drain(t *testing.T) {
+	c := New(0)
+	c.Add("database", func(ctx context.Context) error { return nil })
+	c.Drain()
+
+	code, report := ready(t, c)
+	if code != http.StatusServiceUnavailable || report.Status != StatusDraining {
+		t.Errorf("draining: got %d %+v", code, report)
+	}
+
+	rr := httptest.NewRecorder()
+	c.Live(rr, httptest.NewRequest("GET", "/healthz", nil))
+	if rr.Code != http.StatusOK {
+		t.Errorf("a draining server is still alive, got %d", rr.Code)
+	}
+}
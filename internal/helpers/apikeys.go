@@ -0,0 +1,24 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+)
+
+// GenerateAPIKey returns a new random api key, the prefix shown to admins and the hash to store
+func GenerateAPIKey() (key, prefix, hash string, err error) {
+	b := make([]byte, 24)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", "", err
+	}
+	key = "bk_" + base64.RawURLEncoding.EncodeToString(b)
+	return key, key[:11], HashAPIKey(key), nil
+}
+
+// HashAPIKey returns the hex encoded sha256 hash of an api key
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
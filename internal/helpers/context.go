@@ -0,0 +1,36 @@
+package helpers
+
+import (
+	"context"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+type contextKey string
+
+const (
+	apiKeyContextKey contextKey = "api_key"
+	userContextKey   contextKey = "user"
+)
+
+// WithAPIKey stores the api key of the caller in ctx
+func WithAPIKey(ctx context.Context, key models.APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey, key)
+}
+
+// APIKeyFromContext returns the api key of the caller, if the request was made with one
+func APIKeyFromContext(ctx context.Context) (models.APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey).(models.APIKey)
+	return key, ok
+}
+
+// WithUser stores the logged in user in ctx
+func WithUser(ctx context.Context, u models.User) context.Context {
+	return context.WithValue(ctx, userContextKey, u)
+}
+
+// UserFromContext returns the logged in user, if the Auth middleware loaded one
+func UserFromContext(ctx context.Context) (models.User, bool) {
+	u, ok := ctx.Value(userContextKey).(models.User)
+	return u, ok
+}
@@ -1,9 +1,11 @@
 package helpers
 
 import (
-	"fmt"
+	"encoding/json"
+	"net"
 	"net/http"
 	"runtime/debug"
+	"strings"
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
 )
@@ -15,14 +17,13 @@ func NewHelper(a *config.AppConfig) {
 	app = a
 }
 
-func ClientError(w http.ResponseWriter, status int) {
-	app.InfoLog.Println("Client error with status of ", status)
+func ClientError(w http.ResponseWriter, r *http.Request, status int) {
+	app.Logger.InfoContext(r.Context(), "client error", "status", status)
 	http.Error(w, http.StatusText(status), status)
 
 }
-func ServerError(w http.ResponseWriter, err error) {
-	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
-	app.ErrorLog.Println(trace)
+func ServerError(w http.ResponseWriter, r *http.Request, err error) {
+	app.Logger.ErrorContext(r.Context(), err.Error(), "stack", string(debug.Stack()))
 	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 }
 
@@ -30,3 +31,100 @@ func IsAuthenticated(r *http.Request) bool {
 	exists := app.Session.Exists(r.Context(), "user_id")
 	return exists
 }
+
+// ClientIP returns the ip address the request came from; behind trusted
+// proxies it is the last X-Forwarded-For address not added by one of them,
+// since anything further left can be made up by the client
+func ClientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(forwarded[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		if !trustedProxy(ip) {
+			return ip
+		}
+	}
+	return host
+}
+
+// FromTrustedProxy reports whether the request came through one of
+// app.TrustedProxies, whose headers can be believed
+func FromTrustedProxy(r *http.Request) bool {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return trustedProxy(host)
+}
+
+// trustedProxy reports whether ip belongs to one of app.TrustedProxies
+func trustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if app == nil || parsed == nil {
+		return false
+	}
+	for _, n := range app.TrustedProxies {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseTrustedProxies parses a comma separated list of proxy addresses and
+// networks, like "10.0.0.0/8, 192.0.2.7"
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, s := range strings.Split(list, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+// APIError is the body of every error returned by the JSON api
+type APIError struct {
+	Error  string              `json:"error"`
+	Fields map[string][]string `json:"fields,omitempty"`
+}
+
+// WriteJSON writes v as an indented JSON response with the given status
+func WriteJSON(w http.ResponseWriter, status int, v interface{}) {
+	out, err := json.MarshalIndent(v, "", "     ")
+	if err != nil {
+		app.Logger.Error("can't encode json response", "err", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(out)
+}
+
+// ErrorJSON writes an APIError response with the given status
+func ErrorJSON(w http.ResponseWriter, status int, message string) {
+	WriteJSON(w, status, APIError{Error: message})
+}
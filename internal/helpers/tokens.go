@@ -0,0 +1,21 @@
+package helpers
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+)
+
+// GenerateToken returns a random url safe token to mail to a user and the hash to store
+func GenerateToken() (token, hash string, err error) {
+	b := make([]byte, 32)
+	if _, err = rand.Read(b); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
+}
+
+// HashToken returns the hex encoded sha256 hash of a mailed token
+func HashToken(token string) string {
+	return HashAPIKey(token)
+}
@@ -0,0 +1,79 @@
+package logging
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"log/slog"
+)
+
+// New returns the application logger, JSON for log collectors in production
+// and text for people otherwise. Lines logged with a request context carry
+// the request, see WithRequest
+func New(w io.Writer, production bool, level slog.Level) *slog.Logger {
+	opts := &slog.HandlerOptions{Level: level, AddSource: true}
+	var h slog.Handler
+	if production {
+		h = slog.NewJSONHandler(w, opts)
+	} else {
+		h = slog.NewTextHandler(w, opts)
+	}
+	return slog.New(requestHandler{h})
+}
+
+// Discard returns a logger that drops everything, for tests
+func Discard() *slog.Logger {
+	return slog.New(slog.NewTextHandler(io.Discard, nil))
+}
+
+// Request is what every line logged while serving a request carries
+type Request struct {
+	ID     string
+	Method string
+	Path   string
+}
+
+type requestKey struct{}
+
+// WithRequest returns a context whose log lines carry req
+func WithRequest(ctx context.Context, req Request) context.Context {
+	return context.WithValue(ctx, requestKey{}, req)
+}
+
+// RequestFromContext returns the request stored by WithRequest
+func RequestFromContext(ctx context.Context) (Request, bool) {
+	req, ok := ctx.Value(requestKey{}).(Request)
+	return req, ok
+}
+
+// NewRequestID returns a random request id
+func NewRequestID() string {
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestHandler adds the request in the context to every record
+type requestHandler struct {
+	slog.Handler
+}
+
+func (h requestHandler) Handle(ctx context.Context, r slog.Record) error {
+	if req, ok := RequestFromContext(ctx); ok {
+		r.AddAttrs(
+			slog.String("request_id", req.ID),
+			slog.String("method", req.Method),
+			slog.String("path", req.Path),
+		)
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h requestHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return requestHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h requestHandler) WithGroup(name string) slog.Handler {
+	return requestHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,42 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestNew(t *testing.T) {
+	var buf bytes.Buffer
+	logger := New(&buf, true, slog.LevelInfo)
+	ctx := WithRequest(context.Background(), Request{ID: "abc", Method: "POST", Path: "/make-reservation"})
+
+	logger.With("component", "test").InfoContext(ctx, "hello", "room", 1)
+	logger.DebugContext(ctx, "below the level")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("expected one line, got %d: %s", len(lines), buf.String())
+	}
+	var line map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &line); err != nil {
+		t.Fatalf("production logs are not JSON: %s", err)
+	}
+	for key, expected := range map[string]interface{}{
+		"msg": "hello", "request_id": "abc", "method": "POST", "path": "/make-reservation",
+		"component": "test", "room": float64(1),
+	} {
+		if line[key] != expected {
+			t.Errorf("%s: expected %v but got %v", key, expected, line[key])
+		}
+	}
+
+	buf.Reset()
+	New(&buf, false, slog.LevelInfo).Info("no request")
+	if strings.Contains(buf.String(), "request_id") || !strings.Contains(buf.String(), "msg=\"no request\"") {
+		t.Errorf("unexpected text line: %s", buf.String())
+	}
+}
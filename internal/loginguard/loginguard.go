@@ -0,0 +1,76 @@
+package loginguard
+
+import (
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// Policy decides how long a login has to wait after recent failures. Every
+// failure doubles the delay and too many failures lock the login out for a while
+type Policy struct {
+	// Window is how far back failures are counted
+	Window time.Duration
+	// BaseDelay is the wait after the first failure, MaxDelay caps the doubling
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	// AccountLimit and IPLimit are the failures that trigger a lockout
+	AccountLimit int
+	IPLimit      int
+	// Lockout is how long a lockout lasts after the last failure
+	Lockout time.Duration
+}
+
+// DefaultPolicy locks an account out for 15 minutes after 5 failures and an
+// ip address after 20, failures before that slow down from one second
+func DefaultPolicy() Policy {
+	return Policy{
+		Window:       15 * time.Minute,
+		BaseDelay:    time.Second,
+		MaxDelay:     time.Minute,
+		AccountLimit: 5,
+		IPLimit:      20,
+		Lockout:      15 * time.Minute,
+	}
+}
+
+// Check returns how long the caller has to wait before the next attempt is
+// allowed and whether any of the limits is locked out, a zero wait means go ahead
+func (p Policy) Check(f models.LoginFailures, now time.Time) (wait time.Duration, locked bool) {
+	accountWait, accountLocked := p.check(f.Account, p.AccountLimit, f.AccountLast, now)
+	ipWait, ipLocked := p.check(f.IP, p.IPLimit, f.IPLast, now)
+	wait = accountWait
+	if ipWait > wait {
+		wait = ipWait
+	}
+	return wait, accountLocked || ipLocked
+}
+
+// check applies the policy to the failures of one account or ip address
+func (p Policy) check(failures, limit int, last, now time.Time) (time.Duration, bool) {
+	if failures == 0 {
+		return 0, false
+	}
+	if failures >= limit {
+		if wait := last.Add(p.Lockout).Sub(now); wait > 0 {
+			return wait, true
+		}
+		return 0, false
+	}
+	if wait := last.Add(p.delay(failures)).Sub(now); wait > 0 {
+		return wait, false
+	}
+	return 0, false
+}
+
+// delay returns BaseDelay doubled for every failure after the first
+func (p Policy) delay(failures int) time.Duration {
+	d := p.BaseDelay
+	for i := 1; i < failures; i++ {
+		d *= 2
+		if d >= p.MaxDelay {
+			return p.MaxDelay
+		}
+	}
+	return d
+}
@@ -0,0 +1,37 @@
+package loginguard
+
+import (
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+func TestPolicy_Check(t *testing.T) {
+	p := DefaultPolicy()
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	var tests = []struct {
+		name           string
+		failures       models.LoginFailures
+		expectedWait   time.Duration
+		expectedLocked bool
+	}{
+		{"no failures", models.LoginFailures{}, 0, false},
+		{"first failure just now", models.LoginFailures{Account: 1, AccountLast: now}, time.Second, false},
+		{"delay doubles", models.LoginFailures{Account: 3, AccountLast: now}, 4 * time.Second, false},
+		{"delay has passed", models.LoginFailures{Account: 3, AccountLast: now.Add(-5 * time.Second)}, 0, false},
+		{"delay is capped", models.LoginFailures{IP: 19, IPLast: now}, time.Minute, false},
+		{"account locked", models.LoginFailures{Account: 5, AccountLast: now.Add(-time.Minute)}, 14 * time.Minute, true},
+		{"account lockout over", models.LoginFailures{Account: 5, AccountLast: now.Add(-16 * time.Minute)}, 0, false},
+		{"ip locked", models.LoginFailures{Account: 1, AccountLast: now, IP: 20, IPLast: now}, 15 * time.Minute, true},
+		{"longest wait wins", models.LoginFailures{Account: 4, AccountLast: now, IP: 2, IPLast: now}, 8 * time.Second, false},
+	}
+
+	for _, e := range tests {
+		wait, locked := p.Check(e.failures, now)
+		if wait != e.expectedWait || locked != e.expectedLocked {
+			t.Errorf("%s: expected wait %s locked %v, got %s %v", e.name, e.expectedWait, e.expectedLocked, wait, locked)
+		}
+	}
+}
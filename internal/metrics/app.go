@@ -0,0 +1,88 @@
+package metrics
+
+import (
+	"database/sql"
+	"time"
+)
+
+// Metrics are the metrics of the bookings application
+type Metrics struct {
+	Registry *Registry
+
+	HTTPRequests *Counter
+	HTTPDuration *Histogram
+	DBQuery      *Histogram
+	MailSent     *Counter
+
+	Searches              *Counter
+	ReservationsCreated   *Counter
+	ReservationsCancelled *Counter
+}
+
+// Mail results, the label of MailSent
+const (
+	MailSuccess = "success"
+	MailFailure = "failure"
+)
+
+// New registers the application's metrics in a new registry
+func New() *Metrics {
+	reg := NewRegistry()
+	return &Metrics{
+		Registry: reg,
+		HTTPRequests: reg.NewCounter("bookings_http_requests_total",
+			"HTTP requests served, by route pattern, method and status code.",
+			"route", "method", "code"),
+		HTTPDuration: reg.NewHistogram("bookings_http_request_duration_seconds",
+			"Time to serve HTTP requests, by route pattern and method.",
+			nil, "route", "method"),
+		DBQuery: reg.NewHistogram("bookings_db_query_duration_seconds",
+			"Time spent in database queries, by repository method.",
+			nil, "method"),
+		MailSent: reg.NewCounter("bookings_mail_sent_total",
+			"Emails handed to the mail server, by result.",
+			"result"),
+		Searches: reg.NewCounter("bookings_searches_total",
+			"Availability searches, by channel (web, json or api).",
+			"channel"),
+		ReservationsCreated: reg.NewCounter("bookings_reservations_created_total",
+			"Confirmed reservations created, including approved held ones."),
+		ReservationsCancelled: reg.NewCounter("bookings_reservations_cancelled_total",
+			"Reservations cancelled."),
+	}
+}
+
+// ObserveQuery records how long a query of the repository method took
+func (m *Metrics) ObserveQuery(method string, d time.Duration) {
+	m.DBQuery.Observe(d.Seconds(), method)
+}
+
+// RegisterDB exports the connection pool stats of db
+func (m *Metrics) RegisterDB(db *sql.DB) {
+	m.Registry.NewGaugeFunc("bookings_db_open_connections",
+		"Open connections to the database, in use and idle.",
+		func() float64 { return float64(db.Stats().OpenConnections) })
+	m.Registry.NewGaugeFunc("bookings_db_in_use_connections",
+		"Database connections in use.",
+		func() float64 { return float64(db.Stats().InUse) })
+	m.Registry.NewGaugeFunc("bookings_db_idle_connections",
+		"Idle database connections.",
+		func() float64 { return float64(db.Stats().Idle) })
+	m.Registry.NewGaugeFunc("bookings_db_max_open_connections",
+		"Maximum number of open database connections.",
+		func() float64 { return float64(db.Stats().MaxOpenConnections) })
+	m.Registry.NewCounterFunc("bookings_db_wait_count_total",
+		"Times a query waited for a free database connection.",
+		func() float64 { return float64(db.Stats().WaitCount) })
+	m.Registry.NewCounterFunc("bookings_db_wait_duration_seconds_total",
+		"Time spent waiting for a free database connection.",
+		func() float64 { return db.Stats().WaitDuration.Seconds() })
+}
+
+// RegisterMailQueue exports the number of emails waiting to be sent, as
+// returned by depth
+func (m *Metrics) RegisterMailQueue(depth func() int) {
+	m.Registry.NewGaugeFunc("bookings_mail_queue_length",
+		"Emails waiting for the mail worker.",
+		func() float64 { return float64(depth()) })
+}
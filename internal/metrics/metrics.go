@@ -0,0 +1,311 @@
+// Package metrics keeps counters and histograms in memory and writes them in
+// the Prometheus text exposition format, without a client library
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBuckets are the latency buckets in seconds, from 5ms to 10s
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// Registry holds metrics in the order they were registered
+type Registry struct {
+	mu      sync.Mutex
+	metrics []metric
+	names   map[string]bool
+}
+
+// metric is anything the registry can write
+type metric interface {
+	write(w *bufio.Writer)
+}
+
+// NewRegistry returns an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+func (reg *Registry) register(name string, m metric) {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	if reg.names[name] {
+		panic("metrics: " + name + " registered twice")
+	}
+	reg.names[name] = true
+	reg.metrics = append(reg.metrics, m)
+}
+
+// WriteTo writes every metric in the text exposition format
+func (reg *Registry) WriteTo(w io.Writer) (int64, error) {
+	reg.mu.Lock()
+	metrics := append([]metric(nil), reg.metrics...)
+	reg.mu.Unlock()
+
+	cw := &countingWriter{w: w}
+	bw := bufio.NewWriter(cw)
+	for _, m := range metrics {
+		m.write(bw)
+	}
+	err := bw.Flush()
+	return cw.n, err
+}
+
+// Handler serves the registry to a Prometheus scraper
+func (reg *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		reg.WriteTo(w)
+	})
+}
+
+// desc is the name, help and label names shared by every kind of metric
+type desc struct {
+	name   string
+	help   string
+	kind   string
+	labels []string
+}
+
+func (d desc) writeHeader(w *bufio.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", d.name, escapeHelp(d.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", d.name, d.kind)
+}
+
+// key joins label values into a map key, it panics on the wrong number of
+// values as that is a programming error
+func (d desc) key(values []string) string {
+	if len(values) != len(d.labels) {
+		panic(fmt.Sprintf("metrics: %s wants %d label values, got %d", d.name, len(d.labels), len(values)))
+	}
+	return strings.Join(values, "\xff")
+}
+
+// labelPairs formats label names and values as {a="1",b="2"}, extra is
+// appended as is for the histogram le label
+func (d desc) labelPairs(values []string, extra string) string {
+	if len(values) == 0 && extra == "" {
+		return ""
+	}
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, d.labels[i]+`="`+escapeLabel(v)+`"`)
+	}
+	if extra != "" {
+		pairs = append(pairs, extra)
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// Counter is a value that only goes up, one per combination of label values
+type Counter struct {
+	desc
+	mu     sync.Mutex
+	series map[string]*counterSeries
+}
+
+type counterSeries struct {
+	labels []string
+	value  float64
+}
+
+// NewCounter registers a counter, the label values are passed to Inc and Add
+// in the order of labels
+func (reg *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	c := &Counter{
+		desc:   desc{name: name, help: help, kind: "counter", labels: labels},
+		series: make(map[string]*counterSeries),
+	}
+	reg.register(name, c)
+	return c
+}
+
+// Inc adds one to the counter
+func (c *Counter) Inc(labels ...string) {
+	c.Add(1, labels...)
+}
+
+// Add adds v to the counter, negative values are ignored
+func (c *Counter) Add(v float64, labels ...string) {
+	if v < 0 {
+		return
+	}
+	k := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	s, ok := c.series[k]
+	if !ok {
+		s = &counterSeries{labels: append([]string(nil), labels...)}
+		c.series[k] = s
+	}
+	s.value += v
+}
+
+// Value returns the counter for the label values
+func (c *Counter) Value(labels ...string) float64 {
+	k := c.key(labels)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if s, ok := c.series[k]; ok {
+		return s.value
+	}
+	return 0
+}
+
+func (c *Counter) write(w *bufio.Writer) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.writeHeader(w)
+	for _, k := range sortedKeys(c.series) {
+		s := c.series[k]
+		fmt.Fprintf(w, "%s%s %s\n", c.name, c.labelPairs(s.labels, ""), formatFloat(s.value))
+	}
+}
+
+// Histogram counts observations in buckets, one per combination of label values
+type Histogram struct {
+	desc
+	buckets []float64
+	mu      sync.Mutex
+	series  map[string]*histogramSeries
+}
+
+type histogramSeries struct {
+	labels []string
+	// counts are per bucket, not cumulative, the last one is +Inf
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogram registers a histogram with the upper bounds in buckets, which
+// must be sorted; DefaultBuckets is used when buckets is nil
+func (reg *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if buckets == nil {
+		buckets = DefaultBuckets
+	}
+	h := &Histogram{
+		desc:    desc{name: name, help: help, kind: "histogram", labels: labels},
+		buckets: buckets,
+		series:  make(map[string]*histogramSeries),
+	}
+	reg.register(name, h)
+	return h
+}
+
+// Observe records v
+func (h *Histogram) Observe(v float64, labels ...string) {
+	k := h.key(labels)
+	i := sort.SearchFloat64s(h.buckets, v)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	s, ok := h.series[k]
+	if !ok {
+		s = &histogramSeries{
+			labels: append([]string(nil), labels...),
+			counts: make([]uint64, len(h.buckets)+1),
+		}
+		h.series[k] = s
+	}
+	s.counts[i]++
+	s.sum += v
+	s.count++
+}
+
+// Count returns the number of observations for the label values
+func (h *Histogram) Count(labels ...string) uint64 {
+	k := h.key(labels)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if s, ok := h.series[k]; ok {
+		return s.count
+	}
+	return 0
+}
+
+func (h *Histogram) write(w *bufio.Writer) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.writeHeader(w)
+	for _, k := range sortedKeys(h.series) {
+		s := h.series[k]
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += s.counts[i]
+			le := `le="` + formatFloat(upper) + `"`
+			fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, le), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", h.name, h.labelPairs(s.labels, `le="+Inf"`), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", h.name, h.labelPairs(s.labels, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", h.name, h.labelPairs(s.labels, ""), s.count)
+	}
+}
+
+// valueFunc is a single value read when the registry is scraped
+type valueFunc struct {
+	desc
+	fn func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn's at scrape time
+func (reg *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	reg.register(name, &valueFunc{desc: desc{name: name, help: help, kind: "gauge"}, fn: fn})
+}
+
+// NewCounterFunc registers a counter kept elsewhere, like sql.DBStats.WaitCount
+func (reg *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	reg.register(name, &valueFunc{desc: desc{name: name, help: help, kind: "counter"}, fn: fn})
+}
+
+func (f *valueFunc) write(w *bufio.Writer) {
+	f.writeHeader(w)
+	fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+var helpEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+
+// countingWriter counts the bytes written for WriteTo
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	cw.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,96 @@
+package metrics
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRegistry_Handler(t *testing.T) {
+	reg := NewRegistry()
+	requests := reg.NewCounter("requests_total", "Requests served.", "route", "code")
+	latency := reg.NewHistogram("latency_seconds", "Request latency.", []float64{0.25, 1}, "route")
+	reg.NewGaugeFunc("queue_length", "Queued jobs.", func() float64 { return 3 })
+
+	requests.Inc("/rooms/{id}", "200")
+	requests.Inc("/rooms/{id}", "200")
+	requests.Add(5, `/say "hi"`+"\n", "500")
+	requests.Add(-1, "/rooms/{id}", "200")
+	latency.Observe(0.125, "/")
+	latency.Observe(0.25, "/")
+	latency.Observe(0.5, "/")
+	latency.Observe(7, "/")
+
+	rr := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("wrong content type %q", ct)
+	}
+	body, _ := io.ReadAll(rr.Body)
+
+	want := `# HELP requests_total Requests served.
+# TYPE requests_total counter
+requests_total{route="/rooms/{id}",code="200"} 2
+requests_total{route="/say \"hi\"\n",code="500"} 5
+# HELP latency_seconds Request latency.
+# TYPE latency_seconds histogram
+latency_seconds_bucket{route="/",le="0.25"} 2
+latency_seconds_bucket{route="/",le="1"} 3
+latency_seconds_bucket{route="/",le="+Inf"} 4
+latency_seconds_sum{route="/"} 7.875
+latency_seconds_count{route="/"} 4
+# HELP queue_length Queued jobs.
+# TYPE queue_length gauge
+queue_length 3
+`
+	if string(body) != want {
+		t.Errorf("unexpected exposition:\n%s\nwant:\n%s", body, want)
+	}
+	if got := requests.Value("/rooms/{id}", "200"); got != 2 {
+		t.Errorf("expected counter 2, got %v", got)
+	}
+	if got := latency.Count("/"); got != 4 {
+		t.Errorf("expected 4 observations, got %d", got)
+	}
+}
+
+func TestRegistry_Misuse(t *testing.T) {
+	reg := NewRegistry()
+	c := reg.NewCounter("a_total", "A.", "label")
+
+	panics := func(name string, f func()) {
+		t.Helper()
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: expected a panic", name)
+			}
+		}()
+		f()
+	}
+	panics("missing label value", func() { c.Inc() })
+	panics("extra label value", func() { c.Inc("x", "y") })
+	panics("registered twice", func() { reg.NewCounter("a_total", "A again.") })
+}
+
+func TestNew(t *testing.T) {
+	m := New()
+	m.RegisterMailQueue(func() int { return 2 })
+	m.Searches.Inc("web")
+	m.MailSent.Inc(MailFailure)
+
+	var b strings.Builder
+	if _, err := m.Registry.WriteTo(&b); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		`bookings_searches_total{channel="web"} 1`,
+		`bookings_mail_sent_total{result="failure"} 1`,
+		`bookings_mail_queue_length 2`,
+		`# TYPE bookings_reservations_created_total counter`,
+	} {
+		if !strings.Contains(b.String(), line+"\n") {
+			t.Errorf("missing %q in:\n%s", line, b.String())
+		}
+	}
+}
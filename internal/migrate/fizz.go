@@ -0,0 +1,607 @@
+package migrate
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// call is one fizz statement, like add_index("users", "email", {}), the
+// block holds the t.Column calls of create_table
+type call struct {
+	name  string
+	args  []interface{}
+	block []call
+	line  int
+}
+
+// Translate turns fizz source into postgres statements, it knows the fizz
+// the migrations in this repository use: create_table, drop_table,
+// add_column, change_column, drop_column, add_index, drop_index,
+// add_foreign_key, drop_foreign_key and sql
+func Translate(src string) ([]string, error) {
+	p := &parser{src: []rune(src), line: 1}
+	calls, err := p.calls(false)
+	if err != nil {
+		return nil, err
+	}
+	var stmts []string
+	for _, c := range calls {
+		s, err := translate(c)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s: %w", c.line, c.name, err)
+		}
+		stmts = append(stmts, s...)
+	}
+	return stmts, nil
+}
+
+func translate(c call) ([]string, error) {
+	if c.block != nil && c.name != "create_table" {
+		return nil, fmt.Errorf("unexpected block")
+	}
+	switch c.name {
+	case "sql":
+		s, err := stringArg(c, 0)
+		return []string{s}, err
+	case "create_table":
+		return createTable(c)
+	case "drop_table":
+		table, err := stringArg(c, 0)
+		return []string{"DROP TABLE " + quote(table)}, err
+	case "add_column":
+		table, err := stringArg(c, 0)
+		if err != nil {
+			return nil, err
+		}
+		col, err := column(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		return []string{"ALTER TABLE " + quote(table) + " ADD COLUMN " + col}, nil
+	case "change_column":
+		return changeColumn(c)
+	case "drop_column":
+		table, err := stringArg(c, 0)
+		if err != nil {
+			return nil, err
+		}
+		name, err := stringArg(c, 1)
+		return []string{"ALTER TABLE " + quote(table) + " DROP COLUMN " + quote(name)}, err
+	case "add_index":
+		return addIndex(c)
+	case "drop_index":
+		if _, err := stringArg(c, 0); err != nil {
+			return nil, err
+		}
+		name, err := stringArg(c, 1)
+		return []string{"DROP INDEX " + quote(name)}, err
+	case "add_foreign_key":
+		return addForeignKey(c)
+	case "drop_foreign_key":
+		table, err := stringArg(c, 0)
+		if err != nil {
+			return nil, err
+		}
+		name, err := stringArg(c, 1)
+		if err != nil {
+			return nil, err
+		}
+		ifExists := ""
+		if b, _ := optionsArg(c, 2)["if_exists"].(bool); b {
+			ifExists = "IF EXISTS "
+		}
+		return []string{"ALTER TABLE " + quote(table) + " DROP CONSTRAINT " + ifExists + quote(name)}, nil
+	}
+	return nil, fmt.Errorf("not supported")
+}
+
+func createTable(c call) ([]string, error) {
+	table, err := stringArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	var cols []string
+	names := make(map[string]bool)
+	timestamps := true
+	for _, b := range c.block {
+		switch b.name {
+		case "t.Column":
+			col, err := column(b, 0)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", b.line, err)
+			}
+			name, _ := stringArg(b, 0)
+			names[name] = true
+			cols = append(cols, col)
+		case "t.DisableTimestamps":
+			timestamps = false
+		default:
+			return nil, fmt.Errorf("line %d: %s is not supported", b.line, b.name)
+		}
+	}
+	if timestamps {
+		for _, name := range []string{"created_at", "updated_at"} {
+			if !names[name] {
+				cols = append(cols, quote(name)+" TIMESTAMP NOT NULL")
+			}
+		}
+	}
+	if len(cols) == 0 {
+		return nil, fmt.Errorf("no columns")
+	}
+	return []string{"CREATE TABLE " + quote(table) + " (\n\t" + strings.Join(cols, ",\n\t") + "\n)"}, nil
+}
+
+// column returns the definition of the column whose name, type and options
+// are the arguments from i on
+func column(c call, i int) (string, error) {
+	name, err := stringArg(c, i)
+	if err != nil {
+		return "", err
+	}
+	typ, err := stringArg(c, i+1)
+	if err != nil {
+		return "", err
+	}
+	opts := optionsArg(c, i+2)
+	sqlType, err := columnType(typ, opts)
+	if err != nil {
+		return "", err
+	}
+	def := quote(name) + " " + sqlType
+	if primary, _ := opts["primary"].(bool); primary {
+		switch sqlType {
+		case "INTEGER":
+			return quote(name) + " SERIAL PRIMARY KEY", nil
+		case "BIGINT":
+			return quote(name) + " BIGSERIAL PRIMARY KEY", nil
+		}
+		return def + " PRIMARY KEY", nil
+	}
+	if null, _ := opts["null"].(bool); !null {
+		def += " NOT NULL"
+	}
+	if d, ok, err := defaultValue(opts); err != nil {
+		return "", err
+	} else if ok {
+		def += " DEFAULT " + d
+	}
+	return def, nil
+}
+
+func changeColumn(c call) ([]string, error) {
+	table, err := stringArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	name, err := stringArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	typ, err := stringArg(c, 2)
+	if err != nil {
+		return nil, err
+	}
+	opts := optionsArg(c, 3)
+	sqlType, err := columnType(typ, opts)
+	if err != nil {
+		return nil, err
+	}
+	col := "ALTER COLUMN " + quote(name)
+	parts := []string{col + " TYPE " + sqlType}
+	if null, _ := opts["null"].(bool); null {
+		parts = append(parts, col+" DROP NOT NULL")
+	} else {
+		parts = append(parts, col+" SET NOT NULL")
+	}
+	if d, ok, err := defaultValue(opts); err != nil {
+		return nil, err
+	} else if ok {
+		parts = append(parts, col+" SET DEFAULT "+d)
+	} else {
+		parts = append(parts, col+" DROP DEFAULT")
+	}
+	return []string{"ALTER TABLE " + quote(table) + " " + strings.Join(parts, ", ")}, nil
+}
+
+func addIndex(c call) ([]string, error) {
+	table, err := stringArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	cols, err := columnsArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	opts := optionsArg(c, 2)
+	name, _ := opts["name"].(string)
+	if name == "" {
+		name = table + "_" + strings.Join(cols, "_") + "_idx"
+	}
+	create := "CREATE INDEX "
+	if unique, _ := opts["unique"].(bool); unique {
+		create = "CREATE UNIQUE INDEX "
+	}
+	return []string{create + quote(name) + " ON " + quote(table) + " (" + quoteAll(cols) + ")"}, nil
+}
+
+func addForeignKey(c call) ([]string, error) {
+	table, err := stringArg(c, 0)
+	if err != nil {
+		return nil, err
+	}
+	col, err := stringArg(c, 1)
+	if err != nil {
+		return nil, err
+	}
+	refs := optionsArg(c, 2)
+	if len(refs) != 1 {
+		return nil, fmt.Errorf("want one referenced table")
+	}
+	var refTable string
+	var refCols []string
+	for t, v := range refs {
+		refTable = t
+		refCols, err = columns(v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts := optionsArg(c, 3)
+	name, _ := opts["name"].(string)
+	if name == "" {
+		name = table + "_" + refTable + "_" + strings.Join(refCols, "_") + "_fk"
+	}
+	s := "ALTER TABLE " + quote(table) + " ADD CONSTRAINT " + quote(name) +
+		" FOREIGN KEY (" + quote(col) + ") REFERENCES " + quote(refTable) + " (" + quoteAll(refCols) + ")"
+	for _, action := range []string{"on_delete", "on_update"} {
+		if v, ok := opts[action].(string); ok {
+			s += " " + strings.ToUpper(strings.ReplaceAll(action, "_", " ")) + " " + strings.ToUpper(v)
+		}
+	}
+	return []string{s}, nil
+}
+
+// columnType maps a fizz type to its postgres type
+func columnType(typ string, opts map[string]interface{}) (string, error) {
+	switch strings.ToLower(typ) {
+	case "string", "varchar":
+		size := 255
+		if s, ok := opts["size"].(float64); ok {
+			size = int(s)
+		}
+		return "VARCHAR (" + strconv.Itoa(size) + ")", nil
+	case "text":
+		return "TEXT", nil
+	case "integer", "int":
+		return "INTEGER", nil
+	case "bigint":
+		return "BIGINT", nil
+	case "bool", "boolean":
+		return "BOOLEAN", nil
+	case "date":
+		return "DATE", nil
+	case "timestamp", "datetime", "time":
+		return "TIMESTAMP", nil
+	case "timestamptz":
+		return "TIMESTAMPTZ", nil
+	case "json":
+		return "JSON", nil
+	case "jsonb":
+		return "JSONB", nil
+	case "uuid":
+		return "UUID", nil
+	case "float", "decimal":
+		return "DECIMAL", nil
+	}
+	return "", fmt.Errorf("column type %q is not supported", typ)
+}
+
+// defaultValue returns the DEFAULT clause value of the column options
+func defaultValue(opts map[string]interface{}) (string, bool, error) {
+	if raw, ok := opts["default_raw"].(string); ok {
+		return raw, true, nil
+	}
+	v, ok := opts["default"]
+	if !ok {
+		return "", false, nil
+	}
+	switch v := v.(type) {
+	case string:
+		return "'" + strings.ReplaceAll(v, "'", "''") + "'", true, nil
+	case bool:
+		return strconv.FormatBool(v), true, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true, nil
+	}
+	return "", false, fmt.Errorf("default %v is not supported", v)
+}
+
+func stringArg(c call, i int) (string, error) {
+	if i >= len(c.args) {
+		return "", fmt.Errorf("missing argument %d", i+1)
+	}
+	s, ok := c.args[i].(string)
+	if !ok {
+		return "", fmt.Errorf("argument %d must be a string", i+1)
+	}
+	return s, nil
+}
+
+// optionsArg returns the map argument i, or an empty map when there is none
+func optionsArg(c call, i int) map[string]interface{} {
+	if i < len(c.args) {
+		if m, ok := c.args[i].(map[string]interface{}); ok {
+			return m
+		}
+	}
+	return map[string]interface{}{}
+}
+
+// columnsArg returns argument i, a column name or a list of them
+func columnsArg(c call, i int) ([]string, error) {
+	if i >= len(c.args) {
+		return nil, fmt.Errorf("missing argument %d", i+1)
+	}
+	return columns(c.args[i])
+}
+
+func columns(v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		cols := make([]string, 0, len(v))
+		for _, c := range v {
+			s, ok := c.(string)
+			if !ok {
+				return nil, fmt.Errorf("column names must be strings")
+			}
+			cols = append(cols, s)
+		}
+		if len(cols) > 0 {
+			return cols, nil
+		}
+	}
+	return nil, fmt.Errorf("want a column name or a list of them")
+}
+
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+func quoteAll(names []string) string {
+	quoted := make([]string, len(names))
+	for i, n := range names {
+		quoted[i] = quote(n)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+// parser reads fizz: calls with string, number, bool, list and map
+// arguments, map keys may be bare words and trailing commas are allowed
+type parser struct {
+	src  []rune
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("line %d: %s", p.line, fmt.Sprintf(format, args...))
+}
+
+// skip skips white space and // comments
+func (p *parser) skip() {
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		switch {
+		case r == '\n':
+			p.line++
+			p.pos++
+		case unicode.IsSpace(r):
+			p.pos++
+		case r == '/' && p.pos+1 < len(p.src) && p.src[p.pos+1] == '/':
+			for p.pos < len(p.src) && p.src[p.pos] != '\n' {
+				p.pos++
+			}
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) peek() rune {
+	p.skip()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *parser) expect(r rune) error {
+	if got := p.peek(); got != r {
+		if got == 0 {
+			return p.errorf("expected %q, got the end", r)
+		}
+		return p.errorf("expected %q, got %q", r, got)
+	}
+	p.pos++
+	return nil
+}
+
+// calls reads calls up to the end, or up to } in a block
+func (p *parser) calls(inBlock bool) ([]call, error) {
+	var calls []call
+	for {
+		r := p.peek()
+		if r == 0 {
+			if inBlock {
+				return nil, p.errorf("block is not closed")
+			}
+			return calls, nil
+		}
+		if r == '}' && inBlock {
+			p.pos++
+			return calls, nil
+		}
+		c, err := p.call()
+		if err != nil {
+			return nil, err
+		}
+		calls = append(calls, c)
+	}
+}
+
+func (p *parser) call() (call, error) {
+	c := call{line: p.line}
+	c.name = p.word()
+	if c.name == "" {
+		return c, p.errorf("unexpected %q", p.peek())
+	}
+	if err := p.expect('('); err != nil {
+		return c, err
+	}
+	for p.peek() != ')' {
+		v, err := p.value()
+		if err != nil {
+			return c, err
+		}
+		c.args = append(c.args, v)
+		if p.peek() == ',' {
+			p.pos++
+		} else if p.peek() != ')' {
+			return c, p.errorf("expected , or ) after argument")
+		}
+	}
+	p.pos++
+	if p.peek() == '{' {
+		p.pos++
+		block, err := p.calls(true)
+		if err != nil {
+			return c, err
+		}
+		c.block = block
+		if c.block == nil {
+			c.block = []call{}
+		}
+	}
+	return c, nil
+}
+
+// word reads a bare word like create_table or t.Column
+func (p *parser) word() string {
+	p.skip()
+	start := p.pos
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		if r != '_' && r != '.' && !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			break
+		}
+		p.pos++
+	}
+	return string(p.src[start:p.pos])
+}
+
+func (p *parser) value() (interface{}, error) {
+	switch r := p.peek(); {
+	case r == '"':
+		return p.string()
+	case r == '[':
+		p.pos++
+		list := []interface{}{}
+		for p.peek() != ']' {
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != ']' {
+				return nil, p.errorf("expected , or ] in list")
+			}
+		}
+		p.pos++
+		return list, nil
+	case r == '{':
+		p.pos++
+		m := map[string]interface{}{}
+		for p.peek() != '}' {
+			var key string
+			if p.peek() == '"' {
+				s, err := p.string()
+				if err != nil {
+					return nil, err
+				}
+				key = s
+			} else if key = p.word(); key == "" {
+				return nil, p.errorf("expected a key, got %q", p.peek())
+			}
+			if err := p.expect(':'); err != nil {
+				return nil, err
+			}
+			v, err := p.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			if p.peek() == ',' {
+				p.pos++
+			} else if p.peek() != '}' {
+				return nil, p.errorf("expected , or } in map")
+			}
+		}
+		p.pos++
+		return m, nil
+	case r == '-' || unicode.IsDigit(r):
+		start := p.pos
+		p.pos++
+		for p.pos < len(p.src) && (unicode.IsDigit(p.src[p.pos]) || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		return strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+	case r == 0:
+		return nil, p.errorf("unexpected end")
+	}
+	switch w := p.word(); w {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "":
+		return nil, p.errorf("unexpected %q", p.peek())
+	default:
+		return nil, p.errorf("unexpected word %q", w)
+	}
+}
+
+// string reads a double quoted string, \" and \\ are escapes
+func (p *parser) string() (string, error) {
+	p.pos++
+	var b strings.Builder
+	for p.pos < len(p.src) {
+		r := p.src[p.pos]
+		p.pos++
+		switch r {
+		case '"':
+			return b.String(), nil
+		case '\\':
+			if p.pos < len(p.src) {
+				r = p.src[p.pos]
+				p.pos++
+				switch r {
+				case 'n':
+					r = '\n'
+				case 't':
+					r = '\t'
+				}
+			}
+		case '\n':
+			p.line++
+		}
+		b.WriteRune(r)
+	}
+	return "", p.errorf("string is not closed")
+}
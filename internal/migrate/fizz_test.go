@@ -0,0 +1,94 @@
+package migrate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	var tests = []struct {
+		name string
+		fizz string
+		want []string
+	}{
+		{"create table", `create_table("rooms") {
+    t.Column("id","integer",{primary:true})
+    t.Column("room_name","string",{"default":""})
+    t.Column("code", "string", {"size": 16, "null": true})
+}`, []string{`CREATE TABLE "rooms" (
+	"id" SERIAL PRIMARY KEY,
+	"room_name" VARCHAR (255) NOT NULL DEFAULT '',
+	"code" VARCHAR (16),
+	"created_at" TIMESTAMP NOT NULL,
+	"updated_at" TIMESTAMP NOT NULL
+)`}},
+		{"create table without timestamps", `create_table("audit_log") {
+    t.Column("id","integer",{primary:true})
+    t.Column("after","jsonb",{"null":true})
+    t.DisableTimestamps()
+    t.Column("created_at","timestamp",{})
+}`, []string{`CREATE TABLE "audit_log" (
+	"id" SERIAL PRIMARY KEY,
+	"after" JSONB,
+	"created_at" TIMESTAMP NOT NULL
+)`}},
+		{"drop table", `drop_table("rooms")`, []string{`DROP TABLE "rooms"`}},
+		{"add column", `add_column("users", "active", "bool", {"default": true})`,
+			[]string{`ALTER TABLE "users" ADD COLUMN "active" BOOLEAN NOT NULL DEFAULT true`}},
+		{"add column with number and quote", `add_column("t", "n", "bigint", {"default": 0})
+add_column("t", "s", "string", {"default": "it's"})`, []string{
+			`ALTER TABLE "t" ADD COLUMN "n" BIGINT NOT NULL DEFAULT 0`,
+			`ALTER TABLE "t" ADD COLUMN "s" VARCHAR (255) NOT NULL DEFAULT 'it''s'`,
+		}},
+		{"change column", `change_column("room_restrictions", "reservation_id", "integer", {"null":true})`,
+			[]string{`ALTER TABLE "room_restrictions" ALTER COLUMN "reservation_id" TYPE INTEGER, ALTER COLUMN "reservation_id" DROP NOT NULL, ALTER COLUMN "reservation_id" DROP DEFAULT`}},
+		{"drop column", `drop_column("users", "active")`, []string{`ALTER TABLE "users" DROP COLUMN "active"`}},
+		{"add index", `add_index("users", "email", {"unique": true})
+add_index("room_restrictions", ["start_date", "end_date"], {})`, []string{
+			`CREATE UNIQUE INDEX "users_email_idx" ON "users" ("email")`,
+			`CREATE INDEX "room_restrictions_start_date_end_date_idx" ON "room_restrictions" ("start_date", "end_date")`,
+		}},
+		{"drop index", `drop_index("users", "users_email_idx")`, []string{`DROP INDEX "users_email_idx"`}},
+		{"add foreign key", `add_foreign_key("reservations", "user_id", {"users": ["id"]}, {
+    "on_delete": "set null",
+    "on_update": "cascade",
+})`, []string{`ALTER TABLE "reservations" ADD CONSTRAINT "reservations_users_id_fk" FOREIGN KEY ("user_id") REFERENCES "users" ("id") ON DELETE SET NULL ON UPDATE CASCADE`}},
+		{"drop foreign key", `drop_foreign_key("reservations", "reservations_rooms_id_fk", {"if_exists": true})`,
+			[]string{`ALTER TABLE "reservations" DROP CONSTRAINT IF EXISTS "reservations_rooms_id_fk"`}},
+		{"sql", `sql("CREATE FUNCTION f() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'no'; END; $$ LANGUAGE plpgsql;")`,
+			[]string{`CREATE FUNCTION f() RETURNS trigger AS $$ BEGIN RAISE EXCEPTION 'no'; END; $$ LANGUAGE plpgsql;`}},
+		{"empty", "\n", nil},
+	}
+
+	for _, e := range tests {
+		got, err := Translate(e.fizz)
+		if err != nil {
+			t.Errorf("%s: %s", e.name, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, e.want) {
+			t.Errorf("%s: got %q, want %q", e.name, got, e.want)
+		}
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	var tests = []struct {
+		name string
+		fizz string
+	}{
+		{"unknown statement", `rename_table("a", "b")`},
+		{"unknown type", `add_column("t", "c", "money", {})`},
+		{"missing argument", `drop_column("t")`},
+		{"unclosed string", `drop_table("t)`},
+		{"unclosed block", `create_table("t") { t.Column("id","integer",{primary:true})`},
+		{"block on the wrong statement", `drop_table("t") { }`},
+		{"bare word value", `drop_table(rooms)`},
+	}
+
+	for _, e := range tests {
+		if _, err := Translate(e.fizz); err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+	}
+}
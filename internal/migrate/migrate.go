@@ -0,0 +1,266 @@
+// Package migrate applies the fizz and sql migrations without the soda cli.
+// Applied versions are kept in soda's schema_migration table, so a database
+// migrated by soda carries on where it left off
+package migrate
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// lockID is the postgres advisory lock held while migrating, two instances
+// starting at the same time take turns
+const lockID = 4_270_261_019
+
+// schemaTable is the table soda keeps applied versions in
+const schemaTable = "schema_migration"
+
+// Migration is one version with the statements to apply and to roll it back
+type Migration struct {
+	Version string
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// Status is a migration and whether the database has it
+type Status struct {
+	Migration
+	Applied bool
+}
+
+var (
+	fizzName = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.fizz$`)
+	// sqlName matches soda's raw sql migrations, with an optional dialect
+	sqlName = regexp.MustCompile(`^(\d+)_([^.]+)(?:\.(\w+))?\.(up|down)\.sql$`)
+)
+
+// Load reads and translates every migration in fsys, sorted by version; a
+// migration that can't be translated fails the load before anything runs.
+// Sql migrations run as they are, those for a dialect other than postgres
+// are skipped like soda does
+func Load(fsys fs.FS) ([]Migration, error) {
+	var files []string
+	for _, pattern := range []string{"*.fizz", "*.up.sql", "*.down.sql"} {
+		matches, err := fs.Glob(fsys, pattern)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	byVersion := make(map[string]*Migration)
+	for _, f := range files {
+		var m []string
+		if s := sqlName.FindStringSubmatch(path.Base(f)); s != nil {
+			if s[3] != "" && s[3] != "postgres" {
+				continue
+			}
+			m = []string{s[0], s[1], s[2], s[4]}
+		} else if m = fizzName.FindStringSubmatch(path.Base(f)); m == nil {
+			return nil, fmt.Errorf("%s: not named <version>_<name>.up.fizz, .down.fizz, .up.sql or .down.sql", f)
+		}
+		src, err := fs.ReadFile(fsys, f)
+		if err != nil {
+			return nil, err
+		}
+		var stmts []string
+		if strings.HasSuffix(f, ".sql") {
+			if strings.TrimSpace(string(src)) != "" {
+				stmts = []string{string(src)}
+			}
+		} else {
+			stmts, err = Translate(string(src))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", f, err)
+			}
+		}
+		mig, ok := byVersion[m[1]]
+		if !ok {
+			mig = &Migration{Version: m[1], Name: m[2]}
+			byVersion[m[1]] = mig
+		} else if mig.Name != m[2] {
+			return nil, fmt.Errorf("%s: version %s is also named %s", f, m[1], mig.Name)
+		}
+		if m[3] == "up" {
+			mig.Up = stmts
+		} else {
+			mig.Down = stmts
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies migrations to a postgres database, every command runs in
+// one transaction holding the advisory lock, so it is applied entirely or not
+// at all
+type Migrator struct {
+	DB         *sql.DB
+	Migrations []Migration
+}
+
+// New loads the migrations in fsys for db
+func New(db *sql.DB, fsys fs.FS) (*Migrator, error) {
+	migrations, err := Load(fsys)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{DB: db, Migrations: migrations}, nil
+}
+
+// Up applies every migration the database doesn't have yet, oldest first,
+// and returns them
+func (m *Migrator) Up(ctx context.Context) ([]Migration, error) {
+	var done []Migration
+	err := m.locked(ctx, func(tx *sql.Tx, applied map[string]bool) error {
+		for _, mig := range m.Migrations {
+			if applied[mig.Version] {
+				continue
+			}
+			if err := run(ctx, tx, mig.Up); err != nil {
+				return fmt.Errorf("%s_%s up: %w", mig.Version, mig.Name, err)
+			}
+			_, err := tx.ExecContext(ctx, `insert into `+schemaTable+` (version) values ($1)`, mig.Version)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// Down rolls back the last n applied migrations, newest first, and returns them
+func (m *Migrator) Down(ctx context.Context, n int) ([]Migration, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("can't roll back %d migrations", n)
+	}
+	byVersion := make(map[string]Migration, len(m.Migrations))
+	for _, mig := range m.Migrations {
+		byVersion[mig.Version] = mig
+	}
+
+	var done []Migration
+	err := m.locked(ctx, func(tx *sql.Tx, applied map[string]bool) error {
+		versions := make([]string, 0, len(applied))
+		for v := range applied {
+			versions = append(versions, v)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(versions)))
+		if n > len(versions) {
+			n = len(versions)
+		}
+		for _, v := range versions[:n] {
+			mig, ok := byVersion[v]
+			if !ok {
+				return fmt.Errorf("version %s is applied but has no migration here", v)
+			}
+			if err := run(ctx, tx, mig.Down); err != nil {
+				return fmt.Errorf("%s_%s down: %w", mig.Version, mig.Name, err)
+			}
+			_, err := tx.ExecContext(ctx, `delete from `+schemaTable+` where version = $1`, mig.Version)
+			if err != nil {
+				return err
+			}
+			done = append(done, mig)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return done, nil
+}
+
+// Status lists every migration and whether it was applied
+func (m *Migrator) Status(ctx context.Context) ([]Status, error) {
+	var statuses []Status
+	err := m.locked(ctx, func(tx *sql.Tx, applied map[string]bool) error {
+		for _, mig := range m.Migrations {
+			statuses = append(statuses, Status{Migration: mig, Applied: applied[mig.Version]})
+		}
+		return nil
+	})
+	return statuses, err
+}
+
+// Seed runs the seed statements, they must be safe to run again
+func (m *Migrator) Seed(ctx context.Context, seed string) error {
+	return m.locked(ctx, func(tx *sql.Tx, applied map[string]bool) error {
+		_, err := tx.ExecContext(ctx, seed)
+		return err
+	})
+}
+
+// locked runs f in a transaction holding the migration lock, with the
+// versions already applied
+func (m *Migrator) locked(ctx context.Context, f func(tx *sql.Tx, applied map[string]bool) error) error {
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `select pg_advisory_xact_lock($1)`, lockID); err != nil {
+		return fmt.Errorf("can't take the migration lock: %w", err)
+	}
+	// the same table and index soda creates
+	_, err = tx.ExecContext(ctx, `create table if not exists `+schemaTable+` (version varchar(14) not null)`)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `create unique index if not exists `+schemaTable+`_version_idx on `+schemaTable+` (version)`)
+	if err != nil {
+		return err
+	}
+
+	applied, err := appliedVersions(ctx, tx)
+	if err != nil {
+		return err
+	}
+	if err := f(tx, applied); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func appliedVersions(ctx context.Context, tx *sql.Tx) (map[string]bool, error) {
+	rows, err := tx.QueryContext(ctx, `select version from `+schemaTable)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[string]bool)
+	for rows.Next() {
+		var v string
+		if err := rows.Scan(&v); err != nil {
+			return nil, err
+		}
+		applied[strings.TrimSpace(v)] = true
+	}
+	return applied, rows.Err()
+}
+
+func run(ctx context.Context, tx *sql.Tx, stmts []string) error {
+	for _, s := range stmts {
+		if _, err := tx.ExecContext(ctx, s); err != nil {
+			return fmt.Errorf("%w\n%s", err, s)
+		}
+	}
+	return nil
+}
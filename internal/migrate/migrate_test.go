@@ -0,0 +1,78 @@
+package migrate
+
+import (
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/acceleraterA/go_app_udemy/migrations"
+)
+
+func TestLoad(t *testing.T) {
+	fsys := fstest.MapFS{
+		"20230330051422_create_rooms_table.up.fizz":   {Data: []byte(`create_table("rooms") { t.Column("id","integer",{primary:true}) }`)},
+		"20230330051422_create_rooms_table.down.fizz": {Data: []byte(`drop_table("rooms")`)},
+		"20230329174521_create_user_table.up.fizz":    {Data: []byte(`sql("create table users (id serial)")`)},
+		"20230329174521_create_user_table.down.fizz":  {Data: []byte(``)},
+	}
+	got, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 2 || got[0].Version != "20230329174521" || got[1].Name != "create_rooms_table" {
+		t.Fatalf("migrations not paired and sorted: %+v", got)
+	}
+	if len(got[0].Down) != 0 || len(got[1].Down) != 1 || len(got[1].Up) != 1 {
+		t.Errorf("wrong statements: %+v", got)
+	}
+
+	fsys["20230401000000_broken.up.fizz"] = &fstest.MapFile{Data: []byte(`rename_table("a", "b")`)}
+	if _, err := Load(fsys); err == nil || !strings.Contains(err.Error(), "20230401000000_broken.up.fizz") {
+		t.Errorf("expected an error naming the broken file, got %v", err)
+	}
+	delete(fsys, "20230401000000_broken.up.fizz")
+
+	fsys["20230408161646_seed_room_table.postgres.up.sql"] = &fstest.MapFile{Data: []byte("insert into rooms (id) values (1);\ninsert into rooms (id) values (2);")}
+	fsys["20230408161646_seed_room_table.postgres.down.sql"] = &fstest.MapFile{Data: []byte("delete from rooms;")}
+	fsys["20230408161646_seed_room_table.mysql.up.sql"] = &fstest.MapFile{Data: []byte("insert into `rooms` (id) values (1);")}
+	got, err = Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[2].Name != "seed_room_table" || len(got[2].Up) != 1 || !strings.Contains(got[2].Up[0], "values (2)") || len(got[2].Down) != 1 {
+		t.Errorf("sql migration not loaded as one raw statement for postgres: %+v", got)
+	}
+
+	fsys["add_thing.up.fizz"] = &fstest.MapFile{Data: []byte(``)}
+	if _, err := Load(fsys); err == nil {
+		t.Error("expected an error for a file without a version")
+	}
+}
+
+func TestLoadEmbeddedMigrations(t *testing.T) {
+	got, err := Load(migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) == 0 {
+		t.Fatal("no migrations embedded")
+	}
+	for _, m := range got {
+		if len(m.Up) == 0 {
+			t.Errorf("%s_%s has no up statements", m.Version, m.Name)
+		}
+	}
+	//soda's sql migrations, a database migrated by soda has them applied
+	versions := make(map[string]bool)
+	for _, m := range got {
+		versions[m.Version] = true
+	}
+	for _, v := range []string{"20230408161646", "20230408161834"} {
+		if !versions[v] {
+			t.Errorf("sql migration %s is not loaded", v)
+		}
+	}
+	if !strings.Contains(migrations.Seed, "INSERT INTO rooms") {
+		t.Error("seed is not embedded")
+	}
+}
@@ -1,7 +1,10 @@
 package models
 
 import (
+	"fmt"
 	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
 )
 
 // User is the user model
@@ -12,8 +15,102 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
-	CreatedAt   time.Time
-	UpdatedAt   time.Time
+	Active      bool
+	// EmailVerified is set once the user followed the link mailed to them,
+	// only then are reservations under their email shown to them
+	EmailVerified bool
+	// SessionVersion changes with the password, sessions holding an older
+	// version are logged out
+	SessionVersion int
+	// TOTPSecret is encrypted, TOTPLastCounter is the last time step used so
+	// a code can't be replayed
+	TOTPSecret      string
+	TOTPEnabled     bool
+	TOTPLastCounter int64
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+}
+
+// Staff roles, stored in users.access_level; each role can do everything the
+// roles below it can
+const (
+	AccessLevelFrontDesk = 1
+	AccessLevelManager   = 2
+	AccessLevelOwner     = 3
+)
+
+// AccessLevelGuest is the access level of guest accounts, they can log in
+// to see their bookings but pass no staff role check
+const AccessLevelGuest = 0
+
+// Roles maps each access level to the name shown to admins
+var Roles = map[int]string{
+	AccessLevelFrontDesk: "front-desk",
+	AccessLevelManager:   "manager",
+	AccessLevelOwner:     "owner",
+}
+
+// Role returns the name of the user's role
+func (u User) Role() string {
+	if name, ok := Roles[u.AccessLevel]; ok {
+		return name
+	}
+	if u.IsGuest() {
+		return "guest"
+	}
+	return "none"
+}
+
+// IsGuest reports whether the user is a guest rather than staff
+func (u User) IsGuest() bool {
+	return u.AccessLevel == AccessLevelGuest
+}
+
+// HasRole reports whether the user's access level is at least level
+func (u User) HasRole(level int) bool {
+	return u.AccessLevel >= level
+}
+
+// Name returns the user's full name
+func (u User) Name() string {
+	return u.FirstName + " " + u.LastName
+}
+
+// Token scopes, a token can only be used for the purpose it was issued for
+const (
+	TokenScopeInvite        = "invite"
+	TokenScopePasswordReset = "password-reset"
+	TokenScopeVerifyEmail   = "verify-email"
+)
+
+// UserToken is a single use token mailed to a user, only its hash is stored
+type UserToken struct {
+	ID        int
+	UserID    int
+	Scope     string
+	TokenHash string
+	ExpiresAt time.Time
+	UsedAt    time.Time
+	CreatedAt time.Time
+}
+
+// LoginAttempt is a recorded login, Locked is set when it was refused
+// without checking the password
+type LoginAttempt struct {
+	ID        int
+	Email     string
+	IPAddress string
+	Success   bool
+	Locked    bool
+	CreatedAt time.Time
+}
+
+// LoginFailures sums up recent failed logins for an account and an ip address
+type LoginFailures struct {
+	Account     int
+	AccountLast time.Time
+	IP          int
+	IPLast      time.Time
 }
 
 type Room struct {
@@ -32,11 +129,25 @@ type Reservation struct {
 	StartDate time.Time
 	EndDate   time.Time
 	RoomID    int
+	Status    string
+	// UserID is the guest account that made the reservation, 0 when it was
+	// made without logging in
+	UserID    int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 	Room      Room
+	// HoldReason is why a held reservation looked like spam
+	HoldReason string
 }
 
+// Reservation statuses
+const (
+	ReservationConfirmed = "confirmed"
+	ReservationCancelled = "cancelled"
+	// ReservationHeld waits for an admin to approve it and doesn't block its dates
+	ReservationHeld = "held"
+)
+
 type Restriction struct {
 	ID              int
 	RestrictionName string
@@ -44,6 +155,12 @@ type Restriction struct {
 	UpdatedAt       time.Time
 }
 
+// Restrictions, restrictions.id of what keeps a room from being booked
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+)
+
 type RoomRestriction struct {
 	ID            int
 	StartDate     time.Time
@@ -65,4 +182,242 @@ type MailData struct {
 	Subject  string
 	Content  string
 	Template string
+	// Request is the request that sent the mail, so the mail worker's log
+	// lines carry its id
+	Request logging.Request
+}
+
+// API key scopes
+const (
+	ScopeAvailabilityRead   = "availability:read"
+	ScopeReservationsCreate = "reservations:create"
+	ScopeAdminRead          = "admin:read"
+)
+
+// APIScopes lists every scope an api key can be granted
+var APIScopes = []string{ScopeAvailabilityRead, ScopeReservationsCreate, ScopeAdminRead}
+
+// APIKey is a partner api key, only the hash of the key is stored
+type APIKey struct {
+	ID         int
+	Name       string
+	Prefix     string
+	KeyHash    string
+	Scopes     []string
+	UserID     int
+	LastUsedAt time.Time
+	RevokedAt  time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
+
+// HasScope reports whether the key was granted scope
+func (k APIKey) HasScope(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// Revoked reports whether the key has been revoked
+func (k APIKey) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+// Webhook events
+const (
+	EventReservationCreated   = "reservation.created"
+	EventReservationModified  = "reservation.modified"
+	EventReservationCancelled = "reservation.cancelled"
+)
+
+// WebhookEvents lists every event a webhook endpoint can subscribe to
+var WebhookEvents = []string{EventReservationCreated, EventReservationModified, EventReservationCancelled}
+
+// WebhookEndpoint is an url that receives signed event payloads
+type WebhookEndpoint struct {
+	ID        int
+	URL       string
+	Secret    string
+	Events    []string
+	Active    bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+// Subscribed reports whether the endpoint wants to receive event
+func (e WebhookEndpoint) Subscribed(event string) bool {
+	for _, ev := range e.Events {
+		if ev == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Webhook delivery statuses
+const (
+	DeliveryPending   = "pending"
+	DeliverySucceeded = "succeeded"
+	DeliveryFailed    = "failed"
+)
+
+// WebhookDelivery is one event sent, or to be sent, to one endpoint
+type WebhookDelivery struct {
+	ID                int
+	WebhookEndpointID int
+	Event             string
+	Payload           string
+	Status            string
+	Attempts          int
+	ResponseCode      int
+	LastError         string
+	DeliveredAt       time.Time
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Endpoint          WebhookEndpoint
+}
+
+// Actor types, who made a change recorded in the audit log
+const (
+	ActorUser   = "user"
+	ActorAPIKey = "api_key"
+	ActorGuest  = "guest"
+	// ActorOperator is someone running the admin command, Label is their login
+	ActorOperator = "operator"
+)
+
+// Actor is who made a change: a staff user, an api key or a guest, ID is 0
+// for a guest without an account
+type Actor struct {
+	Type  string
+	ID    int
+	Label string
 }
+
+// String returns the actor the way the audit viewer shows it
+func (a Actor) String() string {
+	if a.Label != "" {
+		return a.Type + " " + a.Label
+	}
+	if a.ID != 0 {
+		return fmt.Sprintf("%s #%d", a.Type, a.ID)
+	}
+	return a.Type
+}
+
+// Audit actions
+const (
+	AuditCreate         = "create"
+	AuditUpdate         = "update"
+	AuditDelete         = "delete"
+	AuditCancel         = "cancel"
+	AuditPasswordChange = "password-change"
+	AuditVerifyEmail    = "verify-email"
+	AuditApprove        = "approve"
+	AuditReply          = "reply"
+	AuditEnableTOTP     = "totp-enable"
+	AuditDisableTOTP    = "totp-disable"
+)
+
+// Entity types in the audit log
+const (
+	EntityReservation      = "reservation"
+	EntityRoomRestriction  = "room_restriction"
+	EntityRoom             = "room"
+	EntityUser             = "user"
+	EntityHousekeepingTask = "housekeeping_task"
+	EntityContactMessage   = "contact_message"
+	// EntityRoleSettings entries have the access level as their id
+	EntityRoleSettings = "role_settings"
+)
+
+// AuditEntry is one change in the append-only audit log, Before and After
+// are JSON snapshots of the entity and empty when it didn't exist
+type AuditEntry struct {
+	ID         int
+	Actor      Actor
+	Action     string
+	EntityType string
+	EntityID   int
+	Before     string
+	After      string
+	CreatedAt  time.Time
+}
+
+// AuditFilter selects audit entries, zero fields match everything
+type AuditFilter struct {
+	ActorType  string
+	ActorID    int
+	Action     string
+	EntityType string
+	EntityID   int
+	From       time.Time
+	To         time.Time
+	Limit      int
+}
+
+// HousekeepingTask is the cleaning state of a room on a date, UpdatedBy is
+// who set it last
+type HousekeepingTask struct {
+	ID        int
+	RoomID    int
+	Date      time.Time
+	Status    string
+	UpdatedBy string
+}
+
+// Housekeeping statuses, in the order a room goes through them
+const (
+	HousekeepingDirty     = "dirty"
+	HousekeepingCleaning  = "cleaning"
+	HousekeepingClean     = "clean"
+	HousekeepingInspected = "inspected"
+)
+
+// HousekeepingStatuses lists the housekeeping statuses in order
+var HousekeepingStatuses = []string{HousekeepingDirty, HousekeepingCleaning, HousekeepingClean, HousekeepingInspected}
+
+// Kinds of the emails sent to guests around their stay
+const (
+	GuestEmailPreArrival = "pre_arrival"
+	GuestEmailPostStay   = "post_stay"
+)
+
+// ContactMessage is a message sent with the contact form, ReadAt is zero
+// until an admin opens it
+type ContactMessage struct {
+	ID      int
+	Name    string
+	Email   string
+	Subject string
+	Message string
+	Status  string
+	// HoldReason is why the message looked like it was sent by a bot
+	HoldReason string
+	ReadAt     time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	Replies    []ContactReply
+}
+
+// ContactReply is an answer to a contact message, mailed to its sender
+type ContactReply struct {
+	ID               int
+	ContactMessageID int
+	Body             string
+	SentBy           string
+	CreatedAt        time.Time
+}
+
+// Contact message statuses, each is a folder of the admin inbox
+const (
+	ContactInbox    = "inbox"
+	ContactArchived = "archived"
+	ContactSpam     = "spam"
+)
+
+// ContactStatuses lists the contact message statuses
+var ContactStatuses = []string{ContactInbox, ContactArchived, ContactSpam}
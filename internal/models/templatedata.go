@@ -8,9 +8,12 @@ type TemplateData struct {
 	FloatMap        map[string]float32
 	Data            map[string]interface{}
 	CSRFToken       string
+	CSPNonce        string
 	Flash           string
 	Warning         string
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	AccessLevel     int
+	Role            string
 }
@@ -0,0 +1,88 @@
+package openapi
+
+import (
+	"testing"
+	"time"
+)
+
+type testResponse struct {
+	Ok      bool      `json:"ok"`
+	Message string    `json:"message,omitempty"`
+	Count   int       `json:"count"`
+	Tags    []string  `json:"tags"`
+	When    time.Time `json:"when"`
+	Skipped string    `json:"-"`
+}
+
+func TestSchemaOf(t *testing.T) {
+	s := SchemaOf(testResponse{})
+	if s.Type != "object" {
+		t.Errorf("expected object schema, got %s", s.Type)
+	}
+	want := map[string]string{"ok": "boolean", "message": "string", "count": "integer", "tags": "array", "when": "string"}
+	for name, typ := range want {
+		prop, ok := s.Properties[name]
+		if !ok {
+			t.Errorf("missing property %s", name)
+			continue
+		}
+		if prop.Type != typ {
+			t.Errorf("property %s: expected type %s, got %s", name, typ, prop.Type)
+		}
+	}
+	if _, ok := s.Properties["-"]; ok {
+		t.Error("field tagged json:\"-\" should not be documented")
+	}
+	for _, name := range s.Required {
+		if name == "message" {
+			t.Error("omitempty field should not be required")
+		}
+	}
+}
+
+func TestDocument_ValidateJSON(t *testing.T) {
+	d := &Document{Components: &Components{Schemas: map[string]*Schema{
+		"Response": SchemaOf(testResponse{}),
+	}}}
+	s := Ref("Response")
+
+	var tests = []struct {
+		name  string
+		body  string
+		valid bool
+	}{
+		{"valid", `{"ok":true,"count":2,"tags":["a"],"when":"2023-05-01T10:00:00Z"}`, true},
+		{"optional present", `{"ok":true,"message":"hi","count":2,"tags":[],"when":"2023-05-01T10:00:00Z"}`, true},
+		{"missing required", `{"ok":true,"tags":[],"when":"2023-05-01T10:00:00Z"}`, false},
+		{"wrong type", `{"ok":"yes","count":2,"tags":[],"when":"2023-05-01T10:00:00Z"}`, false},
+		{"not an integer", `{"ok":true,"count":2.5,"tags":[],"when":"2023-05-01T10:00:00Z"}`, false},
+		{"bad item", `{"ok":true,"count":2,"tags":[1],"when":"2023-05-01T10:00:00Z"}`, false},
+		{"bad date-time", `{"ok":true,"count":2,"tags":[],"when":"yesterday"}`, false},
+		{"unknown property", `{"ok":true,"count":2,"tags":[],"when":"2023-05-01T10:00:00Z","extra":1}`, false},
+		{"not json", `{`, false},
+	}
+
+	for _, e := range tests {
+		err := d.ValidateJSON(s, []byte(e.body))
+		if e.valid && err != nil {
+			t.Errorf("%s: expected valid, got %s", e.name, err)
+		}
+		if !e.valid && err == nil {
+			t.Errorf("%s: expected an error but got none", e.name)
+		}
+	}
+}
+
+func TestDocument_Lookup(t *testing.T) {
+	op := &Operation{OperationID: "x"}
+	d := &Document{Paths: map[string]*PathItem{"/x": {Post: op}}}
+	if d.Lookup("post", "/x") != op {
+		t.Error("expected to find the post operation")
+	}
+	if d.Lookup("GET", "/x") != nil {
+		t.Error("found an operation that does not exist")
+	}
+	if d.Lookup("POST", "/y") != nil {
+		t.Error("found a path that does not exist")
+	}
+}
@@ -0,0 +1,127 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// ValidateJSON checks that a JSON document matches schema s, resolving
+// component references against d
+func (d *Document) ValidateJSON(s *Schema, data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return fmt.Errorf("invalid json: %w", err)
+	}
+	return d.validate(s, v, "$")
+}
+
+func (d *Document) validate(s *Schema, v interface{}, path string) error {
+	s = d.Resolve(s)
+	if s == nil {
+		return nil
+	}
+	if v == nil {
+		if s.Nullable || s.Type == "" {
+			return nil
+		}
+		return fmt.Errorf("%s: got null, want %s", path, s.Type)
+	}
+
+	switch s.Type {
+	case "object":
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want object", path, v)
+		}
+		for _, name := range s.Required {
+			if _, ok := obj[name]; !ok {
+				return fmt.Errorf("%s: missing required property %q", path, name)
+			}
+		}
+		keys := make([]string, 0, len(obj))
+		for k := range obj {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			prop, ok := s.Properties[k]
+			if !ok {
+				if s.AdditionalProperties != nil && !*s.AdditionalProperties {
+					return fmt.Errorf("%s: unexpected property %q", path, k)
+				}
+				continue
+			}
+			if err := d.validate(prop, obj[k], path+"."+k); err != nil {
+				return err
+			}
+		}
+	case "array":
+		arr, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: got %T, want array", path, v)
+		}
+		for i, item := range arr {
+			if err := d.validate(s.Items, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: got %T, want string", path, v)
+		}
+		if len(s.Enum) > 0 && !contains(s.Enum, str) {
+			return fmt.Errorf("%s: %q is not one of %v", path, str, s.Enum)
+		}
+		if err := checkFormat(s.Format, str); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			return fmt.Errorf("%s: got %v, want integer", path, v)
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			return fmt.Errorf("%s: got %T, want number", path, v)
+		}
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			return fmt.Errorf("%s: got %T, want boolean", path, v)
+		}
+	}
+	return nil
+}
+
+// checkFormat validates the string formats used by the documents, empty
+// strings are allowed so optional values can be left blank
+func checkFormat(format, s string) error {
+	if s == "" {
+		return nil
+	}
+	var layout string
+	switch format {
+	case "date":
+		layout = "2006-01-02"
+	case "date-time":
+		layout = time.RFC3339
+	default:
+		return nil
+	}
+	if _, err := time.Parse(layout, s); err != nil {
+		return fmt.Errorf("%q is not a valid %s", s, format)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,93 @@
+package ratelimit
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Limit is a token bucket: Burst requests at once, refilled at Rate requests
+// per second
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// PerMinute returns a limit of n requests a minute with bursts of burst
+func PerMinute(n, burst int) Limit {
+	return Limit{Rate: float64(n) / 60, Burst: burst}
+}
+
+// ParsePerMinute parses a limit written as requests a minute and burst,
+// like "20,10", the burst is the requests a minute when left out
+func ParsePerMinute(s string) (Limit, error) {
+	n, burst, hasBurst := strings.Cut(s, ",")
+	perMinute, err := strconv.Atoi(strings.TrimSpace(n))
+	if err != nil || perMinute <= 0 {
+		return Limit{}, fmt.Errorf("%q is not a number of requests a minute", s)
+	}
+	b := perMinute
+	if hasBurst {
+		b, err = strconv.Atoi(strings.TrimSpace(burst))
+		if err != nil || b <= 0 {
+			return Limit{}, fmt.Errorf("%q has no valid burst", s)
+		}
+	}
+	return PerMinute(perMinute, b), nil
+}
+
+// Result is the outcome of taking a token, RetryAfter is set when the request
+// is refused
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+}
+
+// Store keeps the buckets, the in-process MemoryStore works for a single
+// instance and a shared backend can implement it for several
+type Store interface {
+	Take(key string, limit Limit, now time.Time) (Result, error)
+}
+
+// Limiter answers requests over their limit with 429 Too Many Requests
+type Limiter struct {
+	Store  Store
+	Logger *slog.Logger
+	// Now is the clock, time.Now unless a test replaces it
+	Now func() time.Time
+}
+
+// New returns a limiter keeping its buckets in store
+func New(store Store, logger *slog.Logger) *Limiter {
+	return &Limiter{Store: store, Logger: logger, Now: time.Now}
+}
+
+// Middleware limits each client of a route group separately, key returns the
+// client of a request; requests are let through when the store fails
+func (l *Limiter) Middleware(group string, limit Limit, key func(*http.Request) string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res, err := l.Store.Take(group+"|"+key(r), limit, l.Now())
+			if err != nil {
+				if l.Logger != nil {
+					l.Logger.ErrorContext(r.Context(), "rate limit store failed", "group", group, "err", err)
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Set("X-RateLimit-Limit", strconv.Itoa(limit.Burst))
+			w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.Remaining))
+			if !res.Allowed {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.RetryAfter.Seconds()))))
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,123 @@
+package ratelimit
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParsePerMinute(t *testing.T) {
+	var tests = []struct {
+		in      string
+		want    Limit
+		wantErr bool
+	}{
+		{"20,10", PerMinute(20, 10), false},
+		{" 120 , 30 ", PerMinute(120, 30), false},
+		{"60", PerMinute(60, 60), false},
+		{"", Limit{}, true},
+		{"0,10", Limit{}, true},
+		{"20,", Limit{}, true},
+		{"20,-1", Limit{}, true},
+		{"twenty", Limit{}, true},
+	}
+	for _, e := range tests {
+		got, err := ParsePerMinute(e.in)
+		if (err != nil) != e.wantErr {
+			t.Errorf("%q: got error %v", e.in, err)
+			continue
+		}
+		if got != e.want {
+			t.Errorf("%q: got %+v, want %+v", e.in, got, e.want)
+		}
+	}
+}
+
+func TestMemoryStore_Take(t *testing.T) {
+	s := NewMemoryStore()
+	limit := PerMinute(60, 2)
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		res, _ := s.Take("a", limit, now)
+		if !res.Allowed {
+			t.Fatalf("request %d of the burst was refused", i+1)
+		}
+	}
+	res, _ := s.Take("a", limit, now)
+	if res.Allowed {
+		t.Fatal("request over the burst was allowed")
+	}
+	if res.RetryAfter != time.Second {
+		t.Errorf("expected to retry after 1s, got %s", res.RetryAfter)
+	}
+	if res, _ := s.Take("b", limit, now); !res.Allowed {
+		t.Error("another key shares the bucket")
+	}
+
+	res, _ = s.Take("a", limit, now.Add(time.Second))
+	if !res.Allowed {
+		t.Error("bucket did not refill")
+	}
+	if res.Remaining != 0 {
+		t.Errorf("expected 0 remaining, got %d", res.Remaining)
+	}
+}
+
+func TestMemoryStore_sweep(t *testing.T) {
+	s := NewMemoryStore()
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	s.Take("slow", Limit{Rate: 1.0 / 3600, Burst: 5}, now)
+	s.Take("fast", PerMinute(600, 5), now)
+
+	s.Take("other", PerMinute(1, 5), now.Add(2*sweepInterval))
+	if _, ok := s.buckets["fast"]; ok {
+		t.Error("full bucket was not dropped")
+	}
+	if _, ok := s.buckets["slow"]; !ok {
+		t.Error("bucket that is not full yet was dropped")
+	}
+}
+
+type failingStore struct{}
+
+func (failingStore) Take(string, Limit, time.Time) (Result, error) {
+	return Result{}, errors.New("store is down")
+}
+
+func TestLimiter_Middleware(t *testing.T) {
+	now := time.Date(2026, 10, 19, 12, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(), nil)
+	l.Now = func() time.Time { return now }
+	key := func(r *http.Request) string { return r.RemoteAddr }
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	public := l.Middleware("public", PerMinute(1, 1), key)(ok)
+	api := l.Middleware("api", PerMinute(1, 1), key)(ok)
+
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", "/", nil))
+		return rr
+	}
+
+	if rr := serve(public); rr.Code != http.StatusOK {
+		t.Fatalf("first request got %d", rr.Code)
+	}
+	rr := serve(public)
+	if rr.Code != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", rr.Code)
+	}
+	if rr.Header().Get("Retry-After") != "60" {
+		t.Errorf("expected Retry-After 60, got %q", rr.Header().Get("Retry-After"))
+	}
+	if rr := serve(api); rr.Code != http.StatusOK {
+		t.Errorf("route groups share a bucket, got %d", rr.Code)
+	}
+
+	l.Store = failingStore{}
+	if rr := serve(public); rr.Code != http.StatusOK {
+		t.Errorf("expected requests to pass when the store fails, got %d", rr.Code)
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/acceleraterA/go_app_udemy/internal/secheaders"
 	"github.com/justinas/nosurf"
 )
 
@@ -29,8 +30,11 @@ func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateDa
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
+	td.CSPNonce = secheaders.Nonce(r.Context())
 	if app.Session.Exists(r.Context(), "user_id") {
 		td.IsAuthenticated = 1
+		td.AccessLevel = app.Session.GetInt(r.Context(), "access_level")
+		td.Role = models.User{AccessLevel: td.AccessLevel}.Role()
 	}
 	return td
 }
@@ -52,12 +56,15 @@ func Template(w http.ResponseWriter, tmpl string, td *models.TemplateData, r *ht
 	}
 	buf := new(bytes.Buffer)
 	td = AddDefaultData(td, r)
-	_ = parsedTemplate.Execute(buf, td)
+	err := parsedTemplate.Execute(buf, td)
+	if err != nil {
+		app.Logger.ErrorContext(r.Context(), "can't execute template", "template", tmpl, "err", err)
+	}
 
 	//render the template
-	_, err := buf.WriteTo(w)
+	_, err = buf.WriteTo(w)
 	if err != nil {
-		fmt.Println("error writing template to browser", err)
+		app.Logger.ErrorContext(r.Context(), "can't write template to browser", "template", tmpl, "err", err)
 		return err
 	}
 	return nil
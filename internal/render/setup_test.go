@@ -2,13 +2,14 @@ package render
 
 import (
 	"encoding/gob"
-	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"testing"
 	"time"
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/logging"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 	"github.com/alexedwards/scs/v2"
 )
@@ -30,10 +31,7 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = false
 	// store the session to config app.Session
 	testApp.Session = session
-	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
-	testApp.InfoLog = infoLog
-	ErrorLog := log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
-	testApp.ErrorLog = ErrorLog
+	testApp.Logger = logging.New(os.Stdout, false, slog.LevelInfo)
 	app = &testApp
 
 	//before close the application, run the tests
@@ -0,0 +1,91 @@
+package reports
+
+import (
+	"fmt"
+	"html/template"
+	"strings"
+)
+
+// chart colours, sold nights in the site's primary blue, blocked in grey
+const (
+	colorSold    = "#007bff"
+	colorBlocked = "#adb5bd"
+	colorAxis    = "#6c757d"
+)
+
+// Bar is one bar of a bar chart, Text is printed after it
+type Bar struct {
+	Label string
+	Value float64
+	Text  string
+}
+
+// BarChart draws horizontal bars scaled so max fills the width, as inline
+// SVG. It uses presentation attributes only, which the CSP allows
+func BarChart(title string, bars []Bar, max float64) template.HTML {
+	const (
+		width  = 600
+		label  = 160
+		text   = 60
+		row    = 28
+		height = 20
+	)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s">`,
+		width, row*len(bars)+4, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, template.HTMLEscapeString(title))
+	for i, bar := range bars {
+		y := i*row + 4
+		w := 0.0
+		if max > 0 && bar.Value > 0 {
+			w = float64(width-label-text) * bar.Value / max
+		}
+		fmt.Fprintf(&b, `<text x="0" y="%d" font-size="13" fill="#212529">%s</text>`,
+			y+15, template.HTMLEscapeString(bar.Label))
+		fmt.Fprintf(&b, `<rect x="%d" y="%d" width="%.1f" height="%d" fill="%s"/>`, label, y, w, height, colorSold)
+		fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="12" fill="%s">%s</text>`,
+			float64(label)+w+6, y+15, colorAxis, template.HTMLEscapeString(bar.Text))
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
+
+// DailyChart draws a column per night, sold rooms stacked under blocked
+// ones, on a scale of rooms, as inline SVG
+func DailyChart(title string, days []Day, rooms int) template.HTML {
+	const (
+		width  = 600
+		height = 160
+		axis   = 20
+	)
+	var b strings.Builder
+	fmt.Fprintf(&b, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="100%%" role="img" aria-label="%s">`,
+		width, height+axis, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<title>%s</title>`, template.HTMLEscapeString(title))
+	fmt.Fprintf(&b, `<line x1="0" y1="%d" x2="%d" y2="%d" stroke="%s"/>`, height, width, height, colorAxis)
+	if len(days) > 0 && rooms > 0 {
+		step := float64(width) / float64(len(days))
+		unit := float64(height) / float64(rooms)
+		for i, d := range days {
+			x := float64(i) * step
+			sold := float64(d.Sold) * unit
+			blocked := float64(d.Blocked) * unit
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%s: %d sold, %d blocked</title></rect>`,
+				x, height-sold, step*0.8, sold, colorSold, d.Date.Format("2006-01-02"), d.Sold, d.Blocked)
+			fmt.Fprintf(&b, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"/>`,
+				x, height-sold-blocked, step*0.8, blocked, colorBlocked)
+		}
+		//label the first night and each first of the month far enough from
+		//the previous label
+		last := 0
+		for i, d := range days {
+			if i == 0 || (d.Date.Day() == 1 && float64(i-last)*step >= 50) {
+				fmt.Fprintf(&b, `<text x="%.1f" y="%d" font-size="11" fill="%s">%s</text>`,
+					float64(i)*step, height+15, colorAxis, d.Date.Format("Jan 2"))
+				last = i
+			}
+		}
+	}
+	b.WriteString(`</svg>`)
+	return template.HTML(b.String())
+}
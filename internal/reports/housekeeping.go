@@ -0,0 +1,102 @@
+package reports
+
+import (
+	"strings"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// RoomDay is what housekeeping has to do in a room on a day
+type RoomDay struct {
+	Room models.Room
+	// CheckOut is a guest leaving, CheckIn one arriving and StayOver one
+	// staying another night
+	CheckOut bool
+	CheckIn  bool
+	StayOver bool
+	// Blocked is the owner holding the room for the night
+	Blocked bool
+	// Task is the cleaning status set for the day, or the default one when
+	// none was set yet: dirty after a check-out or a night stayed, clean
+	// otherwise
+	Task models.HousekeepingTask
+}
+
+// Turnover reports a check-out and a check-in on the same day, the room must
+// be ready before the next guest arrives
+func (d RoomDay) Turnover() bool {
+	return d.CheckOut && d.CheckIn
+}
+
+// Activity describes what happens in the room, vacant when nothing does
+func (d RoomDay) Activity() string {
+	var list []string
+	if d.CheckOut {
+		list = append(list, "check-out")
+	}
+	if d.CheckIn {
+		list = append(list, "check-in")
+	}
+	if d.StayOver {
+		list = append(list, "stay-over")
+	}
+	if d.Blocked {
+		list = append(list, "owner block")
+	}
+	if len(list) == 0 {
+		return "vacant"
+	}
+	return strings.Join(list, ", ")
+}
+
+// Housekeeping returns the day of each room from the restrictions
+// overlapping the day before and the day itself, and the tasks set for the
+// day
+func Housekeeping(day time.Time, rooms []models.Room, restrictions []models.RoomRestriction, tasks []models.HousekeepingTask) []RoomDay {
+	day = date(day)
+	index := map[int]int{}
+	list := make([]RoomDay, len(rooms))
+	for i, room := range rooms {
+		index[room.ID] = i
+		list[i].Room = room
+	}
+
+	for _, rr := range restrictions {
+		i, ok := index[rr.RoomID]
+		if !ok {
+			continue
+		}
+		d := &list[i]
+		start, end := date(rr.StartDate), date(rr.EndDate)
+		if rr.RestrictionID != models.RestrictionReservation {
+			d.Blocked = d.Blocked || (!start.After(day) && end.After(day))
+			continue
+		}
+		switch {
+		case start.Equal(day):
+			d.CheckIn = true
+		case end.Equal(day):
+			d.CheckOut = true
+		case start.Before(day) && end.After(day):
+			d.StayOver = true
+		}
+	}
+
+	set := map[int]models.HousekeepingTask{}
+	for _, t := range tasks {
+		set[t.RoomID] = t
+	}
+	for i := range list {
+		d := &list[i]
+		if t, ok := set[d.Room.ID]; ok {
+			d.Task = t
+			continue
+		}
+		d.Task = models.HousekeepingTask{RoomID: d.Room.ID, Date: day, Status: models.HousekeepingClean}
+		if d.CheckOut || d.StayOver {
+			d.Task.Status = models.HousekeepingDirty
+		}
+	}
+	return list
+}
@@ -0,0 +1,194 @@
+// Package reports computes the occupancy figures of the admin dashboard and
+// the housekeeping schedule from the rooms and their restrictions, a
+// reservation or an owner block holding a room for each night from its start
+// date to the day before its end date
+package reports
+
+import (
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// Period is a range of nights, Start included and End, the day after the
+// last night, excluded. Both are dates at midnight UTC
+type Period struct {
+	Start time.Time
+	End   time.Time
+}
+
+// Days returns the number of nights in the period
+func (p Period) Days() int {
+	return nights(p.Start, p.End)
+}
+
+// RoomStats are the nights of one room in a period
+type RoomStats struct {
+	Room models.Room
+	// Sold are the nights taken by reservations, Blocked the ones the owner
+	// blocked and Available the others
+	Sold      int
+	Blocked   int
+	Available int
+	// Occupancy is the share of the nights the owner didn't block that were
+	// sold, 0 when all were blocked
+	Occupancy float64
+}
+
+// Percent returns the occupancy in percent
+func (r RoomStats) Percent() float64 {
+	return r.Occupancy * 100
+}
+
+// Day is the number of rooms sold and blocked for a night
+type Day struct {
+	Date    time.Time
+	Sold    int
+	Blocked int
+}
+
+// Stats are the figures of a period
+type Stats struct {
+	Period Period
+	Rooms  []RoomStats
+	// Total sums the nights of every room
+	Total RoomStats
+	Days  []Day
+	// Arrivals is the number of reservations arriving in the period,
+	// AverageStay their mean length in nights and AverageLeadTime the mean
+	// number of days between booking and arrival
+	Arrivals        int
+	AverageStay     float64
+	AverageLeadTime float64
+}
+
+// Compute returns the stats of the period from the rooms and the
+// restrictions overlapping it. The created date of a reservation
+// restriction is taken from its Reservation when it has one
+func Compute(p Period, rooms []models.Room, restrictions []models.RoomRestriction) Stats {
+	s := Stats{Period: p}
+	days := p.Days()
+	if days < 0 {
+		days = 0
+	}
+	index := map[int]int{}
+	for i, room := range rooms {
+		index[room.ID] = i
+		s.Rooms = append(s.Rooms, RoomStats{Room: room})
+	}
+	for d := 0; d < days; d++ {
+		s.Days = append(s.Days, Day{Date: p.Start.AddDate(0, 0, d)})
+	}
+
+	var stay, lead int
+	for _, rr := range restrictions {
+		i, ok := index[rr.RoomID]
+		if !ok {
+			continue
+		}
+		sold := rr.RestrictionID == models.RestrictionReservation
+		first, last := clip(rr.StartDate, rr.EndDate, p)
+		for d := first; d < last; d++ {
+			if sold {
+				s.Days[d].Sold++
+			} else {
+				s.Days[d].Blocked++
+			}
+		}
+		if sold {
+			s.Rooms[i].Sold += last - first
+		} else {
+			s.Rooms[i].Blocked += last - first
+		}
+
+		if sold && !rr.StartDate.Before(p.Start) && rr.StartDate.Before(p.End) {
+			s.Arrivals++
+			stay += nights(rr.StartDate, rr.EndDate)
+			booked := rr.Reservation.CreatedAt
+			if booked.IsZero() {
+				booked = rr.CreatedAt
+			}
+			if l := nights(booked, rr.StartDate); l > 0 {
+				lead += l
+			}
+		}
+	}
+
+	s.Total.Room = models.Room{RoomName: "All rooms"}
+	for i := range s.Rooms {
+		r := &s.Rooms[i]
+		r.Available = days - r.Sold - r.Blocked
+		r.Occupancy = ratio(r.Sold, days-r.Blocked)
+		s.Total.Sold += r.Sold
+		s.Total.Blocked += r.Blocked
+		s.Total.Available += r.Available
+	}
+	s.Total.Occupancy = ratio(s.Total.Sold, days*len(rooms)-s.Total.Blocked)
+	if s.Arrivals > 0 {
+		s.AverageStay = float64(stay) / float64(s.Arrivals)
+		s.AverageLeadTime = float64(lead) / float64(s.Arrivals)
+	}
+	return s
+}
+
+// Movements are the reservations arriving and leaving on a day
+type Movements struct {
+	Arrivals   []models.RoomRestriction
+	Departures []models.RoomRestriction
+}
+
+// Today returns the reservation restrictions starting and ending on day
+func Today(day time.Time, restrictions []models.RoomRestriction) Movements {
+	var m Movements
+	for _, rr := range restrictions {
+		if rr.RestrictionID != models.RestrictionReservation {
+			continue
+		}
+		if sameDay(rr.StartDate, day) {
+			m.Arrivals = append(m.Arrivals, rr)
+		}
+		if sameDay(rr.EndDate, day) {
+			m.Departures = append(m.Departures, rr)
+		}
+	}
+	return m
+}
+
+// clip returns the nights from start to end inside the period, as day
+// offsets from its start
+func clip(start, end time.Time, p Period) (first, last int) {
+	first = nights(p.Start, start)
+	if first < 0 {
+		first = 0
+	}
+	last = nights(p.Start, end)
+	if days := p.Days(); last > days {
+		last = days
+	}
+	if last < first {
+		last = first
+	}
+	return first, last
+}
+
+// nights returns the number of days from the date of start to the date of
+// end, negative when end is before start
+func nights(start, end time.Time) int {
+	return int(date(end).Sub(date(start)).Hours() / 24)
+}
+
+func date(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, time.UTC)
+}
+
+func sameDay(a, b time.Time) bool {
+	return date(a).Equal(date(b))
+}
+
+func ratio(n, total int) float64 {
+	if total <= 0 {
+		return 0
+	}
+	return float64(n) / float64(total)
+}
@@ -0,0 +1,161 @@
+package reports
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+func day(d int) time.Time {
+	return time.Date(2050, 1, d, 0, 0, 0, 0, time.UTC)
+}
+
+var testRooms = []models.Room{{ID: 1, RoomName: "General's Quarters"}, {ID: 2, RoomName: "Major's Suite"}}
+
+// testRestrictions in January 2050: room 1 is sold on the nights of the 1st
+// to the 4th and 9th to 10th, room 2 is blocked on the 1st to the 7th and a
+// stay from December ends on the 2nd
+var testRestrictions = []models.RoomRestriction{
+	{RoomID: 1, StartDate: day(1), EndDate: day(5), RestrictionID: models.RestrictionReservation,
+		Reservation: models.Reservation{CreatedAt: time.Date(2049, 12, 22, 18, 30, 0, 0, time.UTC)}},
+	{RoomID: 1, StartDate: day(9), EndDate: day(11), RestrictionID: models.RestrictionReservation,
+		CreatedAt: day(8)},
+	{RoomID: 2, StartDate: day(1), EndDate: day(8), RestrictionID: models.RestrictionOwnerBlock},
+	{RoomID: 2, StartDate: time.Date(2049, 12, 30, 0, 0, 0, 0, time.UTC), EndDate: day(2), RestrictionID: models.RestrictionReservation},
+	{RoomID: 3, StartDate: day(1), EndDate: day(3), RestrictionID: models.RestrictionReservation},
+}
+
+func near(a, b float64) bool {
+	return math.Abs(a-b) < 1e-9
+}
+
+func TestCompute(t *testing.T) {
+	p := Period{Start: day(1), End: day(11)}
+	s := Compute(p, testRooms, testRestrictions)
+
+	var tests = []struct {
+		name                     string
+		got                      RoomStats
+		sold, blocked, available int
+		occupancy                float64
+	}{
+		{"room 1", s.Rooms[0], 6, 0, 4, 0.6},
+		{"room 2", s.Rooms[1], 1, 7, 2, 1.0 / 3},
+		{"total", s.Total, 7, 7, 6, 7.0 / 13},
+	}
+	for _, e := range tests {
+		if e.got.Sold != e.sold || e.got.Blocked != e.blocked || e.got.Available != e.available {
+			t.Errorf("%s: got %d sold, %d blocked, %d available", e.name, e.got.Sold, e.got.Blocked, e.got.Available)
+		}
+		if !near(e.got.Occupancy, e.occupancy) {
+			t.Errorf("%s: got occupancy %f, want %f", e.name, e.got.Occupancy, e.occupancy)
+		}
+	}
+
+	//the stay from December arrived before the period
+	if s.Arrivals != 2 || !near(s.AverageStay, 3) {
+		t.Errorf("got %d arrivals staying %f nights", s.Arrivals, s.AverageStay)
+	}
+	//booked 10 days and 1 day ahead
+	if !near(s.AverageLeadTime, 5.5) {
+		t.Errorf("got lead time %f", s.AverageLeadTime)
+	}
+
+	if len(s.Days) != 10 {
+		t.Fatalf("got %d days", len(s.Days))
+	}
+	if d := s.Days[0]; !d.Date.Equal(day(1)) || d.Sold != 2 || d.Blocked != 1 {
+		t.Errorf("unexpected first night %+v", d)
+	}
+	if d := s.Days[7]; d.Sold != 0 || d.Blocked != 0 {
+		t.Errorf("unexpected night of the 8th %+v", d)
+	}
+}
+
+func TestCompute_Empty(t *testing.T) {
+	s := Compute(Period{Start: day(1), End: day(1)}, testRooms, testRestrictions)
+	if len(s.Days) != 0 || s.Total.Sold != 0 || s.Total.Occupancy != 0 || s.Arrivals != 0 {
+		t.Errorf("unexpected stats of an empty period %+v", s)
+	}
+	s = Compute(Period{Start: day(1), End: day(31)}, nil, testRestrictions)
+	if len(s.Rooms) != 0 || s.Total.Occupancy != 0 {
+		t.Errorf("unexpected stats without rooms %+v", s)
+	}
+}
+
+func TestToday(t *testing.T) {
+	m := Today(time.Date(2050, 1, 1, 15, 0, 0, 0, time.Local), testRestrictions)
+	if len(m.Arrivals) != 2 || len(m.Departures) != 0 {
+		t.Errorf("got %d arrivals and %d departures on the 1st", len(m.Arrivals), len(m.Departures))
+	}
+	m = Today(day(2), testRestrictions)
+	if len(m.Arrivals) != 0 || len(m.Departures) != 1 {
+		t.Errorf("got %d arrivals and %d departures on the 2nd", len(m.Arrivals), len(m.Departures))
+	}
+}
+
+func TestCharts(t *testing.T) {
+	bars := BarChart("Occupancy <rooms>", []Bar{{Label: "A&B", Value: 0.5, Text: "50%"}}, 1)
+	s := string(bars)
+	if !strings.HasPrefix(s, "<svg") || !strings.HasSuffix(s, "</svg>") {
+		t.Errorf("not an svg: %s", s)
+	}
+	if !strings.Contains(s, "A&amp;B") || !strings.Contains(s, "Occupancy &lt;rooms&gt;") {
+		t.Errorf("labels are not escaped: %s", s)
+	}
+	if strings.Contains(s, "style=") {
+		t.Errorf("inline styles are blocked by the CSP: %s", s)
+	}
+
+	stats := Compute(Period{Start: day(1), End: day(11)}, testRooms, testRestrictions)
+	s = string(DailyChart("Nights", stats.Days, len(testRooms)))
+	if n := strings.Count(s, "<rect"); n != 2*len(stats.Days) {
+		t.Errorf("got %d rects, want %d", n, 2*len(stats.Days))
+	}
+	if !strings.Contains(s, "2050-01-01: 2 sold, 1 blocked") {
+		t.Errorf("missing the first night: %s", s)
+	}
+}
+
+func TestHousekeeping(t *testing.T) {
+	rooms := append(testRooms, models.Room{ID: 3, RoomName: "Colonel's Cabin"})
+	restrictions := []models.RoomRestriction{
+		{RoomID: 1, StartDate: day(1), EndDate: day(3), RestrictionID: models.RestrictionReservation},
+		{RoomID: 1, StartDate: day(3), EndDate: day(6), RestrictionID: models.RestrictionReservation},
+		{RoomID: 2, StartDate: day(2), EndDate: day(5), RestrictionID: models.RestrictionReservation},
+		{RoomID: 3, StartDate: day(3), EndDate: day(4), RestrictionID: models.RestrictionOwnerBlock},
+	}
+	tasks := []models.HousekeepingTask{{ID: 9, RoomID: 1, Date: day(3), Status: models.HousekeepingCleaning, UpdatedBy: "ann"}}
+
+	list := Housekeeping(time.Date(2050, 1, 3, 8, 0, 0, 0, time.Local), rooms, restrictions, tasks)
+	var tests = []struct {
+		name     string
+		got      RoomDay
+		activity string
+		turnover bool
+		status   string
+	}{
+		{"turnover", list[0], "check-out, check-in", true, models.HousekeepingCleaning},
+		{"stay-over", list[1], "stay-over", false, models.HousekeepingDirty},
+		{"owner block", list[2], "owner block", false, models.HousekeepingClean},
+	}
+	for _, e := range tests {
+		if e.got.Activity() != e.activity || e.got.Turnover() != e.turnover || e.got.Task.Status != e.status {
+			t.Errorf("%s: got %q, turnover %v, %s", e.name, e.got.Activity(), e.got.Turnover(), e.got.Task.Status)
+		}
+	}
+	if list[0].Task.UpdatedBy != "ann" || list[2].Task.RoomID != 3 || !list[2].Task.Date.Equal(day(3)) {
+		t.Errorf("unexpected tasks %+v, %+v", list[0].Task, list[2].Task)
+	}
+
+	list = Housekeeping(day(6), rooms, restrictions, nil)
+	if list[0].Activity() != "check-out" || list[0].Task.Status != models.HousekeepingDirty {
+		t.Errorf("room 1 on the 6th: %q, %s", list[0].Activity(), list[0].Task.Status)
+	}
+	if list[2].Activity() != "vacant" {
+		t.Errorf("room 3 on the 6th: %q", list[2].Activity())
+	}
+}
@@ -1,7 +1,12 @@
 package dbrepo
 
 import (
+	"context"
 	"database/sql"
+	"log/slog"
+	"runtime"
+	"strings"
+	"time"
 
 	"github.com/acceleraterA/go_app_udemy/internal/config"
 	"github.com/acceleraterA/go_app_udemy/internal/repository"
@@ -27,3 +32,62 @@ func NewTestingRepo(a *config.AppConfig) repository.DatabaseRepo {
 		App: a,
 	}
 }
+
+// queryTimeout is how long a repository method may wait for the database,
+// streamTimeout how long one streaming its rows to a caller may take
+const (
+	queryTimeout  = 3 * time.Second
+	streamTimeout = 10 * time.Minute
+)
+
+// queryContext returns ctx with the query timeout, its cancel func records
+// how long the calling repository method took
+func (m *postgresDBRepo) queryContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return m.timeoutContext(ctx, queryTimeout)
+}
+
+// streamContext is queryContext for methods streaming rows
+func (m *postgresDBRepo) streamContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return m.timeoutContext(ctx, streamTimeout)
+}
+
+func (m *postgresDBRepo) timeoutContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	if m.App == nil || m.App.Metrics == nil {
+		return ctx, cancel
+	}
+	start := time.Now()
+	method := callerName(3)
+	return ctx, func() {
+		cancel()
+		m.App.Metrics.ObserveQuery(method, time.Since(start))
+	}
+}
+
+// callerName returns the name of the function skip frames up the stack,
+// without its package and receiver
+func callerName(skip int) string {
+	pc, _, _, ok := runtime.Caller(skip)
+	if !ok {
+		return "unknown"
+	}
+	name := runtime.FuncForPC(pc).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// logError logs a failed query with the request in ctx, the source of the
+// line is the caller
+func (m *postgresDBRepo) logError(ctx context.Context, err error) {
+	logger := slog.Default()
+	if m.App != nil && m.App.Logger != nil {
+		logger = m.App.Logger
+	}
+	if !logger.Enabled(ctx, slog.LevelError) {
+		return
+	}
+	var pcs [1]uintptr
+	runtime.Callers(2, pcs[:])
+	r := slog.NewRecord(time.Now(), slog.LevelError, "database error", pcs[0])
+	r.AddAttrs(slog.Any("err", err))
+	_ = logger.Handler().Handle(ctx, r)
+}
@@ -2,30 +2,66 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
+	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 	"golang.org/x/crypto/bcrypt"
 )
 
-// define function for postgresDBrepo
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns all staff users ordered by name, guest accounts are left out
+func (m *postgresDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var users []models.User
+	query := `select id, first_name, last_name, email, access_level, active, created_at, updated_at
+		from users where access_level <> $1 order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.AccessLevelGuest)
+	if err != nil {
+		m.logError(ctx, err)
+		return users, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u models.User
+		err = rows.Scan(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.AccessLevel, &u.Active, &u.CreatedAt, &u.UpdatedAt)
+		if err != nil {
+			m.logError(ctx, err)
+			return users, err
+		}
+		users = append(users, u)
+	}
+	if err = rows.Err(); err != nil {
+		m.logError(ctx, err)
+		return users, err
+	}
+	return users, nil
 }
 
 // InsertReservation inserts a reservation into a database and return the new reservation id
-func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *postgresDBRepo) InsertReservation(ctx context.Context, actor models.Actor, res models.Reservation) (int, error) {
 	//close the transaction after the 5 minutes lifetime if nothing is happening
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
-	var newID int
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	defer tx.Rollback()
 
 	//sql query
-	stmt := `insert into reservations (first_name,last_name,email,phone,start_date,end_date, room_id, created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9) returning id`
+	if res.Status == "" {
+		res.Status = models.ReservationConfirmed
+	}
+	stmt := `insert into reservations (first_name,last_name,email,phone,start_date,end_date, room_id, status, user_id, hold_reason, created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) returning id`
 
-	err := m.DB.QueryRowContext(ctx, stmt,
+	err = tx.QueryRowContext(ctx, stmt,
 		res.FirstName,
 		res.LastName,
 		res.Email,
@@ -33,44 +69,63 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.Status,
+		sql.NullInt64{Int64: int64(res.UserID), Valid: res.UserID != 0},
+		res.HoldReason,
 		time.Now(),
 		time.Now(),
-	).Scan(&newID)
+	).Scan(&res.ID)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return 0, err
 	}
-	return newID, nil
+	err = writeAudit(ctx, tx, actor, models.AuditCreate, models.EntityReservation, res.ID, nil, res)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return res.ID, tx.Commit()
 }
 
 // Inserts a room restriction into the database
-func (m *postgresDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
+func (m *postgresDBRepo) InsertRoomRestriction(ctx context.Context, actor models.Actor, res models.RoomRestriction) error {
 	//close the transaction after the 5 minutes lifetime if nothing is happening
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
-	stmt := `insert into room_restrictions (start_date,end_date, room_id, reservation_id, restriction_id, created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7)`
-
-	_, err := m.DB.ExecContext(ctx, stmt,
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+	stmt := `insert into room_restrictions (start_date,end_date, room_id, reservation_id, restriction_id, created_at,updated_at) values($1,$2,$3,$4,$5,$6,$7) returning id`
 
+	err = tx.QueryRowContext(ctx, stmt,
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
-		res.ReservationID,
+		//owner blocks belong to no reservation
+		sql.NullInt64{Int64: int64(res.ReservationID), Valid: res.ReservationID != 0},
 		res.RestrictionID,
 		time.Now(),
 		time.Now(),
-	)
+	).Scan(&res.ID)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return err
 	}
-	return nil
+	err = writeAudit(ctx, tx, actor, models.AuditCreate, models.EntityRoomRestriction, res.ID, nil, restrictionSnapshot(res))
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
 }
 
 // SearchAvailabilityByDatesByRoomID return s true if avaiability exists for roomID,
-func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 	//close the transaction after the 5 minutes lifetime if nothing is happening
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 	query := `
 		SELECT
@@ -84,16 +139,16 @@ func (m *postgresDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time,
 		start, end, roomID)
 	err := row.Scan(&numRows)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return false, err
 	}
 	return numRows == 0, nil
 }
 
 // returns a slice of available rooms, if any for given date range
-func (m *postgresDBRepo) SearchAvalibilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *postgresDBRepo) SearchAvalibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 	//close the transaction after the 5 minutes lifetime if nothing is happening
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 	var rooms []models.Room
 	query :=
@@ -107,7 +162,7 @@ func (m *postgresDBRepo) SearchAvalibilityForAllRooms(start, end time.Time) ([]m
 	rows, err := m.DB.QueryContext(ctx, query, start, end)
 
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return rooms, err
 	}
 	for rows.Next() {
@@ -126,8 +181,8 @@ func (m *postgresDBRepo) SearchAvalibilityForAllRooms(start, end time.Time) ([]m
 }
 
 // getroombyid gets a room by id and return room
-func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 	var room models.Room
 	query := `
@@ -138,62 +193,1812 @@ where id=$1`
 		id)
 	err := row.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return room, err
 	}
 	return room, nil
 }
 
+// AllRooms returns every room by id
+func (m *postgresDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var rooms []models.Room
+	rows, err := m.DB.QueryContext(ctx, `select id, room_name, created_at, updated_at from rooms order by id`)
+	if err != nil {
+		m.logError(ctx, err)
+		return rooms, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var room models.Room
+		if err := rows.Scan(&room.ID, &room.RoomName, &room.CreatedAt, &room.UpdatedAt); err != nil {
+			return rooms, err
+		}
+		rooms = append(rooms, room)
+	}
+	return rooms, rows.Err()
+}
+
+// InsertRoom inserts a room and returns its id
+func (m *postgresDBRepo) InsertRoom(ctx context.Context, actor models.Actor, room models.Room) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx, `insert into rooms (room_name, created_at, updated_at) values ($1, $2, $3) returning id`,
+		room.RoomName, time.Now(), time.Now()).Scan(&room.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditCreate, models.EntityRoom, room.ID, nil,
+		map[string]interface{}{"ID": room.ID, "RoomName": room.RoomName})
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return room.ID, tx.Commit()
+}
+
+// RoomRestrictionsBetween returns the reservations and owner blocks of every
+// room overlapping start to end, with the date each reservation was made
+func (m *postgresDBRepo) RoomRestrictionsBetween(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var restrictions []models.RoomRestriction
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, coalesce(rr.reservation_id, 0), rr.restriction_id,
+			rr.created_at, rr.updated_at, coalesce(r.created_at, rr.created_at)
+		from room_restrictions rr
+		left join reservations r on (r.id = rr.reservation_id)
+		where rr.start_date < $2 and rr.end_date > $1
+		order by rr.start_date, rr.room_id`
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		m.logError(ctx, err)
+		return restrictions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var rr models.RoomRestriction
+		err := rows.Scan(&rr.ID, &rr.StartDate, &rr.EndDate, &rr.RoomID, &rr.ReservationID, &rr.RestrictionID,
+			&rr.CreatedAt, &rr.UpdatedAt, &rr.Reservation.CreatedAt)
+		if err != nil {
+			return restrictions, err
+		}
+		rr.Reservation.ID = rr.ReservationID
+		restrictions = append(restrictions, rr)
+	}
+	return restrictions, rows.Err()
+}
+
+// OwnerBlocks returns the owner blocks overlapping start to end, by start date
+func (m *postgresDBRepo) OwnerBlocks(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var blocks []models.RoomRestriction
+	query := `
+		select rr.id, rr.start_date, rr.end_date, rr.room_id, rr.restriction_id, rr.created_at, rr.updated_at, r.room_name
+		from room_restrictions rr
+		left join rooms r on (r.id = rr.room_id)
+		where rr.restriction_id = $1 and rr.start_date < $3 and rr.end_date > $2
+		order by rr.start_date, rr.room_id`
+	rows, err := m.DB.QueryContext(ctx, query, models.RestrictionOwnerBlock, start, end)
+	if err != nil {
+		m.logError(ctx, err)
+		return blocks, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var b models.RoomRestriction
+		err := rows.Scan(&b.ID, &b.StartDate, &b.EndDate, &b.RoomID, &b.RestrictionID, &b.CreatedAt, &b.UpdatedAt, &b.Room.RoomName)
+		if err != nil {
+			return blocks, err
+		}
+		b.Room.ID = b.RoomID
+		blocks = append(blocks, b)
+	}
+	return blocks, rows.Err()
+}
+
+// DeleteOwnerBlock deletes the owner block with the id, restrictions made by
+// reservations are left alone; sql.ErrNoRows means there is no such block
+func (m *postgresDBRepo) DeleteOwnerBlock(ctx context.Context, actor models.Actor, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var b models.RoomRestriction
+	err = tx.QueryRowContext(ctx, `delete from room_restrictions where id = $1 and restriction_id = $2
+		returning id, start_date, end_date, room_id, restriction_id`, id, models.RestrictionOwnerBlock,
+	).Scan(&b.ID, &b.StartDate, &b.EndDate, &b.RoomID, &b.RestrictionID)
+	if err != nil {
+		if !errors.Is(err, sql.ErrNoRows) {
+			m.logError(ctx, err)
+		}
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditDelete, models.EntityRoomRestriction, id, restrictionSnapshot(b), nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
 // GetUserByID gets a user by id and return user
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	return m.getUser(ctx, m.DB, "id=$1", id)
+}
+
+// GetUserByEmail returns the user with the given email
+func (m *postgresDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
+	return m.getUser(ctx, m.DB, "lower(email)=lower($1)", email)
+}
+
+// getUser returns the user matching where, through the database or a transaction
+func (m *postgresDBRepo) getUser(ctx context.Context, q queryRower, where string, arg interface{}) (models.User, error) {
 	var user models.User
-	query := `select id,first_name, last_name,email,password, access_level, created_at, updated_at
-	 from users where id=$1`
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.AccessLevel, &user.CreatedAt, &user.UpdatedAt)
+	query := `select id,first_name, last_name,email,password, access_level, active, email_verified, session_version,
+		totp_secret, totp_enabled, totp_last_counter, created_at, updated_at
+	 from users where ` + where
+	row := q.QueryRowContext(ctx, query, arg)
+	err := row.Scan(&user.ID, &user.FirstName, &user.LastName, &user.Email, &user.Password, &user.AccessLevel, &user.Active, &user.EmailVerified, &user.SessionVersion,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastCounter, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
 		return user, err
 	}
 	return user, nil
 }
 
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+// UpdateUser updates the profile, access level and active flag of a user. A
+// new email is unverified until the user follows the link mailed to it
+func (m *postgresDBRepo) UpdateUser(ctx context.Context, actor models.Actor, u models.User) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := m.getUser(ctx, tx, "id=$1", u.ID)
+	if err != nil {
+		return err
+	}
+	query := `update users set first_name=$1,last_name=$2,email=$3,access_level=$4,active=$5,updated_at=$6,
+		email_verified = email_verified and lower(email) = lower($3)
+		where id=$7`
+	_, err = tx.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, u.Active, time.Now(), u.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	after, err := m.getUser(ctx, tx, "id=$1", u.ID)
+	if err != nil {
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditUpdate, models.EntityUser, u.ID, userSnapshot(before), userSnapshot(after))
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertUser creates a user, u.Password must already be a bcrypt hash
+func (m *postgresDBRepo) InsertUser(ctx context.Context, actor models.Actor, u models.User) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	query := `insert into users (first_name, last_name, email, password, access_level, active, email_verified, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+	err = tx.QueryRowContext(ctx, query,
+		u.FirstName, u.LastName, u.Email, u.Password, u.AccessLevel, u.Active, u.EmailVerified, time.Now(), time.Now(),
+	).Scan(&u.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditCreate, models.EntityUser, u.ID, nil, userSnapshot(u))
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return u.ID, tx.Commit()
+}
+
+// VerifyEmail marks the user's email as verified and activates the account
+func (m *postgresDBRepo) VerifyEmail(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
-	query := `update users set first_name=$1,last_name=$2,email=$3,access_level=$4,updated_at=$5 
-	`
-	_, err := m.DB.ExecContext(ctx, query, u.FirstName, u.LastName, u.Email, u.AccessLevel, time.Now())
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		log.Println(err)
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := m.getUser(ctx, tx, "id=$1", id)
+	if err != nil {
+		return err
+	}
+	query := `update users set email_verified=true, active=true, updated_at=$1 where id=$2`
+	_, err = tx.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	after := before
+	after.EmailVerified, after.Active = true, true
+	//only the owner of the email can follow the link
+	actor := models.Actor{Type: models.ActorGuest, ID: id, Label: before.Email}
+	err = writeAudit(ctx, tx, actor, models.AuditVerifyEmail, models.EntityUser, id, userSnapshot(before), userSnapshot(after))
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// UpdateUserPassword stores a new bcrypt hash for a user and bumps their
+// session version, which logs out every existing session
+func (m *postgresDBRepo) UpdateUserPassword(ctx context.Context, actor models.Actor, id int, hash string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update users set password=$1, session_version=session_version+1, updated_at=$2 where id=$3`
+	_, err = tx.ExecContext(ctx, query, hash, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	//the hash itself stays out of the audit log
+	err = writeAudit(ctx, tx, actor, models.AuditPasswordChange, models.EntityUser, id, nil, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeleteUser deletes a user, their api keys and tokens go with them
+func (m *postgresDBRepo) DeleteUser(ctx context.Context, actor models.Actor, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := m.getUser(ctx, tx, "id=$1", id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from users where id=$1`, id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditDelete, models.EntityUser, id, userSnapshot(before), nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertUserToken stores the hash of a token mailed to a user
+func (m *postgresDBRepo) InsertUserToken(ctx context.Context, t models.UserToken) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	query := `insert into user_tokens (user_id, scope, token_hash, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+	_, err := m.DB.ExecContext(ctx, query, t.UserID, t.Scope, t.TokenHash, t.ExpiresAt, time.Now(), time.Now())
+	if err != nil {
+		m.logError(ctx, err)
 		return err
 	}
 	return nil
 }
 
+// ConsumeUserToken marks an unused, unexpired token as used and returns its user id,
+// the single update makes sure a token can't be used twice
+func (m *postgresDBRepo) ConsumeUserToken(ctx context.Context, scope, hash string) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var userID int
+	query := `update user_tokens set used_at=$1, updated_at=$1
+		where token_hash=$2 and scope=$3 and used_at is null and expires_at > $1
+		returning user_id`
+	err := m.DB.QueryRowContext(ctx, query, time.Now(), hash, scope).Scan(&userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return userID, nil
+}
+
+// dummyHash is compared against when the email is unknown, so a login for a
+// missing user costs as much time as a wrong password
+const dummyHash = "$2a$12$qsy5WcWySkLB0T.5/0DfqONbBBftZHFdIAXzj5AagXPeNNKRGbfCK"
+
+// ErrInvalidCredentials is returned for an unknown email and a wrong password alike
+var ErrInvalidCredentials = errors.New("invalid credentials")
+
 // Authenticate a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+func (m *postgresDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	ctx, cancel := m.queryContext(ctx)
 	defer cancel()
 	var id int
 	var hashedPassword string
 
-	row := m.DB.QueryRowContext(ctx, "select id,password from users where email=$1", email)
-	err := row.Scan(&id, &hashedPassword)
+	var active bool
+
+	row := m.DB.QueryRowContext(ctx, "select id,password,active from users where lower(email)=lower($1)", email)
+	err := row.Scan(&id, &hashedPassword, &active)
+	if err == sql.ErrNoRows {
+		//do the same work as for a real user before failing
+		_ = bcrypt.CompareHashAndPassword([]byte(dummyHash), []byte(testPassword))
+		return 0, "", ErrInvalidCredentials
+	} else if err != nil {
+		m.logError(ctx, err)
+		return 0, "", err
+	}
+
+	//check if the testpassword match with the one in database, cased with byte
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword || (err == nil && !active) {
+		return 0, "", ErrInvalidCredentials
+	} else if err != nil {
+		return 0, "", err
+	}
+	return id, hashedPassword, nil
+}
+
+// GuestReservations returns the reservations made by a guest account and,
+// when email is not empty, those made under that email without logging in;
+// latest arrival first
+func (m *postgresDBRepo) GuestReservations(ctx context.Context, userID int, email string) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, coalesce(r.user_id, 0), r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.user_id = $1 or ($2 <> '' and lower(r.email) = lower($2))
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, userID, email)
+	if err != nil {
+		m.logError(ctx, err)
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Status, &i.UserID, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// AllReservations returns all reservations with their room, newest arrival first
+func (m *postgresDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		m.logError(ctx, err)
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Status, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// ReservationsArriving returns the reservations arriving from start to end,
+// both inclusive, by arrival date
+func (m *postgresDBRepo) ReservationsArriving(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.start_date >= $1 and r.start_date <= $2
+		order by r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	if err != nil {
+		m.logError(ctx, err)
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Status, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	return reservations, rows.Err()
+}
+
+// EachReservationArriving calls fn with each reservation arriving from start
+// to end as it is read, fn's error stops it and is returned
+func (m *postgresDBRepo) EachReservationArriving(ctx context.Context, start, end time.Time, fn func(models.Reservation) error) error {
+	ctx, cancel := m.streamContext(ctx)
+	defer cancel()
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.start_date >= $1 and r.start_date <= $2
+		order by r.start_date, r.id`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end)
 	if err != nil {
-		log.Println(err)
-		return id, "", err
-	} else {
-		//check if the testpassword match with the one in database, cased with byte
-		err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
-		if err == bcrypt.ErrMismatchedHashAndPassword {
-			//_,err=m.DB.ExecContext(ctx,"update users set password=$1",)
-			return 0, "", errors.New("incorrect password")
-		} else if err != nil {
-			return 0, "", err
+		m.logError(ctx, err)
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Status, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		if err != nil {
+			return err
+		}
+		if err := fn(i); err != nil {
+			return err
 		}
-		return id, hashedPassword, nil
 	}
+	return rows.Err()
+}
+
+// InsertAPIKey inserts a new api key and returns its id
+func (m *postgresDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var newID int
+	stmt := `insert into api_keys (name, prefix, key_hash, scopes, user_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		k.Name,
+		k.Prefix,
+		k.KeyHash,
+		strings.Join(k.Scopes, ","),
+		k.UserID,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return newID, nil
+}
+
+// AllAPIKeys returns all api keys, including revoked ones
+func (m *postgresDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var keys []models.APIKey
+	query := `select id, name, prefix, key_hash, scopes, coalesce(user_id, 0), last_used_at, revoked_at, created_at, updated_at
+		from api_keys order by created_at desc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		m.logError(ctx, err)
+		return keys, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		k, err := scanAPIKey(rows)
+		if err != nil {
+			return keys, err
+		}
+		keys = append(keys, k)
+	}
+	if err = rows.Err(); err != nil {
+		return keys, err
+	}
+	return keys, nil
+}
+
+// GetAPIKeyByHash gets an api key by the hash of the key
+func (m *postgresDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	query := `select id, name, prefix, key_hash, scopes, coalesce(user_id, 0), last_used_at, revoked_at, created_at, updated_at
+		from api_keys where key_hash = $1`
+
+	k, err := scanAPIKey(m.DB.QueryRowContext(ctx, query, hash))
+	if err != nil {
+		m.logError(ctx, err)
+		return k, err
+	}
+	return k, nil
+}
+
+// RevokeAPIKey marks an api key as revoked, revoked keys stay listed for reference
+func (m *postgresDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	stmt := `update api_keys set revoked_at = $1, updated_at = $1 where id = $2 and revoked_at is null`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// UpdateAPIKeyLastUsed records that an api key was just used
+func (m *postgresDBRepo) UpdateAPIKeyLastUsed(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	stmt := `update api_keys set last_used_at = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, stmt, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// queryRower is implemented by *sql.DB and *sql.Tx
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func scanAPIKey(row scanner) (models.APIKey, error) {
+	var k models.APIKey
+	var scopes string
+	var lastUsed, revoked sql.NullTime
+	err := row.Scan(&k.ID, &k.Name, &k.Prefix, &k.KeyHash, &scopes, &k.UserID, &lastUsed, &revoked, &k.CreatedAt, &k.UpdatedAt)
+	if err != nil {
+		return k, err
+	}
+	if scopes != "" {
+		k.Scopes = strings.Split(scopes, ",")
+	}
+	k.LastUsedAt = lastUsed.Time
+	k.RevokedAt = revoked.Time
+	return k, nil
+}
+
+// GetReservationByID returns one reservation with its room
+func (m *postgresDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	return m.getReservation(ctx, m.DB, id)
+}
+
+// getReservation returns one reservation with its room, through the database or a transaction
+func (m *postgresDBRepo) getReservation(ctx context.Context, q queryRower, id int) (models.Reservation, error) {
+	var res models.Reservation
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, coalesce(r.user_id, 0), r.hold_reason, r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.id = $1`
+
+	row := q.QueryRowContext(ctx, query, id)
+	err := row.Scan(&res.ID, &res.FirstName, &res.LastName, &res.Email, &res.Phone, &res.StartDate, &res.EndDate,
+		&res.RoomID, &res.Status, &res.UserID, &res.HoldReason, &res.CreatedAt, &res.UpdatedAt, &res.Room.ID, &res.Room.RoomName)
+	if err != nil {
+		m.logError(ctx, err)
+		return res, err
+	}
+	return res, nil
+}
+
+// UpdateReservation updates the guest details, room and dates of a
+// reservation and moves its room restriction along with it
+func (m *postgresDBRepo) UpdateReservation(ctx context.Context, actor models.Actor, res models.Reservation) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := m.getReservation(ctx, tx, res.ID)
+	if err != nil {
+		return err
+	}
+	stmt := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4,
+		start_date = $5, end_date = $6, room_id = $7, updated_at = $8 where id = $9`
+	_, err = tx.ExecContext(ctx, stmt, res.FirstName, res.LastName, res.Email, res.Phone,
+		res.StartDate, res.EndDate, res.RoomID, time.Now(), res.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	blocked, err := m.queryRestrictions(ctx, tx, `select `+restrictionColumns+` from room_restrictions
+		where reservation_id = $1 for update`, res.ID)
+	if err != nil {
+		return err
+	}
+	stmt = `update room_restrictions set start_date = $1, end_date = $2, room_id = $3, updated_at = $4
+		where reservation_id = $5 returning ` + restrictionColumns
+	moved, err := m.queryRestrictions(ctx, tx, stmt, res.StartDate, res.EndDate, res.RoomID, time.Now(), res.ID)
+	if err != nil {
+		return err
+	}
+	for _, r := range moved {
+		for _, b := range blocked {
+			if b.ID != r.ID {
+				continue
+			}
+			err = writeAudit(ctx, tx, actor, models.AuditUpdate, models.EntityRoomRestriction, r.ID, restrictionSnapshot(b), restrictionSnapshot(r))
+			if err != nil {
+				m.logError(ctx, err)
+				return err
+			}
+		}
+	}
+	after, err := m.getReservation(ctx, tx, res.ID)
+	if err != nil {
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditUpdate, models.EntityReservation, res.ID, before, after)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelReservation marks a reservation as cancelled and frees its dates
+func (m *postgresDBRepo) CancelReservation(ctx context.Context, actor models.Actor, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := m.getReservation(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, updated_at = $2 where id = $3`,
+		models.ReservationCancelled, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	freed, err := m.queryRestrictions(ctx, tx, `delete from room_restrictions where reservation_id = $1
+		returning `+restrictionColumns, id)
+	if err != nil {
+		return err
+	}
+	for _, r := range freed {
+		err = writeAudit(ctx, tx, actor, models.AuditDelete, models.EntityRoomRestriction, r.ID, restrictionSnapshot(r), nil)
+		if err != nil {
+			m.logError(ctx, err)
+			return err
+		}
+	}
+	after, err := m.getReservation(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditCancel, models.EntityReservation, id, before, after)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// HeldReservations returns the reservations waiting for approval, oldest first
+func (m *postgresDBRepo) HeldReservations(ctx context.Context) ([]models.Reservation, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+	query := `
+		select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date, r.end_date,
+			r.room_id, r.status, r.hold_reason, r.created_at, r.updated_at, rm.id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.status = $1
+		order by r.created_at`
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationHeld)
+	if err != nil {
+		m.logError(ctx, err)
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.Phone, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Status, &i.HoldReason, &i.CreatedAt, &i.UpdatedAt, &i.Room.ID, &i.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, i)
+	}
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+	return reservations, nil
+}
+
+// ErrReservationNotHeld is returned when approving a reservation that isn't
+// waiting for approval, like one approved by someone else meanwhile
+var ErrReservationNotHeld = errors.New("reservation is not held")
+
+// ErrRoomUnavailable is returned when approving a reservation whose room has
+// been booked for its dates since it was held
+var ErrRoomUnavailable = errors.New("room is not available for these dates")
+
+// ApproveReservation confirms a held reservation and blocks its dates with a
+// room restriction, if the room is still free for them
+func (m *postgresDBRepo) ApproveReservation(ctx context.Context, actor models.Actor, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	//a reservation approved twice at once is approved once, the second
+	//approval waits and finds it confirmed
+	_, err = tx.ExecContext(ctx, `select id from reservations where id = $1 for update`, id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	before, err := m.getReservation(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	if before.Status != models.ReservationHeld {
+		return fmt.Errorf("reservation %d is %s: %w", id, before.Status, ErrReservationNotHeld)
+	}
+	//approvals of other reservations for the room wait for this one to
+	//block its dates before checking theirs
+	_, err = tx.ExecContext(ctx, `select id from rooms where id = $1 for update`, before.RoomID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	available, err := m.roomAvailable(ctx, tx, before.StartDate, before.EndDate, before.RoomID, id)
+	if err != nil {
+		return err
+	}
+	if !available {
+		return ErrRoomUnavailable
+	}
+	_, err = tx.ExecContext(ctx, `update reservations set status = $1, hold_reason = '', updated_at = $2 where id = $3`,
+		models.ReservationConfirmed, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	restriction := models.RoomRestriction{
+		StartDate:     before.StartDate,
+		EndDate:       before.EndDate,
+		RoomID:        before.RoomID,
+		ReservationID: id,
+		RestrictionID: 1,
+	}
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, reservation_id, restriction_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+	err = tx.QueryRowContext(ctx, stmt, restriction.StartDate, restriction.EndDate, restriction.RoomID,
+		restriction.ReservationID, restriction.RestrictionID, time.Now(), time.Now()).Scan(&restriction.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditCreate, models.EntityRoomRestriction, restriction.ID, nil, restrictionSnapshot(restriction))
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	after, err := m.getReservation(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditApprove, models.EntityReservation, id, before, after)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// RoomAvailableForReservation returns true if a room is free for the dates,
+// ignoring the restriction held by the reservation itself
+func (m *postgresDBRepo) RoomAvailableForReservation(ctx context.Context, start, end time.Time, roomID, reservationID int) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	return m.roomAvailable(ctx, m.DB, start, end, roomID, reservationID)
+}
+
+// roomAvailable is RoomAvailableForReservation through the database or a transaction
+func (m *postgresDBRepo) roomAvailable(ctx context.Context, q queryRower, start, end time.Time, roomID, reservationID int) (bool, error) {
+	query := `
+		select count(id)
+		from room_restrictions
+		where $1 < end_date and $2 > start_date and room_id = $3
+			and (reservation_id is null or reservation_id <> $4)`
+	var numRows int
+	err := q.QueryRowContext(ctx, query, start, end, roomID, reservationID).Scan(&numRows)
+	if err != nil {
+		m.logError(ctx, err)
+		return false, err
+	}
+	return numRows == 0, nil
+}
+
+// AllWebhookEndpoints returns every configured webhook endpoint
+func (m *postgresDBRepo) AllWebhookEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var endpoints []models.WebhookEndpoint
+	query := `select id, url, secret, events, active, created_at, updated_at from webhook_endpoints order by id`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		m.logError(ctx, err)
+		return endpoints, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.WebhookEndpoint
+		var events string
+		err := rows.Scan(&e.ID, &e.URL, &e.Secret, &events, &e.Active, &e.CreatedAt, &e.UpdatedAt)
+		if err != nil {
+			return endpoints, err
+		}
+		if events != "" {
+			e.Events = strings.Split(events, ",")
+		}
+		endpoints = append(endpoints, e)
+	}
+	if err = rows.Err(); err != nil {
+		return endpoints, err
+	}
+	return endpoints, nil
+}
+
+// WebhookEndpointsForEvent returns the active endpoints subscribed to event
+func (m *postgresDBRepo) WebhookEndpointsForEvent(ctx context.Context, event string) ([]models.WebhookEndpoint, error) {
+	all, err := m.AllWebhookEndpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var endpoints []models.WebhookEndpoint
+	for _, e := range all {
+		if e.Active && e.Subscribed(event) {
+			endpoints = append(endpoints, e)
+		}
+	}
+	return endpoints, nil
+}
+
+// InsertWebhookEndpoint adds a webhook endpoint and returns its id
+func (m *postgresDBRepo) InsertWebhookEndpoint(ctx context.Context, e models.WebhookEndpoint) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var newID int
+	stmt := `insert into webhook_endpoints (url, secret, events, active, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, e.URL, e.Secret, strings.Join(e.Events, ","), e.Active, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return newID, nil
+}
+
+// DeleteWebhookEndpoint removes an endpoint together with its delivery log
+func (m *postgresDBRepo) DeleteWebhookEndpoint(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from webhook_endpoints where id = $1`, id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// InsertWebhookDelivery records a delivery and returns its id
+func (m *postgresDBRepo) InsertWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var newID int
+	stmt := `insert into webhook_deliveries (webhook_endpoint_id, event, payload, status, attempts, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt, d.WebhookEndpointID, d.Event, d.Payload, d.Status, d.Attempts, time.Now(), time.Now()).Scan(&newID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return newID, nil
+}
+
+// UpdateWebhookDelivery saves the outcome of a delivery attempt
+func (m *postgresDBRepo) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var deliveredAt sql.NullTime
+	if !d.DeliveredAt.IsZero() {
+		deliveredAt = sql.NullTime{Time: d.DeliveredAt, Valid: true}
+	}
+	stmt := `update webhook_deliveries set status = $1, attempts = $2, response_code = $3, last_error = $4,
+		delivered_at = $5, updated_at = $6 where id = $7`
+
+	_, err := m.DB.ExecContext(ctx, stmt, d.Status, d.Attempts, d.ResponseCode, d.LastError, deliveredAt, time.Now(), d.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return nil
+}
+
+const webhookDeliveryQuery = `
+	select d.id, d.webhook_endpoint_id, d.event, d.payload, d.status, d.attempts, d.response_code,
+		d.last_error, d.delivered_at, d.created_at, d.updated_at, e.id, e.url, e.secret
+	from webhook_deliveries d
+	left join webhook_endpoints e on (d.webhook_endpoint_id = e.id)`
+
+func scanWebhookDelivery(row scanner) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	var deliveredAt sql.NullTime
+	err := row.Scan(&d.ID, &d.WebhookEndpointID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.ResponseCode,
+		&d.LastError, &deliveredAt, &d.CreatedAt, &d.UpdatedAt, &d.Endpoint.ID, &d.Endpoint.URL, &d.Endpoint.Secret)
+	d.DeliveredAt = deliveredAt.Time
+	return d, err
+}
+
+// GetWebhookDeliveryByID returns a delivery with the url and secret of its endpoint
+func (m *postgresDBRepo) GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+
+	d, err := scanWebhookDelivery(m.DB.QueryRowContext(ctx, webhookDeliveryQuery+` where d.id = $1`, id))
+	if err != nil {
+		m.logError(ctx, err)
+		return d, err
+	}
+	return d, nil
+}
+
+// PendingWebhookDeliveries returns the deliveries that still have to be sent
+func (m *postgresDBRepo) PendingWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	return m.queryWebhookDeliveries(ctx, webhookDeliveryQuery+` where d.status = $1 order by d.id`, models.DeliveryPending)
+}
+
+// RecentWebhookDeliveries returns the latest deliveries for the delivery log
+func (m *postgresDBRepo) RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	return m.queryWebhookDeliveries(ctx, webhookDeliveryQuery+` order by d.id desc limit $1`, limit)
+}
+
+func (m *postgresDBRepo) queryWebhookDeliveries(ctx context.Context, query string, args ...interface{}) ([]models.WebhookDelivery, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var deliveries []models.WebhookDelivery
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		m.logError(ctx, err)
+		return deliveries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return deliveries, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	if err = rows.Err(); err != nil {
+		return deliveries, err
+	}
+	return deliveries, nil
+}
+
+// InsertLoginAttempt records a login attempt
+func (m *postgresDBRepo) InsertLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	query := `insert into login_attempts (email, ip_address, success, locked, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6)`
+	_, err := m.DB.ExecContext(ctx, query, strings.ToLower(a.Email), a.IPAddress, a.Success, a.Locked, time.Now(), time.Now())
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return nil
+}
+
+// LoginFailures counts the failed attempts since the given time for an email,
+// stopping at its last successful login, and for an ip address. Attempts
+// refused while throttled are left out, retrying must not extend a lockout
+func (m *postgresDBRepo) LoginFailures(ctx context.Context, email, ip string, since time.Time) (models.LoginFailures, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var f models.LoginFailures
+	var accountLast, ipLast sql.NullTime
+	query := `
+		select
+			count(*) filter (where email = $1 and created_at > coalesce(
+				(select max(created_at) from login_attempts where email = $1 and success), $3)),
+			max(created_at) filter (where email = $1),
+			count(*) filter (where ip_address = $2),
+			max(created_at) filter (where ip_address = $2)
+		from login_attempts
+		where not success and not locked and created_at > $3 and (email = $1 or ip_address = $2)`
+
+	err := m.DB.QueryRowContext(ctx, query, strings.ToLower(email), ip, since).Scan(
+		&f.Account, &accountLast, &f.IP, &ipLast,
+	)
+	if err != nil {
+		m.logError(ctx, err)
+		return f, err
+	}
+	f.AccountLast = accountLast.Time
+	f.IPLast = ipLast.Time
+	return f, nil
+}
+
+// RecentLoginAttempts returns the latest failed and locked out login attempts
+func (m *postgresDBRepo) RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var attempts []models.LoginAttempt
+	query := `select id, email, ip_address, success, locked, created_at
+		from login_attempts where not success order by created_at desc limit $1`
+
+	rows, err := m.DB.QueryContext(ctx, query, limit)
+	if err != nil {
+		m.logError(ctx, err)
+		return attempts, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var a models.LoginAttempt
+		err = rows.Scan(&a.ID, &a.Email, &a.IPAddress, &a.Success, &a.Locked, &a.CreatedAt)
+		if err != nil {
+			m.logError(ctx, err)
+			return attempts, err
+		}
+		attempts = append(attempts, a)
+	}
+	if err = rows.Err(); err != nil {
+		m.logError(ctx, err)
+		return attempts, err
+	}
+	return attempts, nil
+}
+
+// EnableTOTP stores the encrypted totp secret of a user and replaces their recovery codes
+func (m *postgresDBRepo) EnableTOTP(ctx context.Context, actor models.Actor, userID int, secret string, recoveryHashes []string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret=$1, totp_enabled=true, totp_last_counter=0, updated_at=$2 where id=$3`,
+		secret, time.Now(), userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id=$1`, userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	for _, hash := range recoveryHashes {
+		_, err = tx.ExecContext(ctx, `insert into user_recovery_codes (user_id, code_hash, created_at, updated_at) values ($1, $2, $3, $4)`,
+			userID, hash, time.Now(), time.Now())
+		if err != nil {
+			m.logError(ctx, err)
+			return err
+		}
+	}
+	//the secret and the codes stay out of the audit log
+	err = writeAudit(ctx, tx, actor, models.AuditEnableTOTP, models.EntityUser, userID, nil, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// DisableTOTP removes the totp secret and recovery codes of a user
+func (m *postgresDBRepo) DisableTOTP(ctx context.Context, actor models.Actor, userID int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret='', totp_enabled=false, totp_last_counter=0, updated_at=$1 where id=$2`,
+		time.Now(), userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `delete from user_recovery_codes where user_id=$1`, userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditDisableTOTP, models.EntityUser, userID, nil, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// UseTOTPCounter records the time step of an accepted code, it fails when
+// that step or a later one was already used
+func (m *postgresDBRepo) UseTOTPCounter(ctx context.Context, userID int, counter int64) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, `update users set totp_last_counter=$1 where id=$2 and totp_last_counter < $1`, counter, userID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("code was already used")
+	}
+	return nil
+}
+
+// UseRecoveryCode marks an unused recovery code of a user as used
+func (m *postgresDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	res, err := m.DB.ExecContext(ctx, `update user_recovery_codes set used_at=$1, updated_at=$1
+		where user_id=$2 and code_hash=$3 and used_at is null`, time.Now(), userID, hash)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return errors.New("recovery code is invalid or used")
+	}
+	return nil
+}
+
+// TwoFactorPolicy returns the access levels that must use two factor authentication
+func (m *postgresDBRepo) TwoFactorPolicy(ctx context.Context) (map[int]bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	policy := make(map[int]bool)
+	rows, err := m.DB.QueryContext(ctx, `select access_level, require_2fa from role_settings`)
+	if err != nil {
+		m.logError(ctx, err)
+		return policy, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var level int
+		var required bool
+		if err = rows.Scan(&level, &required); err != nil {
+			m.logError(ctx, err)
+			return policy, err
+		}
+		policy[level] = required
+	}
+	if err = rows.Err(); err != nil {
+		m.logError(ctx, err)
+		return policy, err
+	}
+	return policy, nil
+}
+
+// SetTwoFactorRequired turns the two factor requirement of an access level
+// on or off, saving the setting it already has changes nothing
+func (m *postgresDBRepo) SetTwoFactorRequired(ctx context.Context, actor models.Actor, level int, required bool) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	var before interface{}
+	var was bool
+	err = tx.QueryRowContext(ctx, `select require_2fa from role_settings where access_level=$1 for update`, level).Scan(&was)
+	switch {
+	case err == nil:
+		if was == required {
+			return nil
+		}
+		before = map[string]interface{}{"AccessLevel": level, "Require2FA": was}
+	case !errors.Is(err, sql.ErrNoRows):
+		m.logError(ctx, err)
+		return err
+	}
+	query := `insert into role_settings (access_level, require_2fa, created_at, updated_at) values ($1, $2, $3, $3)
+		on conflict (access_level) do update set require_2fa=excluded.require_2fa, updated_at=excluded.updated_at`
+	_, err = tx.ExecContext(ctx, query, level, required, time.Now())
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	after := map[string]interface{}{"AccessLevel": level, "Require2FA": required}
+	err = writeAudit(ctx, tx, actor, models.AuditUpdate, models.EntityRoleSettings, level, before, after)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// writeAudit appends a change to the audit log inside the transaction making
+// it, so the entry exists if and only if the change does
+func writeAudit(ctx context.Context, tx *sql.Tx, actor models.Actor, action, entityType string, entityID int, before, after interface{}) error {
+	b, err := auditSnapshot(before)
+	if err != nil {
+		return err
+	}
+	a, err := auditSnapshot(after)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `insert into audit_log (actor_type, actor_id, actor_label, action, entity_type, entity_id, before, after, created_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9)`,
+		actor.Type, actor.ID, actor.Label, action, entityType, entityID, b, a, time.Now())
+	return err
+}
+
+// auditSnapshot marshals an entity to JSON, nil is stored as null
+func auditSnapshot(v interface{}) (sql.NullString, error) {
+	if v == nil {
+		return sql.NullString{}, nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return sql.NullString{}, err
+	}
+	return sql.NullString{String: string(b), Valid: true}, nil
+}
+
+// userSnapshot leaves the password hash and totp secret out of the audit log
+func userSnapshot(u models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":            u.ID,
+		"FirstName":     u.FirstName,
+		"LastName":      u.LastName,
+		"Email":         u.Email,
+		"AccessLevel":   u.AccessLevel,
+		"Active":        u.Active,
+		"EmailVerified": u.EmailVerified,
+		"TOTPEnabled":   u.TOTPEnabled,
+	}
+}
+
+// restrictionColumns are the room_restrictions columns queryRestrictions scans
+const restrictionColumns = `id, start_date, end_date, room_id, coalesce(reservation_id, 0), restriction_id`
+
+// queryRestrictions returns the room restrictions a query selects or
+// returns, as restrictionColumns
+func (m *postgresDBRepo) queryRestrictions(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) ([]models.RoomRestriction, error) {
+	var restrictions []models.RoomRestriction
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		m.logError(ctx, err)
+		return restrictions, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r models.RoomRestriction
+		err := rows.Scan(&r.ID, &r.StartDate, &r.EndDate, &r.RoomID, &r.ReservationID, &r.RestrictionID)
+		if err != nil {
+			return restrictions, err
+		}
+		restrictions = append(restrictions, r)
+	}
+	return restrictions, rows.Err()
+}
+
+// restrictionSnapshot leaves out the empty room, reservation and restriction
+func restrictionSnapshot(r models.RoomRestriction) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":            r.ID,
+		"StartDate":     r.StartDate,
+		"EndDate":       r.EndDate,
+		"RoomID":        r.RoomID,
+		"ReservationID": r.ReservationID,
+		"RestrictionID": r.RestrictionID,
+	}
+}
+
+// AuditLog returns the audit entries matching the filter, newest first
+func (m *postgresDBRepo) AuditLog(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var entries []models.AuditEntry
+
+	var where []string
+	var args []interface{}
+	add := func(cond string, arg interface{}) {
+		args = append(args, arg)
+		where = append(where, fmt.Sprintf(cond, len(args)))
+	}
+	if f.ActorType != "" {
+		add("actor_type = $%d", f.ActorType)
+	}
+	if f.ActorID != 0 {
+		add("actor_id = $%d", f.ActorID)
+	}
+	if f.Action != "" {
+		add("action = $%d", f.Action)
+	}
+	if f.EntityType != "" {
+		add("entity_type = $%d", f.EntityType)
+	}
+	if f.EntityID != 0 {
+		add("entity_id = $%d", f.EntityID)
+	}
+	if !f.From.IsZero() {
+		add("created_at >= $%d", f.From)
+	}
+	if !f.To.IsZero() {
+		add("created_at < $%d", f.To)
+	}
+	query := `select id, actor_type, actor_id, actor_label, action, entity_type, entity_id,
+		coalesce(before::text, ''), coalesce(after::text, ''), created_at from audit_log`
+	if len(where) > 0 {
+		query += " where " + strings.Join(where, " and ")
+	}
+	if f.Limit <= 0 {
+		f.Limit = 100
+	}
+	args = append(args, f.Limit)
+	query += fmt.Sprintf(" order by created_at desc, id desc limit $%d", len(args))
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		m.logError(ctx, err)
+		return entries, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e models.AuditEntry
+		err = rows.Scan(&e.ID, &e.Actor.Type, &e.Actor.ID, &e.Actor.Label, &e.Action, &e.EntityType, &e.EntityID,
+			&e.Before, &e.After, &e.CreatedAt)
+		if err != nil {
+			m.logError(ctx, err)
+			return entries, err
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		m.logError(ctx, err)
+		return entries, err
+	}
+	return entries, nil
+}
+
+// HousekeepingTasks returns the housekeeping statuses set for a date, rooms
+// without one have no task yet
+func (m *postgresDBRepo) HousekeepingTasks(ctx context.Context, date time.Time) ([]models.HousekeepingTask, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var tasks []models.HousekeepingTask
+	rows, err := m.DB.QueryContext(ctx, `
+		select id, room_id, date, status, updated_by
+		from housekeeping_tasks
+		where date = $1
+		order by room_id`, date)
+	if err != nil {
+		m.logError(ctx, err)
+		return tasks, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var t models.HousekeepingTask
+		if err := rows.Scan(&t.ID, &t.RoomID, &t.Date, &t.Status, &t.UpdatedBy); err != nil {
+			return tasks, err
+		}
+		tasks = append(tasks, t)
+	}
+	return tasks, rows.Err()
+}
+
+// SetHousekeepingStatus sets the status of the room on the date of task,
+// creating its task the first time
+func (m *postgresDBRepo) SetHousekeepingStatus(ctx context.Context, actor models.Actor, task models.HousekeepingTask) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	//before stays nil for a new task
+	var before interface{}
+	var old models.HousekeepingTask
+	err = tx.QueryRowContext(ctx, `
+		select id, room_id, date, status, updated_by from housekeeping_tasks
+		where room_id = $1 and date = $2 for update`, task.RoomID, task.Date).
+		Scan(&old.ID, &old.RoomID, &old.Date, &old.Status, &old.UpdatedBy)
+	switch {
+	case err == nil:
+		before = old
+	case !errors.Is(err, sql.ErrNoRows):
+		m.logError(ctx, err)
+		return err
+	}
+
+	task.UpdatedBy = actor.Label
+	err = tx.QueryRowContext(ctx, `
+		insert into housekeeping_tasks (room_id, date, status, updated_by, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $5)
+		on conflict (room_id, date) do update
+		set status = excluded.status, updated_by = excluded.updated_by, updated_at = excluded.updated_at
+		returning id`,
+		task.RoomID, task.Date, task.Status, task.UpdatedBy, time.Now()).Scan(&task.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	action := models.AuditUpdate
+	if before == nil {
+		action = models.AuditCreate
+	}
+	err = writeAudit(ctx, tx, actor, action, models.EntityHousekeepingTask, task.ID, before, task)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// guestEmailDates is the reservation date each kind of guest email is sent
+// around
+var guestEmailDates = map[string]string{
+	models.GuestEmailPreArrival: "r.start_date",
+	models.GuestEmailPostStay:   "r.end_date",
+}
+
+// GuestEmailsDue returns the confirmed reservations arriving from start to
+// end for pre-arrival emails, or leaving from start to end for post-stay
+// ones, that weren't sent that kind of email yet
+func (m *postgresDBRepo) GuestEmailsDue(ctx context.Context, kind string, start, end time.Time) ([]models.Reservation, error) {
+	column, ok := guestEmailDates[kind]
+	if !ok {
+		return nil, fmt.Errorf("unknown guest email %q", kind)
+	}
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var reservations []models.Reservation
+	query := fmt.Sprintf(`
+		select r.id, r.first_name, r.last_name, r.email, r.start_date, r.end_date,
+			r.room_id, rm.room_name
+		from reservations r
+		left join rooms rm on (r.room_id = rm.id)
+		where r.status = $1 and %[1]s >= $2 and %[1]s <= $3
+			and not exists (select 1 from guest_emails g where g.reservation_id = r.id and g.kind = $4)
+		order by %[1]s, r.id`, column)
+
+	rows, err := m.DB.QueryContext(ctx, query, models.ReservationConfirmed, start, end, kind)
+	if err != nil {
+		m.logError(ctx, err)
+		return reservations, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i models.Reservation
+		err := rows.Scan(&i.ID, &i.FirstName, &i.LastName, &i.Email, &i.StartDate, &i.EndDate,
+			&i.RoomID, &i.Room.RoomName)
+		if err != nil {
+			return reservations, err
+		}
+		i.Room.ID = i.RoomID
+		reservations = append(reservations, i)
+	}
+	return reservations, rows.Err()
+}
+
+// MarkGuestEmailSent records that a kind of email went to the guest of a
+// reservation, it reports false when it already had been so callers claim
+// the email before sending it and never send it twice
+func (m *postgresDBRepo) MarkGuestEmailSent(ctx context.Context, reservationID int, kind string) (bool, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, `
+		insert into guest_emails (reservation_id, kind, sent_at, created_at, updated_at)
+		values ($1, $2, $3, $3, $3)
+		on conflict (reservation_id, kind) do nothing`,
+		reservationID, kind, time.Now())
+	if err != nil {
+		m.logError(ctx, err)
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// InsertContactMessage stores a message sent with the contact form and
+// returns its id
+func (m *postgresDBRepo) InsertContactMessage(ctx context.Context, msg models.ContactMessage) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var newID int
+	stmt := `insert into contact_messages (name, email, subject, message, status, hold_reason, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $7) returning id`
+	err := m.DB.QueryRowContext(ctx, stmt,
+		msg.Name,
+		msg.Email,
+		msg.Subject,
+		msg.Message,
+		msg.Status,
+		msg.HoldReason,
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return newID, nil
+}
+
+// contactMessageColumns are scanned by scanContactMessage
+const contactMessageColumns = `id, name, email, subject, message, status, hold_reason, read_at, created_at, updated_at`
+
+// scanContactMessage scans the contactMessageColumns of a row
+func scanContactMessage(row interface{ Scan(...interface{}) error }) (models.ContactMessage, error) {
+	var msg models.ContactMessage
+	var readAt sql.NullTime
+	err := row.Scan(&msg.ID, &msg.Name, &msg.Email, &msg.Subject, &msg.Message, &msg.Status, &msg.HoldReason,
+		&readAt, &msg.CreatedAt, &msg.UpdatedAt)
+	msg.ReadAt = readAt.Time
+	return msg, err
+}
+
+// ContactMessages returns the contact messages with a status, newest first
+func (m *postgresDBRepo) ContactMessages(ctx context.Context, status string) ([]models.ContactMessage, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	var messages []models.ContactMessage
+	rows, err := m.DB.QueryContext(ctx, `
+		select `+contactMessageColumns+`
+		from contact_messages
+		where status = $1
+		order by created_at desc, id desc`, status)
+	if err != nil {
+		m.logError(ctx, err)
+		return messages, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		msg, err := scanContactMessage(rows)
+		if err != nil {
+			return messages, err
+		}
+		messages = append(messages, msg)
+	}
+	return messages, rows.Err()
+}
+
+// GetContactMessageByID returns a contact message with its replies, oldest
+// first
+func (m *postgresDBRepo) GetContactMessageByID(ctx context.Context, id int) (models.ContactMessage, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	msg, err := scanContactMessage(m.DB.QueryRowContext(ctx, `
+		select `+contactMessageColumns+`
+		from contact_messages
+		where id = $1`, id))
+	if err != nil {
+		return msg, err
+	}
+
+	rows, err := m.DB.QueryContext(ctx, `
+		select id, contact_message_id, body, sent_by, created_at
+		from contact_replies
+		where contact_message_id = $1
+		order by created_at, id`, id)
+	if err != nil {
+		m.logError(ctx, err)
+		return msg, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var reply models.ContactReply
+		if err := rows.Scan(&reply.ID, &reply.ContactMessageID, &reply.Body, &reply.SentBy, &reply.CreatedAt); err != nil {
+			return msg, err
+		}
+		msg.Replies = append(msg.Replies, reply)
+	}
+	return msg, rows.Err()
+}
+
+// MarkContactMessageRead records when a contact message was first opened
+func (m *postgresDBRepo) MarkContactMessageRead(ctx context.Context, id int) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, `update contact_messages set read_at = $1 where id = $2 and read_at is null`,
+		time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+	}
+	return err
+}
+
+// contactSnapshot is what the audit log keeps of a contact message, not the
+// message itself
+func contactSnapshot(msg models.ContactMessage) map[string]interface{} {
+	return map[string]interface{}{
+		"ID":      msg.ID,
+		"Email":   msg.Email,
+		"Subject": msg.Subject,
+		"Status":  msg.Status,
+	}
+}
+
+// SetContactMessageStatus moves a contact message to the folder of status
+func (m *postgresDBRepo) SetContactMessageStatus(ctx context.Context, actor models.Actor, id int, status string) error {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	defer tx.Rollback()
+
+	before, err := scanContactMessage(tx.QueryRowContext(ctx, `
+		select `+contactMessageColumns+`
+		from contact_messages
+		where id = $1 for update`, id))
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, `update contact_messages set status = $1, updated_at = $2 where id = $3`,
+		status, time.Now(), id)
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	after := before
+	after.Status = status
+	err = writeAudit(ctx, tx, actor, models.AuditUpdate, models.EntityContactMessage, id, contactSnapshot(before), contactSnapshot(after))
+	if err != nil {
+		m.logError(ctx, err)
+		return err
+	}
+	return tx.Commit()
+}
+
+// InsertContactReply stores a reply to a contact message and returns its id
+func (m *postgresDBRepo) InsertContactReply(ctx context.Context, actor models.Actor, reply models.ContactReply) (int, error) {
+	ctx, cancel := m.queryContext(ctx)
+	defer cancel()
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	reply.SentBy = actor.Label
+	reply.CreatedAt = time.Now()
+	err = tx.QueryRowContext(ctx, `
+		insert into contact_replies (contact_message_id, body, sent_by, created_at, updated_at)
+		values ($1, $2, $3, $4, $4) returning id`,
+		reply.ContactMessageID, reply.Body, reply.SentBy, reply.CreatedAt).Scan(&reply.ID)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	err = writeAudit(ctx, tx, actor, models.AuditReply, models.EntityContactMessage, reply.ContactMessageID, nil, reply)
+	if err != nil {
+		m.logError(ctx, err)
+		return 0, err
+	}
+	return reply.ID, tx.Commit()
 }
@@ -0,0 +1,325 @@
+package dbrepo
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/config"
+	"github.com/acceleraterA/go_app_udemy/internal/driver"
+	"github.com/acceleraterA/go_app_udemy/internal/loginguard"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// testPostgres connects to the development database, skipping the test when
+// postgres is not running. The connection pool holds a single connection so
+// the temporary tables created by the test hide the real ones
+func testPostgres(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := driver.NewDatabase(driver.DSN())
+	if err != nil {
+		t.Skip("postgres is not running:", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+// createTempTables runs the create temporary table statements of a test
+func createTempTables(t *testing.T, db *sql.DB, stmts ...string) {
+	t.Helper()
+	for _, stmt := range stmts {
+		if _, err := db.Exec(stmt); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// tempUsers and tempAuditLog have the columns the repository reads and writes
+const (
+	tempUsers = `create temporary table users (
+		id serial primary key, first_name text not null default '', last_name text not null default '',
+		email text not null unique, password text not null default '', access_level integer not null default 1,
+		active bool not null default true, email_verified bool not null default false,
+		session_version integer not null default 1, totp_secret text not null default '',
+		totp_enabled bool not null default false, totp_last_counter bigint not null default 0,
+		created_at timestamp not null default now(), updated_at timestamp not null default now())`
+	tempRooms        = `create temporary table rooms (id integer primary key, room_name text not null)`
+	tempReservations = `create temporary table reservations (
+		id serial primary key, first_name text not null default '', last_name text not null default '',
+		email text not null, phone text not null default '', start_date date not null, end_date date not null,
+		room_id integer not null, status text not null default 'confirmed', user_id integer,
+		hold_reason text not null default '',
+		created_at timestamp not null default now(), updated_at timestamp not null default now())`
+	tempRoomRestrictions = `create temporary table room_restrictions (
+		id serial primary key, start_date date not null, end_date date not null, room_id integer not null,
+		reservation_id integer, restriction_id integer not null,
+		created_at timestamp not null default now(), updated_at timestamp not null default now())`
+	tempAuditLog = `create temporary table audit_log (
+		id serial primary key, actor_type text not null, actor_id integer not null default 0,
+		actor_label text not null default '', action text not null, entity_type text not null,
+		entity_id integer not null, before jsonb, after jsonb, created_at timestamp not null)`
+)
+
+func TestUpdateUser_NewEmailHidesBookingsUntilVerified(t *testing.T) {
+	db := testPostgres(t)
+	createTempTables(t, db, tempUsers, tempAuditLog,
+		tempRooms, tempReservations,
+		`insert into rooms (id, room_name) values (1, 'General''s Quarters')`,
+		`insert into users (id, first_name, email, access_level, email_verified) values (5, 'Mallory', 'mallory@here.com', 0, true)`,
+		`insert into reservations (email, start_date, end_date, room_id) values ('victim@here.com', '2050-01-01', '2050-01-03', 1)`,
+	)
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+	ctx := context.Background()
+	actor := models.Actor{Type: models.ActorUser, ID: 5}
+
+	//MyBookings only matches reservations on the email once it is verified
+	bookings := func() int {
+		t.Helper()
+		u, err := repo.GetUserByID(ctx, 5)
+		if err != nil {
+			t.Fatal(err)
+		}
+		email := ""
+		if u.EmailVerified {
+			email = u.Email
+		}
+		res, err := repo.GuestReservations(ctx, u.ID, email)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res)
+	}
+
+	u, _ := repo.GetUserByID(ctx, 5)
+	u.Email = "Mallory@Here.com"
+	if err := repo.UpdateUser(ctx, actor, u); err != nil {
+		t.Fatal(err)
+	}
+	if u, _ := repo.GetUserByID(ctx, 5); !u.EmailVerified {
+		t.Error("changing the case of the email reset its verification")
+	}
+
+	u.Email = "victim@here.com"
+	if err := repo.UpdateUser(ctx, actor, u); err != nil {
+		t.Fatal(err)
+	}
+	if n := bookings(); n != 0 {
+		t.Errorf("expected no bookings before the new email is verified, got %d", n)
+	}
+	if err := repo.VerifyEmail(ctx, 5); err != nil {
+		t.Fatal(err)
+	}
+	if n := bookings(); n != 1 {
+		t.Errorf("expected the booking once the new email is verified, got %d", n)
+	}
+}
+
+// auditActions returns the actions logged for an entity, oldest first
+func TestAllUsers_LeavesOutGuests(t *testing.T) {
+	db := testPostgres(t)
+	createTempTables(t, db, tempUsers,
+		`insert into users (email, access_level) values ('owner@here.com', 3), ('guest@here.com', 0)`,
+	)
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+
+	users, err := repo.AllUsers(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0].Email != "owner@here.com" {
+		t.Errorf("expected only the staff user, got %v", users)
+	}
+}
+
+func auditActions(t *testing.T, db *sql.DB, entityType string, entityID int) []string {
+	t.Helper()
+	rows, err := db.Query(`select action from audit_log where entity_type = $1 and entity_id = $2 order by id`, entityType, entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var actions []string
+	for rows.Next() {
+		var a string
+		if err := rows.Scan(&a); err != nil {
+			t.Fatal(err)
+		}
+		actions = append(actions, a)
+	}
+	return actions
+}
+
+func TestReservationChanges_AuditRoomRestrictions(t *testing.T) {
+	db := testPostgres(t)
+	createTempTables(t, db, tempRooms, tempReservations, tempRoomRestrictions, tempAuditLog,
+		`insert into rooms (id, room_name) values (1, 'General''s Quarters'), (2, 'Major''s Suite')`,
+		`insert into reservations (id, first_name, email, start_date, end_date, room_id) values (1, 'John', 'john@smith.com', '2050-01-01', '2050-01-03', 1)`,
+		`insert into room_restrictions (id, start_date, end_date, room_id, reservation_id, restriction_id) values (7, '2050-01-01', '2050-01-03', 1, 1, 1)`,
+	)
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+	ctx := context.Background()
+	actor := models.Actor{Type: models.ActorUser, ID: 1}
+
+	res, err := repo.GetReservationByID(ctx, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.StartDate, res.EndDate, res.RoomID = res.StartDate.AddDate(0, 0, 7), res.EndDate.AddDate(0, 0, 7), 2
+	if err := repo.UpdateReservation(ctx, actor, res); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.CancelReservation(ctx, actor, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	got := auditActions(t, db, models.EntityRoomRestriction, 7)
+	want := []string{models.AuditUpdate, models.AuditDelete}
+	if len(got) != len(want) || got[0] != want[0] || got[1] != want[1] {
+		t.Errorf("expected the restriction audited as %v, got %v", want, got)
+	}
+	var before, after string
+	err = db.QueryRow(`select before->>'RoomID', after->>'RoomID' from audit_log
+		where entity_type = $1 and action = $2`, models.EntityRoomRestriction, models.AuditUpdate).Scan(&before, &after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before != "1" || after != "2" {
+		t.Errorf("expected the move from room 1 to 2 audited, got %s to %s", before, after)
+	}
+}
+
+func TestApproveReservation_ChecksRoomInTransaction(t *testing.T) {
+	db := testPostgres(t)
+	createTempTables(t, db, tempRooms, tempReservations, tempRoomRestrictions, tempAuditLog,
+		`insert into rooms (id, room_name) values (1, 'General''s Quarters')`,
+		`insert into reservations (id, first_name, email, start_date, end_date, room_id, status) values
+			(1, 'John', 'john@smith.com', '2050-01-01', '2050-01-03', 1, 'held'),
+			(2, 'Jane', 'jane@smith.com', '2050-01-02', '2050-01-04', 1, 'held')`,
+	)
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+	ctx := context.Background()
+	actor := models.Actor{Type: models.ActorUser, ID: 1}
+
+	var tests = []struct {
+		name string
+		id   int
+		err  error
+	}{
+		{"free room", 1, nil},
+		{"approved twice", 1, ErrReservationNotHeld},
+		{"dates taken by the first", 2, ErrRoomUnavailable},
+	}
+
+	for _, e := range tests {
+		err := repo.ApproveReservation(ctx, actor, e.id)
+		if !errors.Is(err, e.err) {
+			t.Errorf("%s: expected %v, got %v", e.name, e.err, err)
+		}
+	}
+	var blocked int
+	if err := db.QueryRow(`select count(id) from room_restrictions`).Scan(&blocked); err != nil {
+		t.Fatal(err)
+	}
+	if blocked != 1 {
+		t.Errorf("expected one room restriction, got %d", blocked)
+	}
+}
+
+func TestTwoFactorChanges_Audited(t *testing.T) {
+	db := testPostgres(t)
+	createTempTables(t, db, tempUsers, tempAuditLog,
+		`create temporary table user_recovery_codes (
+			id serial primary key, user_id integer not null, code_hash text not null, used_at timestamp,
+			created_at timestamp not null, updated_at timestamp not null)`,
+		`create temporary table role_settings (
+			id serial primary key, access_level integer not null unique, require_2fa bool not null default false,
+			created_at timestamp not null, updated_at timestamp not null)`,
+		`insert into users (id, email) values (1, 'admin@here.com')`,
+	)
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+	ctx := context.Background()
+	actor := models.Actor{Type: models.ActorUser, ID: 1}
+
+	if err := repo.EnableTOTP(ctx, actor, 1, "sealed", []string{"hash"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := repo.DisableTOTP(ctx, actor, 1); err != nil {
+		t.Fatal(err)
+	}
+	got := auditActions(t, db, models.EntityUser, 1)
+	if len(got) != 2 || got[0] != models.AuditEnableTOTP || got[1] != models.AuditDisableTOTP {
+		t.Errorf("expected totp enable and disable audited, got %v", got)
+	}
+
+	//saving the form again with the same value is not a change
+	for _, required := range []bool{true, true, false} {
+		if err := repo.SetTwoFactorRequired(ctx, actor, models.AccessLevelOwner, required); err != nil {
+			t.Fatal(err)
+		}
+	}
+	got = auditActions(t, db, models.EntityRoleSettings, models.AccessLevelOwner)
+	if len(got) != 2 {
+		t.Errorf("expected two policy changes audited, got %v", got)
+	}
+	var secret string
+	err := db.QueryRow(`select coalesce(before::text, '') || coalesce(after::text, '') from audit_log
+		where entity_type = $1 and action = $2`, models.EntityUser, models.AuditEnableTOTP).Scan(&secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(secret, "sealed") {
+		t.Errorf("expected the totp secret kept out of the audit log, got %s", secret)
+	}
+}
+
+func TestLoginFailures_IgnoresRefusedAttempts(t *testing.T) {
+	db := testPostgres(t)
+	_, err := db.Exec(`create temporary table login_attempts (
+		id serial primary key, email text not null default '', ip_address text not null,
+		success bool not null default false, locked bool not null default false,
+		created_at timestamp not null, updated_at timestamp not null)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo := NewPostgresRepo(db, &config.AppConfig{})
+	ctx := context.Background()
+	since := time.Now().Add(-time.Hour)
+	attempt := models.LoginAttempt{Email: "user@here.com", IPAddress: "192.0.2.1"}
+
+	for i := 0; i < 5; i++ {
+		if err := repo.InsertLoginAttempt(ctx, attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	locked, err := repo.LoginFailures(ctx, attempt.Email, attempt.IPAddress, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := loginguard.DefaultPolicy()
+	if _, isLocked := policy.Check(locked, time.Now()); !isLocked || locked.Account != 5 {
+		t.Fatalf("expected a lockout after 5 failures, got %+v", locked)
+	}
+
+	//retrying while locked out is refused and recorded as locked
+	time.Sleep(10 * time.Millisecond)
+	attempt.Locked = true
+	for i := 0; i < 3; i++ {
+		if err := repo.InsertLoginAttempt(ctx, attempt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	retried, err := repo.LoginFailures(ctx, attempt.Email, attempt.IPAddress, since)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if retried.Account != locked.Account || retried.IP != locked.IP {
+		t.Errorf("refused attempts were counted: got %+v, want %+v", retried, locked)
+	}
+	if !retried.AccountLast.Equal(locked.AccountLast) || !retried.IPLast.Equal(locked.IPLast) {
+		t.Errorf("refused attempts extended the lockout: last failure moved from %s to %s", locked.AccountLast, retried.AccountLast)
+	}
+}
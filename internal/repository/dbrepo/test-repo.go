@@ -1,20 +1,32 @@
 package dbrepo
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"log"
 	"time"
 
+	"github.com/acceleraterA/go_app_udemy/internal/encryption"
+	"github.com/acceleraterA/go_app_udemy/internal/helpers"
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 )
 
-// define function for postgresDBrepo
-func (m *testDBRepo) AllUsers() bool {
-	return true
+// TestTOTPSecret is the totp secret of the test manager, user 2
+const TestTOTPSecret = "JBSWY3DPEHPK3PXP"
+
+// AllUsers returns one user of each role
+func (m *testDBRepo) AllUsers(ctx context.Context) ([]models.User, error) {
+	var users []models.User
+	for id := models.AccessLevelFrontDesk; id <= models.AccessLevelOwner; id++ {
+		u, _ := m.GetUserByID(ctx, id)
+		users = append(users, u)
+	}
+	return users, nil
 }
 
 // InsertReservation inserts a reservation into a database and return the new reservation id
-func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
+func (m *testDBRepo) InsertReservation(ctx context.Context, actor models.Actor, res models.Reservation) (int, error) {
 	//if the roomid is 3, fail
 	if res.RoomID == 3 {
 		return 0, errors.New("some error")
@@ -23,7 +35,7 @@ func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
 }
 
 // Inserts a room restriction into the database
-func (m *testDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
+func (m *testDBRepo) InsertRoomRestriction(ctx context.Context, actor models.Actor, res models.RoomRestriction) error {
 	if res.RoomID == 11 {
 		return errors.New("some error")
 	}
@@ -31,7 +43,7 @@ func (m *testDBRepo) InsertRoomRestriction(res models.RoomRestriction) error {
 }
 
 // SearchAvailabilityByDatesByRoomID return s true if avaiability exists for roomID,
-func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
+func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error) {
 	// set up a test time
 	layout := "2006-01-02"
 	str := "2049-12-31"
@@ -61,7 +73,7 @@ func (m *testDBRepo) SearchAvailabilityByDatesByRoomID(start, end time.Time, roo
 }
 
 // returns a slice of available rooms, if any for given date range
-func (m *testDBRepo) SearchAvalibilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvalibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error) {
 
 	var rooms []models.Room
 
@@ -69,7 +81,7 @@ func (m *testDBRepo) SearchAvalibilityForAllRooms(start, end time.Time) ([]model
 }
 
 // getroombyid gets a room by id and return room
-func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
+func (m *testDBRepo) GetRoomByID(ctx context.Context, id int) (models.Room, error) {
 
 	var room models.Room
 	if id != 1 && id != 3 {
@@ -78,21 +90,576 @@ func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	return room, nil
 }
 
-// getroombyid gets a room by id and return room
-func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
+// AllRooms returns the two rooms of the site
+func (m *testDBRepo) AllRooms(ctx context.Context) ([]models.Room, error) {
+	return []models.Room{
+		{ID: 1, RoomName: "General's Quarters"},
+		{ID: 2, RoomName: "Major's Suite"},
+	}, nil
+}
+
+// InsertRoom fails for a room without a name
+func (m *testDBRepo) InsertRoom(ctx context.Context, actor models.Actor, room models.Room) (int, error) {
+	if room.RoomName == "" {
+		return 0, errors.New("some error")
+	}
+	return 3, nil
+}
+
+// OwnerBlocks knows one block of room 2 in the first week of 2050
+func (m *testDBRepo) RoomRestrictionsBetween(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	if start.Year() == 2060 {
+		return nil, errors.New("some error")
+	}
+	all := []models.RoomRestriction{
+		{
+			ID:            1,
+			StartDate:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:       time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			RoomID:        1,
+			ReservationID: 1,
+			RestrictionID: models.RestrictionReservation,
+			Reservation:   models.Reservation{ID: 1, CreatedAt: time.Date(2049, 12, 2, 10, 0, 0, 0, time.UTC)},
+		},
+	}
+	blocks, _ := m.OwnerBlocks(ctx, start, end)
+	all = append(all, blocks...)
+	var restrictions []models.RoomRestriction
+	for _, rr := range all {
+		if rr.StartDate.Before(end) && rr.EndDate.After(start) {
+			restrictions = append(restrictions, rr)
+		}
+	}
+	return restrictions, nil
+}
+
+func (m *testDBRepo) OwnerBlocks(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error) {
+	b := models.RoomRestriction{
+		ID:            7,
+		StartDate:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 8, 0, 0, 0, 0, time.UTC),
+		RoomID:        2,
+		RestrictionID: models.RestrictionOwnerBlock,
+		Room:          models.Room{ID: 2, RoomName: "Major's Suite"},
+	}
+	if b.StartDate.Before(end) && b.EndDate.After(start) {
+		return []models.RoomRestriction{b}, nil
+	}
+	return nil, nil
+}
+
+// DeleteOwnerBlock knows block 7 only
+func (m *testDBRepo) DeleteOwnerBlock(ctx context.Context, actor models.Actor, id int) error {
+	if id != 7 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// GetUserByID knows user 1 (front-desk), 2 (manager), 3 (owner) and
+// 4 (a deactivated front-desk user)
+func (m *testDBRepo) GetUserByID(ctx context.Context, id int) (models.User, error) {
 
 	var user models.User
+	if id < 1 || id > 5 {
+		return user, errors.New("no such user")
+	}
+	user.ID = id
+	user.FirstName = "Test"
+	user.LastName = "User"
+	user.Email = "user@here.com"
+	user.AccessLevel = id
+	user.Active = true
+	user.EmailVerified = true
+	if id == models.AccessLevelManager {
+		//the manager has enrolled in two factor authentication
+		user.TOTPEnabled = true
+		user.TOTPSecret, _ = encryption.Encrypt(m.App.EncryptionKey, TestTOTPSecret)
+	}
+	if id == 4 {
+		user.AccessLevel = models.AccessLevelFrontDesk
+		user.Active = false
+	}
+	if id == 5 {
+		user.Email = "guest@here.com"
+		user.AccessLevel = models.AccessLevelGuest
+	}
 
 	return user, nil
 }
 
-func (m *testDBRepo) UpdateUser(u models.User) error {
+// GetUserByEmail only knows user@here.com, which is user 1
+func (m *testDBRepo) GetUserByEmail(ctx context.Context, email string) (models.User, error) {
+	if email != "user@here.com" {
+		return models.User{}, errors.New("no such user")
+	}
+	return m.GetUserByID(ctx, 1)
+}
+
+func (m *testDBRepo) UpdateUser(ctx context.Context, actor models.Actor, u models.User) error {
+	if u.ID == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertUser fails for an email that is already taken
+func (m *testDBRepo) InsertUser(ctx context.Context, actor models.Actor, u models.User) (int, error) {
+	if u.Email == "taken@here.com" {
+		return 0, errors.New("duplicate key value violates unique constraint")
+	}
+	return 5, nil
+}
+
+func (m *testDBRepo) VerifyEmail(ctx context.Context, id int) error {
+	return nil
+}
 
+func (m *testDBRepo) UpdateUserPassword(ctx context.Context, actor models.Actor, id int, hash string) error {
 	return nil
 }
 
-func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, error) {
-	//var id int
-	//var hashedPassword string
+func (m *testDBRepo) DeleteUser(ctx context.Context, actor models.Actor, id int) error {
+	if id == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertUserToken(ctx context.Context, t models.UserToken) error {
+	return nil
+}
+
+// ConsumeUserToken only accepts "valid-token", and only for user 1
+func (m *testDBRepo) ConsumeUserToken(ctx context.Context, scope, hash string) (int, error) {
+	if hash != helpers.HashToken("valid-token") {
+		return 0, errors.New("token is invalid or expired")
+	}
+	return 1, nil
+}
+
+// Authenticate logs manager@here.com in as user 2, owner@here.com as user 3,
+// guest@here.com as user 5 and everyone else as user 1, unless the password is "wrong"
+func (m *testDBRepo) Authenticate(ctx context.Context, email, testPassword string) (int, string, error) {
+	if testPassword == "wrong" {
+		return 0, "", ErrInvalidCredentials
+	}
+	switch email {
+	case "manager@here.com":
+		return models.AccessLevelManager, "", nil
+	case "owner@here.com":
+		return models.AccessLevelOwner, "", nil
+	case "guest@here.com":
+		return 5, "", nil
+	}
 	return 1, "", nil
 }
+
+// ReservationsArriving returns the reservations of AllReservations arriving
+// from start to end
+func (m *testDBRepo) ReservationsArriving(ctx context.Context, start, end time.Time) ([]models.Reservation, error) {
+	all, _ := m.AllReservations(ctx)
+	var reservations []models.Reservation
+	for _, res := range all {
+		if !res.StartDate.Before(start) && !res.StartDate.After(end) {
+			reservations = append(reservations, res)
+		}
+	}
+	return reservations, nil
+}
+
+func (m *testDBRepo) EachReservationArriving(ctx context.Context, start, end time.Time, fn func(models.Reservation) error) error {
+	if start.Year() == 2060 {
+		return errors.New("some error")
+	}
+	reservations, _ := m.ReservationsArriving(ctx, start, end)
+	for _, res := range reservations {
+		if err := fn(res); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *testDBRepo) AllReservations(ctx context.Context) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	res := models.Reservation{
+		ID:        1,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	reservations = append(reservations, res)
+	return reservations, nil
+}
+
+// GuestReservations returns one past and one upcoming stay for user 5
+func (m *testDBRepo) GuestReservations(ctx context.Context, userID int, email string) ([]models.Reservation, error) {
+	var reservations []models.Reservation
+	if userID != 5 {
+		return reservations, nil
+	}
+	reservations = append(reservations,
+		models.Reservation{ID: 2, FirstName: "Test", LastName: "User", Email: email, UserID: userID, RoomID: 1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+			Status: models.ReservationConfirmed, Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		models.Reservation{ID: 3, FirstName: "Test", LastName: "User", Email: email, RoomID: 2,
+			StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), EndDate: time.Date(2020, 1, 3, 0, 0, 0, 0, time.UTC),
+			Status: models.ReservationConfirmed, Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+	)
+	return reservations, nil
+}
+
+func (m *testDBRepo) InsertAPIKey(ctx context.Context, k models.APIKey) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) AllAPIKeys(ctx context.Context) ([]models.APIKey, error) {
+	var keys []models.APIKey
+	return keys, nil
+}
+
+// GetAPIKeyByHash knows the keys "read-key" (availability:read), "admin-key"
+// (every scope) and "revoked-key"
+func (m *testDBRepo) GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error) {
+	switch hash {
+	case helpers.HashAPIKey("read-key"):
+		return models.APIKey{ID: 1, Name: "read", Scopes: []string{models.ScopeAvailabilityRead}}, nil
+	case helpers.HashAPIKey("admin-key"):
+		return models.APIKey{ID: 2, Name: "admin", Scopes: models.APIScopes}, nil
+	case helpers.HashAPIKey("revoked-key"):
+		return models.APIKey{ID: 3, Name: "revoked", Scopes: models.APIScopes, RevokedAt: time.Now()}, nil
+	}
+	return models.APIKey{}, errors.New("no such api key")
+}
+
+func (m *testDBRepo) RevokeAPIKey(ctx context.Context, id int) error {
+	if id == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) UpdateAPIKeyLastUsed(ctx context.Context, id int) error {
+	return nil
+}
+
+// GetReservationByID knows reservation 1, 2 is cancelled and 3 is in room 3
+func (m *testDBRepo) GetReservationByID(ctx context.Context, id int) (models.Reservation, error) {
+	res := models.Reservation{
+		ID:        id,
+		FirstName: "John",
+		LastName:  "Smith",
+		Email:     "john@smith.com",
+		StartDate: time.Date(2040, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2040, 1, 3, 0, 0, 0, 0, time.UTC),
+		RoomID:    1,
+		Status:    models.ReservationConfirmed,
+		Room:      models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+	switch id {
+	case 1:
+	case 2:
+		res.Status = models.ReservationCancelled
+	case 3:
+		res.RoomID = 3
+	case 4:
+		res.Status = models.ReservationHeld
+		res.HoldReason = "honeypot filled in"
+	case 5:
+		//held for dates that were booked since
+		res.Status = models.ReservationHeld
+		res.StartDate = time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+		res.EndDate = time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	default:
+		return models.Reservation{}, errors.New("no such reservation")
+	}
+	return res, nil
+}
+
+func (m *testDBRepo) UpdateReservation(ctx context.Context, actor models.Actor, res models.Reservation) error {
+	if res.RoomID == 3 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) CancelReservation(ctx context.Context, actor models.Actor, id int) error {
+	if id == 3 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) HeldReservations(ctx context.Context) ([]models.Reservation, error) {
+	res, _ := m.GetReservationByID(ctx, 4)
+	return []models.Reservation{res}, nil
+}
+
+func (m *testDBRepo) ApproveReservation(ctx context.Context, actor models.Actor, id int) error {
+	res, err := m.GetReservationByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if res.Status != models.ReservationHeld {
+		return ErrReservationNotHeld
+	}
+	available, _ := m.RoomAvailableForReservation(ctx, res.StartDate, res.EndDate, res.RoomID, id)
+	if !available {
+		return ErrRoomUnavailable
+	}
+	return nil
+}
+
+func (m *testDBRepo) RoomAvailableForReservation(ctx context.Context, start, end time.Time, roomID, reservationID int) (bool, error) {
+	return m.SearchAvailabilityByDatesByRoomID(ctx, start, end, roomID)
+}
+
+func (m *testDBRepo) AllWebhookEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	return endpoints, nil
+}
+
+func (m *testDBRepo) WebhookEndpointsForEvent(ctx context.Context, event string) ([]models.WebhookEndpoint, error) {
+	var endpoints []models.WebhookEndpoint
+	return endpoints, nil
+}
+
+func (m *testDBRepo) InsertWebhookEndpoint(ctx context.Context, e models.WebhookEndpoint) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) DeleteWebhookEndpoint(ctx context.Context, id int) error {
+	if id == 100 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+func (m *testDBRepo) InsertWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int, error) {
+	return 1, nil
+}
+
+func (m *testDBRepo) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	return nil
+}
+
+func (m *testDBRepo) GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error) {
+	if id != 1 {
+		return models.WebhookDelivery{}, errors.New("no such delivery")
+	}
+	return models.WebhookDelivery{ID: 1, Status: models.DeliveryFailed}, nil
+}
+
+func (m *testDBRepo) PendingWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	return deliveries, nil
+}
+
+func (m *testDBRepo) RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error) {
+	var deliveries []models.WebhookDelivery
+	return deliveries, nil
+}
+
+func (m *testDBRepo) InsertLoginAttempt(ctx context.Context, a models.LoginAttempt) error {
+	return nil
+}
+
+// LoginFailures reports a locked out account for locked@here.com, a fresh
+// failure for slow@here.com and a locked out ip for 10.0.0.66
+func (m *testDBRepo) LoginFailures(ctx context.Context, email, ip string, since time.Time) (models.LoginFailures, error) {
+	var f models.LoginFailures
+	switch email {
+	case "locked@here.com":
+		f.Account, f.AccountLast = 5, time.Now()
+	case "slow@here.com":
+		f.Account, f.AccountLast = 3, time.Now()
+	}
+	if ip == "10.0.0.66" {
+		f.IP, f.IPLast = 50, time.Now()
+	}
+	return f, nil
+}
+
+func (m *testDBRepo) RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error) {
+	return []models.LoginAttempt{
+		{ID: 1, Email: "user@here.com", IPAddress: "192.0.2.1", CreatedAt: time.Now()},
+		{ID: 2, Email: "user@here.com", IPAddress: "192.0.2.1", Locked: true, CreatedAt: time.Now()},
+	}, nil
+}
+
+func (m *testDBRepo) EnableTOTP(ctx context.Context, actor models.Actor, userID int, secret string, recoveryHashes []string) error {
+	return nil
+}
+
+func (m *testDBRepo) DisableTOTP(ctx context.Context, actor models.Actor, userID int) error {
+	return nil
+}
+
+// UseTOTPCounter refuses the step 1, as if it had been used before
+func (m *testDBRepo) UseTOTPCounter(ctx context.Context, userID int, counter int64) error {
+	if counter == 1 {
+		return errors.New("code was already used")
+	}
+	return nil
+}
+
+// UseRecoveryCode accepts "recovery-code" for the manager, stored the way
+// handlers normalize it
+func (m *testDBRepo) UseRecoveryCode(ctx context.Context, userID int, hash string) error {
+	if userID != models.AccessLevelManager || hash != helpers.HashToken("recoverycode") {
+		return errors.New("recovery code is invalid or used")
+	}
+	return nil
+}
+
+// TwoFactorPolicy requires two factor authentication for owners
+func (m *testDBRepo) TwoFactorPolicy(ctx context.Context) (map[int]bool, error) {
+	return map[int]bool{models.AccessLevelOwner: true}, nil
+}
+
+func (m *testDBRepo) SetTwoFactorRequired(ctx context.Context, actor models.Actor, level int, required bool) error {
+	return nil
+}
+
+// AuditLog returns one reservation update by user 1 unless the filter asks for another entity type
+func (m *testDBRepo) AuditLog(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error) {
+	var entries []models.AuditEntry
+	if f.EntityType != "" && f.EntityType != models.EntityReservation {
+		return entries, nil
+	}
+	entries = append(entries, models.AuditEntry{
+		ID:         1,
+		Actor:      models.Actor{Type: models.ActorUser, ID: 1, Label: "user@here.com"},
+		Action:     models.AuditUpdate,
+		EntityType: models.EntityReservation,
+		EntityID:   1,
+		Before:     `{"StartDate":"2050-01-01T00:00:00Z"}`,
+		After:      `{"StartDate":"2050-01-02T00:00:00Z"}`,
+		CreatedAt:  time.Now(),
+	})
+	return entries, nil
+}
+
+func (m *testDBRepo) HousekeepingTasks(ctx context.Context, date time.Time) ([]models.HousekeepingTask, error) {
+	if date.Year() == 2060 {
+		return nil, errors.New("some error")
+	}
+	task := models.HousekeepingTask{
+		ID:        1,
+		RoomID:    1,
+		Date:      time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC),
+		Status:    models.HousekeepingCleaning,
+		UpdatedBy: "admin@here.com",
+	}
+	if date.Equal(task.Date) {
+		return []models.HousekeepingTask{task}, nil
+	}
+	return nil, nil
+}
+
+func (m *testDBRepo) SetHousekeepingStatus(ctx context.Context, actor models.Actor, task models.HousekeepingTask) error {
+	if task.RoomID > 2 {
+		return errors.New("no such room")
+	}
+	return nil
+}
+
+// GuestEmailsDue knows reservations 1 and 2 arriving on 2050-01-01 and
+// leaving on 2050-01-03
+func (m *testDBRepo) GuestEmailsDue(ctx context.Context, kind string, start, end time.Time) ([]models.Reservation, error) {
+	if start.Year() == 2060 {
+		return nil, errors.New("some error")
+	}
+	arrival := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+	departure := time.Date(2050, 1, 3, 0, 0, 0, 0, time.UTC)
+	day := arrival
+	if kind == models.GuestEmailPostStay {
+		day = departure
+	}
+	if day.Before(start) || day.After(end) {
+		return nil, nil
+	}
+	var reservations []models.Reservation
+	for id := 1; id <= 2; id++ {
+		reservations = append(reservations, models.Reservation{
+			ID: id, FirstName: "John", LastName: "Smith", Email: "john@smith.com",
+			StartDate: arrival, EndDate: departure, RoomID: 1,
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"},
+		})
+	}
+	return reservations, nil
+}
+
+// MarkGuestEmailSent reports reservation 2 as already sent
+func (m *testDBRepo) MarkGuestEmailSent(ctx context.Context, reservationID int, kind string) (bool, error) {
+	return reservationID != 2, nil
+}
+
+// InsertContactMessage fails for messages from error@here.com
+func (m *testDBRepo) InsertContactMessage(ctx context.Context, msg models.ContactMessage) (int, error) {
+	if msg.Email == "error@here.com" {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
+
+// ContactMessages knows message 1 in the inbox
+func (m *testDBRepo) ContactMessages(ctx context.Context, status string) ([]models.ContactMessage, error) {
+	if status == models.ContactInbox {
+		msg, _ := m.GetContactMessageByID(ctx, 1)
+		return []models.ContactMessage{msg}, nil
+	}
+	return nil, nil
+}
+
+// GetContactMessageByID knows message 1 in the inbox with a reply, 2 in spam
+// and 3 archived
+func (m *testDBRepo) GetContactMessageByID(ctx context.Context, id int) (models.ContactMessage, error) {
+	msg := models.ContactMessage{
+		ID:        id,
+		Name:      "John Smith",
+		Email:     "john@smith.com",
+		Subject:   "Parking",
+		Message:   "Is there parking at the house?",
+		Status:    models.ContactInbox,
+		CreatedAt: time.Date(2050, 1, 1, 9, 0, 0, 0, time.UTC),
+	}
+	switch id {
+	case 1:
+		msg.Replies = []models.ContactReply{{ID: 1, ContactMessageID: 1, Body: "Yes, for two cars.", SentBy: "admin@here.com",
+			CreatedAt: time.Date(2050, 1, 1, 10, 0, 0, 0, time.UTC)}}
+	case 2:
+		msg.Status = models.ContactSpam
+		msg.HoldReason = "honeypot filled in"
+	case 3:
+		msg.Status = models.ContactArchived
+	default:
+		return msg, errors.New("no such message")
+	}
+	return msg, nil
+}
+
+func (m *testDBRepo) MarkContactMessageRead(ctx context.Context, id int) error {
+	return nil
+}
+
+// SetContactMessageStatus fails for message 3
+func (m *testDBRepo) SetContactMessageStatus(ctx context.Context, actor models.Actor, id int, status string) error {
+	if id == 3 {
+		return errors.New("some error")
+	}
+	return nil
+}
+
+// InsertContactReply fails for message 3
+func (m *testDBRepo) InsertContactReply(ctx context.Context, actor models.Actor, reply models.ContactReply) (int, error) {
+	if reply.ContactMessageID == 3 {
+		return 0, errors.New("some error")
+	}
+	return 1, nil
+}
@@ -1,19 +1,83 @@
 package repository
 
 import (
+	"context"
 	"time"
 
 	"github.com/acceleraterA/go_app_udemy/internal/models"
 )
 
 type DatabaseRepo interface {
-	AllUsers() bool
-	InsertReservation(res models.Reservation) (int, error)
-	InsertRoomRestriction(res models.RoomRestriction) error
-	SearchAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvalibilityForAllRooms(start, end time.Time) ([]models.Room, error)
-	GetRoomByID(id int) (models.Room, error)
-	GetUserByID(id int) (models.User, error)
-	UpdateUser(u models.User) error
-	Authenticate(email, testPassword string) (int, string, error)
+	AllUsers(ctx context.Context) ([]models.User, error)
+	InsertReservation(ctx context.Context, actor models.Actor, res models.Reservation) (int, error)
+	InsertRoomRestriction(ctx context.Context, actor models.Actor, res models.RoomRestriction) error
+	SearchAvailabilityByDatesByRoomID(ctx context.Context, start, end time.Time, roomID int) (bool, error)
+	SearchAvalibilityForAllRooms(ctx context.Context, start, end time.Time) ([]models.Room, error)
+	GetRoomByID(ctx context.Context, id int) (models.Room, error)
+	AllRooms(ctx context.Context) ([]models.Room, error)
+	InsertRoom(ctx context.Context, actor models.Actor, room models.Room) (int, error)
+	RoomRestrictionsBetween(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error)
+	OwnerBlocks(ctx context.Context, start, end time.Time) ([]models.RoomRestriction, error)
+	DeleteOwnerBlock(ctx context.Context, actor models.Actor, id int) error
+	GetUserByID(ctx context.Context, id int) (models.User, error)
+	GetUserByEmail(ctx context.Context, email string) (models.User, error)
+	UpdateUser(ctx context.Context, actor models.Actor, u models.User) error
+	InsertUser(ctx context.Context, actor models.Actor, u models.User) (int, error)
+	UpdateUserPassword(ctx context.Context, actor models.Actor, id int, hash string) error
+	VerifyEmail(ctx context.Context, id int) error
+	DeleteUser(ctx context.Context, actor models.Actor, id int) error
+	InsertUserToken(ctx context.Context, t models.UserToken) error
+	ConsumeUserToken(ctx context.Context, scope, hash string) (int, error)
+	Authenticate(ctx context.Context, email, testPassword string) (int, string, error)
+	InsertLoginAttempt(ctx context.Context, a models.LoginAttempt) error
+	LoginFailures(ctx context.Context, email, ip string, since time.Time) (models.LoginFailures, error)
+	RecentLoginAttempts(ctx context.Context, limit int) ([]models.LoginAttempt, error)
+	EnableTOTP(ctx context.Context, actor models.Actor, userID int, secret string, recoveryHashes []string) error
+	DisableTOTP(ctx context.Context, actor models.Actor, userID int) error
+	UseTOTPCounter(ctx context.Context, userID int, counter int64) error
+	UseRecoveryCode(ctx context.Context, userID int, hash string) error
+	TwoFactorPolicy(ctx context.Context) (map[int]bool, error)
+	SetTwoFactorRequired(ctx context.Context, actor models.Actor, level int, required bool) error
+
+	AllReservations(ctx context.Context) ([]models.Reservation, error)
+	ReservationsArriving(ctx context.Context, start, end time.Time) ([]models.Reservation, error)
+	EachReservationArriving(ctx context.Context, start, end time.Time, fn func(models.Reservation) error) error
+	GuestReservations(ctx context.Context, userID int, email string) ([]models.Reservation, error)
+	GetReservationByID(ctx context.Context, id int) (models.Reservation, error)
+	UpdateReservation(ctx context.Context, actor models.Actor, res models.Reservation) error
+	CancelReservation(ctx context.Context, actor models.Actor, id int) error
+	HeldReservations(ctx context.Context) ([]models.Reservation, error)
+	ApproveReservation(ctx context.Context, actor models.Actor, id int) error
+	RoomAvailableForReservation(ctx context.Context, start, end time.Time, roomID, reservationID int) (bool, error)
+
+	InsertAPIKey(ctx context.Context, k models.APIKey) (int, error)
+	AllAPIKeys(ctx context.Context) ([]models.APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (models.APIKey, error)
+	RevokeAPIKey(ctx context.Context, id int) error
+	UpdateAPIKeyLastUsed(ctx context.Context, id int) error
+
+	AllWebhookEndpoints(ctx context.Context) ([]models.WebhookEndpoint, error)
+	WebhookEndpointsForEvent(ctx context.Context, event string) ([]models.WebhookEndpoint, error)
+	InsertWebhookEndpoint(ctx context.Context, e models.WebhookEndpoint) (int, error)
+	DeleteWebhookEndpoint(ctx context.Context, id int) error
+	InsertWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int, error)
+	UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error
+	GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error)
+	PendingWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error)
+	RecentWebhookDeliveries(ctx context.Context, limit int) ([]models.WebhookDelivery, error)
+
+	AuditLog(ctx context.Context, f models.AuditFilter) ([]models.AuditEntry, error)
+
+	HousekeepingTasks(ctx context.Context, date time.Time) ([]models.HousekeepingTask, error)
+	SetHousekeepingStatus(ctx context.Context, actor models.Actor, task models.HousekeepingTask) error
+
+	GuestEmailsDue(ctx context.Context, kind string, start, end time.Time) ([]models.Reservation, error)
+	MarkGuestEmailSent(ctx context.Context, reservationID int, kind string) (bool, error)
+
+	InsertContactMessage(ctx context.Context, msg models.ContactMessage) (int, error)
+	ContactMessages(ctx context.Context, status string) ([]models.ContactMessage, error)
+	GetContactMessageByID(ctx context.Context, id int) (models.ContactMessage, error)
+	MarkContactMessageRead(ctx context.Context, id int) error
+	SetContactMessageStatus(ctx context.Context, actor models.Actor, id int, status string) error
+	InsertContactReply(ctx context.Context, actor models.Actor, reply models.ContactReply) (int, error)
 }
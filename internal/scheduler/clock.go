@@ -0,0 +1,107 @@
+package scheduler
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits, the scheduler only sees time through it
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the channel once d has passed, like time.After
+	After(d time.Duration) <-chan time.Time
+}
+
+// RealClock is the wall clock
+type RealClock struct{}
+
+// Now returns time.Now
+func (RealClock) Now() time.Time {
+	return time.Now()
+}
+
+// After returns time.After
+func (RealClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
+
+// FakeClock only moves when told to, so tests decide when jobs run
+type FakeClock struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []waiter
+	changed chan struct{}
+}
+
+type waiter struct {
+	at time.Time
+	c  chan time.Time
+}
+
+// NewFakeClock returns a fake clock showing now
+func NewFakeClock(now time.Time) *FakeClock {
+	return &FakeClock{now: now, changed: make(chan struct{})}
+}
+
+// Now returns the time the clock shows
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+// After returns a channel sent the time once the clock is advanced by d
+func (c *FakeClock) After(d time.Duration) <-chan time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	ch := make(chan time.Time, 1)
+	if d <= 0 {
+		ch <- c.now
+		return ch
+	}
+	c.waiters = append(c.waiters, waiter{at: c.now.Add(d), c: ch})
+	c.notify()
+	return ch
+}
+
+// Advance moves the clock by d and wakes the waiters due by then, earliest
+// first
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+	sort.SliceStable(c.waiters, func(i, j int) bool {
+		return c.waiters[i].at.Before(c.waiters[j].at)
+	})
+	var left []waiter
+	for _, w := range c.waiters {
+		if w.at.After(c.now) {
+			left = append(left, w)
+			continue
+		}
+		w.c <- c.now
+	}
+	c.waiters = left
+	c.notify()
+}
+
+// BlockUntil waits until n goroutines wait on the clock, tests call it
+// before Advance so the jobs have rescheduled
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.mu.Lock()
+		waiting, changed := len(c.waiters), c.changed
+		c.mu.Unlock()
+		if waiting >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+// notify wakes BlockUntil, the lock must be held
+func (c *FakeClock) notify() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
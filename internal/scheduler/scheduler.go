@@ -0,0 +1,113 @@
+package scheduler
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// Schedule returns the next time a job runs after now
+type Schedule func(now time.Time) time.Time
+
+// Daily runs at the time at, as the time since midnight, every day in the
+// location of now
+func Daily(at time.Duration) Schedule {
+	return func(now time.Time) time.Time {
+		y, m, d := now.Date()
+		next := time.Date(y, m, d, 0, 0, 0, 0, now.Location()).Add(at)
+		if !next.After(now) {
+			next = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location()).Add(at)
+		}
+		return next
+	}
+}
+
+// Every runs each interval d after the previous run
+func Every(d time.Duration) Schedule {
+	return func(now time.Time) time.Time {
+		return now.Add(d)
+	}
+}
+
+// Job is the work of one run, now is the time it was due
+type Job func(ctx context.Context, now time.Time) error
+
+type job struct {
+	name     string
+	schedule Schedule
+	run      Job
+}
+
+// Scheduler runs jobs in the background of the web process on their
+// schedules, a job that fails is logged and runs again at its next time
+type Scheduler struct {
+	Clock  Clock
+	Logger *slog.Logger
+	// Timeout bounds each run of a job
+	Timeout time.Duration
+
+	jobs []job
+	wg   sync.WaitGroup
+	done chan struct{}
+}
+
+// New returns a scheduler on the wall clock, add the jobs then call Start
+func New(logger *slog.Logger) *Scheduler {
+	return &Scheduler{
+		Clock:   RealClock{},
+		Logger:  logger,
+		Timeout: time.Minute,
+		done:    make(chan struct{}),
+	}
+}
+
+// Add registers a job, it must be called before Start
+func (s *Scheduler) Add(name string, schedule Schedule, run Job) {
+	s.jobs = append(s.jobs, job{name: name, schedule: schedule, run: run})
+}
+
+// Start launches one goroutine per job
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop stops the jobs and waits for running ones to finish
+func (s *Scheduler) Stop() {
+	close(s.done)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j job) {
+	defer s.wg.Done()
+	for {
+		next := j.schedule(s.Clock.Now())
+		select {
+		case <-s.done:
+			return
+		case <-s.Clock.After(next.Sub(s.Clock.Now())):
+		}
+		s.runOnce(j, next)
+	}
+}
+
+// runOnce runs the job due at now, a panic is logged like an error so the
+// job keeps its schedule
+func (s *Scheduler) runOnce(j job, now time.Time) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	defer func() {
+		if p := recover(); p != nil {
+			s.Logger.Error("scheduled job panicked", "job", j.name, "panic", p)
+		}
+	}()
+	started := s.Clock.Now()
+	if err := j.run(ctx, now); err != nil {
+		s.Logger.Error("scheduled job failed", "job", j.name, "err", err)
+		return
+	}
+	s.Logger.Debug("scheduled job done", "job", j.name, "took", s.Clock.Now().Sub(started))
+}
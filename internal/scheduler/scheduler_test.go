@@ -0,0 +1,124 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+)
+
+func TestDaily(t *testing.T) {
+	at := 7 * time.Hour
+	var tests = []struct {
+		name string
+		now  time.Time
+		want time.Time
+	}{
+		{"before", time.Date(2050, 1, 1, 6, 59, 0, 0, time.UTC), time.Date(2050, 1, 1, 7, 0, 0, 0, time.UTC)},
+		{"at", time.Date(2050, 1, 1, 7, 0, 0, 0, time.UTC), time.Date(2050, 1, 2, 7, 0, 0, 0, time.UTC)},
+		{"after", time.Date(2050, 1, 31, 18, 0, 0, 0, time.UTC), time.Date(2050, 2, 1, 7, 0, 0, 0, time.UTC)},
+	}
+	for _, e := range tests {
+		if got := Daily(at)(e.now); !got.Equal(e.want) {
+			t.Errorf("%s: got %s, want %s", e.name, got, e.want)
+		}
+	}
+}
+
+func newTestScheduler(now time.Time) (*Scheduler, *FakeClock) {
+	clock := NewFakeClock(now)
+	s := New(slog.New(slog.NewTextHandler(io.Discard, nil)))
+	s.Clock = clock
+	return s, clock
+}
+
+func TestScheduler_RunsOnSchedule(t *testing.T) {
+	s, clock := newTestScheduler(time.Date(2050, 1, 1, 6, 0, 0, 0, time.UTC))
+	runs := make(chan time.Time, 10)
+	s.Add("morning", Daily(7*time.Hour), func(ctx context.Context, now time.Time) error {
+		runs <- now
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	clock.BlockUntil(1)
+	clock.Advance(59 * time.Minute)
+	select {
+	case now := <-runs:
+		t.Fatalf("ran early at %s", now)
+	default:
+	}
+
+	clock.Advance(time.Minute)
+	if now := <-runs; !now.Equal(time.Date(2050, 1, 1, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("ran at %s", now)
+	}
+	clock.BlockUntil(1)
+	clock.Advance(24 * time.Hour)
+	if now := <-runs; !now.Equal(time.Date(2050, 1, 2, 7, 0, 0, 0, time.UTC)) {
+		t.Errorf("ran the next day at %s", now)
+	}
+}
+
+func TestScheduler_KeepsRunningAfterFailures(t *testing.T) {
+	s, clock := newTestScheduler(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	runs := make(chan int, 10)
+	n := 0
+	s.Add("flaky", Every(time.Hour), func(ctx context.Context, now time.Time) error {
+		n++
+		runs <- n
+		switch n {
+		case 1:
+			return errors.New("some error")
+		case 2:
+			panic("boom")
+		}
+		return nil
+	})
+	s.Start()
+	defer s.Stop()
+
+	for want := 1; want <= 3; want++ {
+		clock.BlockUntil(1)
+		clock.Advance(time.Hour)
+		if got := <-runs; got != want {
+			t.Fatalf("got run %d, want %d", got, want)
+		}
+	}
+}
+
+func TestScheduler_StopWaitsForRunningJob(t *testing.T) {
+	s, clock := newTestScheduler(time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC))
+	started := make(chan struct{})
+	release := make(chan struct{})
+	finished := false
+	s.Add("slow", Every(time.Hour), func(ctx context.Context, now time.Time) error {
+		close(started)
+		<-release
+		finished = true
+		return nil
+	})
+	s.Start()
+	clock.BlockUntil(1)
+	clock.Advance(time.Hour)
+	<-started
+
+	stopped := make(chan struct{})
+	go func() {
+		s.Stop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("stop returned while the job was running")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	<-stopped
+	if !finished {
+		t.Error("the job didn't finish")
+	}
+}
@@ -0,0 +1,134 @@
+package secheaders
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type contextKey string
+
+const nonceContextKey contextKey = "csp_nonce"
+
+// Nonce returns the nonce inline scripts of the request must carry, empty
+// when the middleware didn't run
+func Nonce(ctx context.Context) string {
+	nonce, _ := ctx.Value(nonceContextKey).(string)
+	return nonce
+}
+
+// Config says which security headers to send, the Content-Security-Policy
+// allows scripts from ScriptSources and inline scripts carrying the nonce of
+// the request
+type Config struct {
+	ScriptSources  []string
+	StyleSources   []string
+	FontSources    []string
+	ImageSources   []string
+	ConnectSources []string
+	// FrameAncestors may frame the pages, none when empty
+	FrameAncestors []string
+	// ReportURI receives violation reports, ReportOnly only reports them
+	ReportURI  string
+	ReportOnly bool
+	// HSTS is only sent when set, it must only be turned on behind https
+	HSTS              bool
+	HSTSMaxAge        time.Duration
+	ReferrerPolicy    string
+	PermissionsPolicy string
+}
+
+// Default returns the headers for the pages of the site, with the CDNs the
+// layout loads Bootstrap, the datepicker, notie and SweetAlert from
+func Default() Config {
+	cdns := []string{"https://cdn.jsdelivr.net", "https://code.jquery.com", "https://unpkg.com"}
+	return Config{
+		ScriptSources: cdns,
+		//SweetAlert and notie add inline styles at runtime
+		StyleSources:      append([]string{"'unsafe-inline'"}, cdns...),
+		FontSources:       []string{"data:", "https://cdn.jsdelivr.net"},
+		ImageSources:      []string{"data:"},
+		ReportURI:         "/csp-report",
+		HSTSMaxAge:        365 * 24 * time.Hour,
+		ReferrerPolicy:    "strict-origin-when-cross-origin",
+		PermissionsPolicy: "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+	}
+}
+
+// CSP returns the Content-Security-Policy for a request with the given nonce
+func (c Config) CSP(nonce string) string {
+	directive := func(name string, sources ...string) string {
+		return name + " " + strings.Join(sources, " ")
+	}
+	self := func(sources []string) []string {
+		return append([]string{"'self'"}, sources...)
+	}
+	ancestors := c.FrameAncestors
+	if len(ancestors) == 0 {
+		ancestors = []string{"'none'"}
+	}
+	policy := []string{
+		directive("default-src", "'self'"),
+		directive("script-src", append(self(c.ScriptSources), "'nonce-"+nonce+"'")...),
+		directive("style-src", self(c.StyleSources)...),
+		directive("font-src", self(c.FontSources)...),
+		directive("img-src", self(c.ImageSources)...),
+		directive("connect-src", self(c.ConnectSources)...),
+		directive("object-src", "'none'"),
+		directive("base-uri", "'self'"),
+		directive("form-action", "'self'"),
+		directive("frame-ancestors", ancestors...),
+	}
+	if c.ReportURI != "" {
+		policy = append(policy, directive("report-uri", c.ReportURI))
+	}
+	return strings.Join(policy, "; ")
+}
+
+// Middleware sets the security headers and puts a fresh nonce in the request
+// context for the templates
+func Middleware(c Config) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			nonce, err := newNonce()
+			if err != nil {
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+			h := w.Header()
+			cspHeader := "Content-Security-Policy"
+			if c.ReportOnly {
+				cspHeader = "Content-Security-Policy-Report-Only"
+			}
+			h.Set(cspHeader, c.CSP(nonce))
+			if c.HSTS {
+				h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", int(c.HSTSMaxAge.Seconds())))
+			}
+			h.Set("X-Content-Type-Options", "nosniff")
+			if len(c.FrameAncestors) == 0 {
+				//for browsers without frame-ancestors
+				h.Set("X-Frame-Options", "DENY")
+			}
+			if c.ReferrerPolicy != "" {
+				h.Set("Referrer-Policy", c.ReferrerPolicy)
+			}
+			if c.PermissionsPolicy != "" {
+				h.Set("Permissions-Policy", c.PermissionsPolicy)
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), nonceContextKey, nonce)))
+		})
+	}
+}
+
+// newNonce returns 128 random bits, base64 encoded
+func newNonce() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
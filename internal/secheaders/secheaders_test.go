@@ -0,0 +1,71 @@
+package secheaders
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serve(c Config) (*httptest.ResponseRecorder, string) {
+	var nonce string
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce = Nonce(r.Context())
+	})
+	rr := httptest.NewRecorder()
+	Middleware(c)(next).ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	return rr, nonce
+}
+
+func TestMiddleware(t *testing.T) {
+	rr, nonce := serve(Default())
+
+	if nonce == "" {
+		t.Fatal("no nonce in the request context")
+	}
+	csp := rr.Header().Get("Content-Security-Policy")
+	for _, want := range []string{"'nonce-" + nonce + "'", "script-src 'self' https://cdn.jsdelivr.net", "frame-ancestors 'none'", "report-uri /csp-report", "object-src 'none'"} {
+		if !strings.Contains(csp, want) {
+			t.Errorf("expected %q in the policy %q", want, csp)
+		}
+	}
+	for header, want := range map[string]string{
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Strict-Transport-Security": "",
+	} {
+		if got := rr.Header().Get(header); got != want {
+			t.Errorf("expected %s %q but got %q", header, want, got)
+		}
+	}
+	if rr.Header().Get("Permissions-Policy") == "" {
+		t.Error("no Permissions-Policy")
+	}
+
+	if _, again := serve(Default()); again == nonce {
+		t.Error("the nonce was reused for the next request")
+	}
+}
+
+func TestMiddlewareOptions(t *testing.T) {
+	c := Default()
+	c.HSTS = true
+	c.ReportOnly = true
+	c.FrameAncestors = []string{"https://partner.example.com"}
+	rr, _ := serve(c)
+
+	if got := rr.Header().Get("Strict-Transport-Security"); got != "max-age=31536000; includeSubDomains" {
+		t.Errorf("wrong Strict-Transport-Security %q", got)
+	}
+	if rr.Header().Get("Content-Security-Policy") != "" {
+		t.Error("the policy is enforced in report only mode")
+	}
+	csp := rr.Header().Get("Content-Security-Policy-Report-Only")
+	if !strings.Contains(csp, "frame-ancestors https://partner.example.com") {
+		t.Errorf("frame ancestors missing from %q", csp)
+	}
+	if rr.Header().Get("X-Frame-Options") != "" {
+		t.Error("X-Frame-Options would block the allowed frame ancestors")
+	}
+}
@@ -0,0 +1,107 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/alexedwards/scs/v2/memstore"
+)
+
+// Kinds of session store that can be configured
+const (
+	Memory   = "memory"
+	Postgres = "postgres"
+)
+
+// SQLStore keeps sessions in the sessions table, so they survive restarts and
+// are shared between instances. The table matches the one of scs' own
+// postgresstore
+type SQLStore struct {
+	db     *sql.DB
+	logger *slog.Logger
+	stop   chan struct{}
+}
+
+// New returns a store using db, expired sessions are deleted every
+// cleanupInterval unless it is 0
+func New(db *sql.DB, cleanupInterval time.Duration, logger *slog.Logger) *SQLStore {
+	s := &SQLStore{db: db, logger: logger}
+	if cleanupInterval > 0 {
+		s.stop = make(chan struct{})
+		go s.cleanup(cleanupInterval)
+	}
+	return s
+}
+
+// Open returns the store of the given kind, the postgres one keeps sessions
+// in the database of db
+func Open(kind string, db *sql.DB, cleanupInterval time.Duration, logger *slog.Logger) (scs.Store, error) {
+	switch kind {
+	case "", Memory:
+		return memstore.NewWithCleanupInterval(cleanupInterval), nil
+	case Postgres:
+		if db == nil {
+			return nil, errors.New("the sessions table needs a database connection")
+		}
+		return New(db, cleanupInterval, logger), nil
+	}
+	return nil, fmt.Errorf("unknown session store %q, use %s or %s", kind, Memory, Postgres)
+}
+
+// Find returns the data of an unexpired session
+func (s *SQLStore) Find(token string) ([]byte, bool, error) {
+	var b []byte
+	row := s.db.QueryRow(`select data from sessions where token = $1 and expiry > $2`, token, time.Now().UTC())
+	err := row.Scan(&b)
+	if err == sql.ErrNoRows {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Commit adds or replaces a session
+func (s *SQLStore) Commit(token string, b []byte, expiry time.Time) error {
+	_, err := s.db.Exec(`insert into sessions (token, data, expiry) values ($1, $2, $3)
+		on conflict (token) do update set data = excluded.data, expiry = excluded.expiry`,
+		token, b, expiry.UTC())
+	return err
+}
+
+// Delete removes a session
+func (s *SQLStore) Delete(token string) error {
+	_, err := s.db.Exec(`delete from sessions where token = $1`, token)
+	return err
+}
+
+// StopCleanup stops the background cleanup
+func (s *SQLStore) StopCleanup() {
+	if s.stop != nil {
+		close(s.stop)
+	}
+}
+
+func (s *SQLStore) cleanup(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.deleteExpired(); err != nil && s.logger != nil {
+				s.logger.Error("can't delete expired sessions", "err", err)
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *SQLStore) deleteExpired() error {
+	_, err := s.db.Exec(`delete from sessions where expiry < $1`, time.Now().UTC())
+	return err
+}
@@ -0,0 +1,130 @@
+package sessionstore
+
+import (
+	"database/sql"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/driver"
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+	"github.com/alexedwards/scs/v2"
+)
+
+func TestOpen(t *testing.T) {
+	db := &sql.DB{}
+	var tests = []struct {
+		name  string
+		kind  string
+		db    *sql.DB
+		isErr bool
+	}{
+		{"default", "", nil, false},
+		{"memory", Memory, nil, false},
+		{"postgres", Postgres, db, false},
+		{"postgres-no-db", Postgres, nil, true},
+		{"sqlite", "sqlite", db, true},
+		{"unknown", "redis", db, true},
+	}
+	for _, e := range tests {
+		store, err := Open(e.kind, e.db, 0, nil)
+		if e.isErr && err == nil {
+			t.Errorf("%s: expected an error", e.name)
+		}
+		if !e.isErr && (err != nil || store == nil) {
+			t.Errorf("%s: unexpected error %v", e.name, err)
+		}
+		if s, ok := store.(interface{ StopCleanup() }); ok {
+			s.StopCleanup()
+		}
+	}
+}
+
+// testStore returns a SQLStore on the development database, skipping the
+// test when postgres is not running. The connection pool holds a single
+// connection so the temporary sessions table hides the real one
+func testStore(t *testing.T) *SQLStore {
+	t.Helper()
+	db, err := driver.NewDatabase(driver.DSN())
+	if err != nil {
+		t.Skip("postgres is not running:", err)
+	}
+	db.SetMaxOpenConns(1)
+	t.Cleanup(func() { db.Close() })
+	_, err = db.Exec(`create temporary table sessions (token text primary key, data bytea not null, expiry timestamptz not null)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(db, 0, nil)
+}
+
+func TestSQLStore(t *testing.T) {
+	store := testStore(t)
+
+	if _, found, err := store.Find("missing"); found || err != nil {
+		t.Errorf("found a missing session: %v, %v", found, err)
+	}
+	if err := store.Commit("a", []byte("one"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Commit("a", []byte("two"), time.Now().Add(time.Hour)); err != nil {
+		t.Fatal(err)
+	}
+	if b, found, err := store.Find("a"); !found || err != nil || string(b) != "two" {
+		t.Errorf("got %q, %v, %v, want the replaced session", b, found, err)
+	}
+
+	if err := store.Commit("old", []byte("x"), time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := store.Find("old"); found {
+		t.Error("found an expired session")
+	}
+	if err := store.deleteExpired(); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := store.db.QueryRow(`select count(*) from sessions`).Scan(&n); err != nil || n != 1 {
+		t.Errorf("got %d sessions after the cleanup, %v", n, err)
+	}
+
+	if err := store.Delete("a"); err != nil {
+		t.Fatal(err)
+	}
+	if _, found, _ := store.Find("a"); found {
+		t.Error("found a deleted session")
+	}
+}
+
+// a reservation in the session must be readable by a new session manager on the same store, as after a restart
+func TestReservationSurvivesRestart(t *testing.T) {
+	gob.Register(models.Reservation{})
+	store := testStore(t)
+
+	first := scs.New()
+	first.Store = store
+	var cookie *http.Cookie
+	put := first.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		first.Put(r.Context(), "reservation", models.Reservation{FirstName: "John", RoomID: 1, StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)})
+	}))
+	rr := httptest.NewRecorder()
+	put.ServeHTTP(rr, httptest.NewRequest("GET", "/", nil))
+	cookie = rr.Result().Cookies()[0]
+
+	second := scs.New()
+	second.Store = store
+	var got models.Reservation
+	var ok bool
+	get := second.LoadAndSave(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = second.Get(r.Context(), "reservation").(models.Reservation)
+	}))
+	req := httptest.NewRequest("GET", "/", nil)
+	req.AddCookie(cookie)
+	get.ServeHTTP(httptest.NewRecorder(), req)
+
+	if !ok || got.FirstName != "John" || got.RoomID != 1 {
+		t.Errorf("reservation not restored from the store, got %+v", got)
+	}
+}
@@ -0,0 +1,75 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// the SHA1 test vectors of RFC 6238 appendix B
+func TestHOTP_RFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	var tests = []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, e := range tests {
+		got := hotp(key, uint64(e.unix/Period), 8)
+		if got != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, got)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tests = []struct {
+		name     string
+		code     string
+		at       time.Time
+		expected bool
+	}{
+		{"now", code, now, true},
+		{"with spaces", code[:3] + " " + code[3:], now, true},
+		{"one step late", code, now.Add(Period * time.Second), true},
+		{"two steps late", code, now.Add(2 * Period * time.Second), false},
+		{"wrong code", "000000", now, code == "000000"},
+		{"too short", code[:5], now, false},
+	}
+
+	for _, e := range tests {
+		counter, ok := Validate(secret, e.code, e.at)
+		if ok != e.expected {
+			t.Errorf("%s: expected %v but got %v", e.name, e.expected, ok)
+		}
+		if ok && counter != Counter(now) {
+			t.Errorf("%s: expected step %d but got %d", e.name, Counter(now), counter)
+		}
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Bookings", "me@here.com", "JBSWY3DPEHPK3PXP")
+	for _, part := range []string{"otpauth://totp/Bookings:me@here.com?", "secret=JBSWY3DPEHPK3PXP", "issuer=Bookings", "digits=6", "period=30"} {
+		if !strings.Contains(uri, part) {
+			t.Errorf("expected %q in %s", part, uri)
+		}
+	}
+}
@@ -0,0 +1,289 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// Headers sent with every delivery
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Store is the part of the database repository the dispatcher needs
+type Store interface {
+	WebhookEndpointsForEvent(ctx context.Context, event string) ([]models.WebhookEndpoint, error)
+	InsertWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int, error)
+	UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error
+	GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error)
+	PendingWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error)
+}
+
+// Payload is the JSON body posted to the endpoints
+type Payload struct {
+	Event     string      `json:"event"`
+	CreatedAt time.Time   `json:"created_at"`
+	Data      interface{} `json:"data"`
+}
+
+// Dispatcher signs and posts event payloads in the background, retrying
+// failed deliveries with an exponential backoff
+type Dispatcher struct {
+	Store       Store
+	Client      *http.Client
+	Logger      *slog.Logger
+	MaxAttempts int
+	// Backoff returns how long to wait before the given retry
+	Backoff func(attempt int) time.Duration
+
+	queue chan int
+	wg    sync.WaitGroup
+	mu    sync.Mutex
+	timer map[int]*time.Timer
+	done  chan struct{}
+}
+
+// New returns a dispatcher with sensible defaults, call Start to begin delivering
+func New(store Store, logger *slog.Logger) *Dispatcher {
+	return &Dispatcher{
+		Store: store,
+		Client: &http.Client{
+			Timeout: 10 * time.Second,
+			//a redirect would send the signed payload to a url nobody
+			//registered, it fails the delivery instead
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		Logger:      logger,
+		MaxAttempts: 5,
+		Backoff: func(attempt int) time.Duration {
+			return time.Duration(1<<uint(attempt)) * 30 * time.Second
+		},
+		queue: make(chan int, 100),
+		timer: map[int]*time.Timer{},
+		done:  make(chan struct{}),
+	}
+}
+
+// Start launches the delivery workers and queues deliveries left pending
+// by a previous run
+func (d *Dispatcher) Start(workers int) {
+	for i := 0; i < workers; i++ {
+		d.wg.Add(1)
+		go d.work()
+	}
+	pending, err := d.Store.PendingWebhookDeliveries(context.Background())
+	if err != nil {
+		d.Logger.Error("can't load pending webhook deliveries", "err", err)
+		return
+	}
+	for _, p := range pending {
+		d.enqueue(p.ID)
+	}
+}
+
+// Stop stops the workers and waits for in flight deliveries to finish
+func (d *Dispatcher) Stop() {
+	d.mu.Lock()
+	for id, t := range d.timer {
+		t.Stop()
+		delete(d.timer, id)
+	}
+	d.mu.Unlock()
+	close(d.done)
+	d.wg.Wait()
+}
+
+// Publish records a delivery for every active endpoint subscribed to event and queues them
+func (d *Dispatcher) Publish(ctx context.Context, event string, data interface{}) error {
+	endpoints, err := d.Store.WebhookEndpointsForEvent(ctx, event)
+	if err != nil {
+		return err
+	}
+	if len(endpoints) == 0 {
+		return nil
+	}
+	body, err := json.Marshal(Payload{Event: event, CreatedAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return err
+	}
+	for _, e := range endpoints {
+		id, err := d.Store.InsertWebhookDelivery(ctx, models.WebhookDelivery{
+			WebhookEndpointID: e.ID,
+			Event:             event,
+			Payload:           string(body),
+			Status:            models.DeliveryPending,
+		})
+		if err != nil {
+			return err
+		}
+		d.enqueue(id)
+	}
+	return nil
+}
+
+// Replay queues a delivery again with a fresh set of attempts
+func (d *Dispatcher) Replay(ctx context.Context, id int) error {
+	delivery, err := d.Store.GetWebhookDeliveryByID(ctx, id)
+	if err != nil {
+		return err
+	}
+	if delivery.Status == models.DeliveryPending {
+		return errors.New("delivery is still pending")
+	}
+	delivery.Status = models.DeliveryPending
+	delivery.Attempts = 0
+	if err := d.Store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		return err
+	}
+	d.enqueue(id)
+	return nil
+}
+
+// enqueue hands a delivery to the workers without blocking the caller
+func (d *Dispatcher) enqueue(id int) {
+	select {
+	case d.queue <- id:
+	default:
+		go func() {
+			select {
+			case d.queue <- id:
+			case <-d.done:
+			}
+		}()
+	}
+}
+
+func (d *Dispatcher) work() {
+	defer d.wg.Done()
+	for {
+		select {
+		case id := <-d.queue:
+			d.deliver(id)
+		case <-d.done:
+			return
+		}
+	}
+}
+
+// deliver makes one attempt and schedules a retry if it failed
+func (d *Dispatcher) deliver(id int) {
+	ctx := context.Background()
+	delivery, err := d.Store.GetWebhookDeliveryByID(ctx, id)
+	if err != nil {
+		d.Logger.Error("can't load webhook delivery", "delivery_id", id, "err", err)
+		return
+	}
+	if delivery.Status != models.DeliveryPending {
+		return
+	}
+
+	delivery.Attempts++
+	delivery.ResponseCode, err = d.post(delivery)
+	if err == nil {
+		delivery.Status = models.DeliverySucceeded
+		delivery.LastError = ""
+		delivery.DeliveredAt = time.Now()
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts >= d.MaxAttempts {
+			delivery.Status = models.DeliveryFailed
+		}
+	}
+	if err := d.Store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		d.Logger.Error("can't update webhook delivery", "delivery_id", id, "err", err)
+		return
+	}
+
+	if delivery.Status == models.DeliveryPending {
+		d.retryLater(id, d.Backoff(delivery.Attempts))
+	}
+}
+
+func (d *Dispatcher) retryLater(id int, wait time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	select {
+	case <-d.done:
+		return
+	default:
+	}
+	d.timer[id] = time.AfterFunc(wait, func() {
+		d.mu.Lock()
+		delete(d.timer, id)
+		d.mu.Unlock()
+		d.enqueue(id)
+	})
+}
+
+// post sends the signed payload and returns the response code
+func (d *Dispatcher) post(delivery models.WebhookDelivery) (int, error) {
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req, err := http.NewRequest("POST", delivery.Endpoint.URL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, delivery.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(delivery.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Sign(delivery.Endpoint.Secret, timestamp, []byte(delivery.Payload)))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode >= 300 && resp.StatusCode <= 399 {
+		return resp.StatusCode, fmt.Errorf("endpoint redirected to %q, redirects are not followed", resp.Header.Get("Location"))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("endpoint returned %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the signature header value for a payload, the HMAC-SHA256 of
+// "timestamp.body" keyed with the endpoint secret
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks a signature header in constant time, receivers can use it
+// to authenticate deliveries
+func Verify(secret, timestamp string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, timestamp, body)), []byte(signature))
+}
+
+// NewSecret returns a random secret for a new endpoint
+func NewSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(b), nil
+}
@@ -0,0 +1,245 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/acceleraterA/go_app_udemy/internal/models"
+)
+
+// memoryStore keeps endpoints and deliveries in memory
+type memoryStore struct {
+	mu         sync.Mutex
+	endpoints  []models.WebhookEndpoint
+	deliveries map[int]models.WebhookDelivery
+	changed    chan models.WebhookDelivery
+}
+
+func newMemoryStore(endpoints ...models.WebhookEndpoint) *memoryStore {
+	return &memoryStore{
+		endpoints:  endpoints,
+		deliveries: map[int]models.WebhookDelivery{},
+		changed:    make(chan models.WebhookDelivery, 100),
+	}
+}
+
+func (s *memoryStore) WebhookEndpointsForEvent(ctx context.Context, event string) ([]models.WebhookEndpoint, error) {
+	var out []models.WebhookEndpoint
+	for _, e := range s.endpoints {
+		if e.Active && e.Subscribed(event) {
+			out = append(out, e)
+		}
+	}
+	return out, nil
+}
+
+func (s *memoryStore) InsertWebhookDelivery(ctx context.Context, d models.WebhookDelivery) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d.ID = len(s.deliveries) + 1
+	s.deliveries[d.ID] = d
+	return d.ID, nil
+}
+
+func (s *memoryStore) UpdateWebhookDelivery(ctx context.Context, d models.WebhookDelivery) error {
+	s.mu.Lock()
+	s.deliveries[d.ID] = d
+	s.mu.Unlock()
+	s.changed <- d
+	return nil
+}
+
+func (s *memoryStore) GetWebhookDeliveryByID(ctx context.Context, id int) (models.WebhookDelivery, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	d, ok := s.deliveries[id]
+	if !ok {
+		return d, errors.New("no such delivery")
+	}
+	for _, e := range s.endpoints {
+		if e.ID == d.WebhookEndpointID {
+			d.Endpoint = e
+		}
+	}
+	return d, nil
+}
+
+func (s *memoryStore) PendingWebhookDeliveries(ctx context.Context) ([]models.WebhookDelivery, error) {
+	return nil, nil
+}
+
+// waitFor returns the first update of a delivery with the given status
+func (s *memoryStore) waitFor(t *testing.T, status string) models.WebhookDelivery {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case d := <-s.changed:
+			if d.Status == status {
+				return d
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for a %s delivery", status)
+		}
+	}
+}
+
+func newTestDispatcher(store Store) *Dispatcher {
+	d := New(store, slog.New(slog.NewTextHandler(os.Stdout, nil)))
+	d.MaxAttempts = 3
+	d.Backoff = func(attempt int) time.Duration { return time.Millisecond }
+	return d
+}
+
+func TestDispatcher_Publish(t *testing.T) {
+	type received struct {
+		header http.Header
+		body   []byte
+	}
+	got := make(chan received, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		got <- received{r.Header, body}
+	}))
+	defer ts.Close()
+
+	store := newMemoryStore(
+		models.WebhookEndpoint{ID: 1, URL: ts.URL, Secret: "secret", Events: []string{models.EventReservationCreated}, Active: true},
+		models.WebhookEndpoint{ID: 2, URL: ts.URL, Secret: "other", Events: []string{models.EventReservationCancelled}, Active: true},
+	)
+	d := newTestDispatcher(store)
+	d.Start(1)
+	defer d.Stop()
+
+	err := d.Publish(context.Background(), models.EventReservationCreated, map[string]int{"id": 7})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delivery := store.waitFor(t, models.DeliverySucceeded)
+	if delivery.WebhookEndpointID != 1 || delivery.Attempts != 1 || delivery.ResponseCode != http.StatusOK {
+		t.Errorf("unexpected delivery %+v", delivery)
+	}
+
+	r := <-got
+	if r.header.Get(HeaderEvent) != models.EventReservationCreated {
+		t.Errorf("expected event header %s, got %s", models.EventReservationCreated, r.header.Get(HeaderEvent))
+	}
+	if !Verify("secret", r.header.Get(HeaderTimestamp), r.body, r.header.Get(HeaderSignature)) {
+		t.Error("signature does not verify with the endpoint secret")
+	}
+	if Verify("other", r.header.Get(HeaderTimestamp), r.body, r.header.Get(HeaderSignature)) {
+		t.Error("signature verifies with the wrong secret")
+	}
+	var p struct {
+		Event string         `json:"event"`
+		Data  map[string]int `json:"data"`
+	}
+	if err := json.Unmarshal(r.body, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p.Event != models.EventReservationCreated || p.Data["id"] != 7 {
+		t.Errorf("unexpected payload %s", r.body)
+	}
+}
+
+func TestDispatcher_Retry(t *testing.T) {
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer ts.Close()
+
+	store := newMemoryStore(models.WebhookEndpoint{ID: 1, URL: ts.URL, Secret: "s", Events: models.WebhookEvents, Active: true})
+	d := newTestDispatcher(store)
+	d.Start(1)
+	defer d.Stop()
+
+	if err := d.Publish(context.Background(), models.EventReservationModified, nil); err != nil {
+		t.Fatal(err)
+	}
+	delivery := store.waitFor(t, models.DeliverySucceeded)
+	if delivery.Attempts != 3 {
+		t.Errorf("expected success on the third attempt, got %d attempts", delivery.Attempts)
+	}
+}
+
+func TestDispatcher_FailAndReplay(t *testing.T) {
+	var healthy int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer ts.Close()
+
+	store := newMemoryStore(models.WebhookEndpoint{ID: 1, URL: ts.URL, Secret: "s", Events: models.WebhookEvents, Active: true})
+	d := newTestDispatcher(store)
+	d.Start(1)
+	defer d.Stop()
+
+	if err := d.Publish(context.Background(), models.EventReservationCancelled, nil); err != nil {
+		t.Fatal(err)
+	}
+	failed := store.waitFor(t, models.DeliveryFailed)
+	if failed.Attempts != 3 || failed.ResponseCode != http.StatusBadGateway || failed.LastError == "" {
+		t.Errorf("unexpected failed delivery %+v", failed)
+	}
+
+	atomic.StoreInt32(&healthy, 1)
+	if err := d.Replay(context.Background(), failed.ID); err != nil {
+		t.Fatal(err)
+	}
+	replayed := store.waitFor(t, models.DeliverySucceeded)
+	if replayed.ID != failed.ID || replayed.Attempts != 1 {
+		t.Errorf("unexpected replayed delivery %+v", replayed)
+	}
+}
+
+func TestDispatcher_RedirectFails(t *testing.T) {
+	var followed int32
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&followed, 1)
+	}))
+	defer target.Close()
+	ts := httptest.NewServer(http.RedirectHandler(target.URL, http.StatusTemporaryRedirect))
+	defer ts.Close()
+
+	store := newMemoryStore(models.WebhookEndpoint{ID: 1, URL: ts.URL, Secret: "s", Events: models.WebhookEvents, Active: true})
+	d := newTestDispatcher(store)
+	d.Start(1)
+	defer d.Stop()
+
+	if err := d.Publish(context.Background(), models.EventReservationCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	failed := store.waitFor(t, models.DeliveryFailed)
+	if failed.ResponseCode != http.StatusTemporaryRedirect || failed.LastError == "" {
+		t.Errorf("unexpected failed delivery %+v", failed)
+	}
+	if n := atomic.LoadInt32(&followed); n != 0 {
+		t.Errorf("the redirect was followed %d times", n)
+	}
+}
+
+func TestDispatcher_PublishWithoutSubscribers(t *testing.T) {
+	store := newMemoryStore(models.WebhookEndpoint{ID: 1, URL: "http://127.0.0.1:1", Events: models.WebhookEvents, Active: false})
+	d := newTestDispatcher(store)
+	if err := d.Publish(context.Background(), models.EventReservationCreated, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.deliveries) != 0 {
+		t.Error("created a delivery for an inactive endpoint")
+	}
+}
@@ -0,0 +1,17 @@
+// Package migrations embeds the fizz migrations and the seed data, so the
+// migrate command doesn't need the files next to it
+package migrations
+
+import "embed"
+
+// FS holds the *.up.fizz and *.down.fizz migrations and soda's raw sql
+// migrations for postgres
+//
+//go:embed *.fizz *.postgres.up.sql *.postgres.down.sql
+var FS embed.FS
+
+// Seed inserts the rooms and restrictions the site can't work without, it
+// can be run more than once
+//
+//go:embed seed.sql
+var Seed string
@@ -0,0 +1,14 @@
+-- rooms and restriction types the site expects, existing rows are kept
+INSERT INTO rooms (id, room_name, created_at, updated_at) VALUES
+    (1, 'General''s Quarters', now(), now()),
+    (2, 'Major''s Suite', now(), now())
+ON CONFLICT (id) DO NOTHING;
+
+INSERT INTO restrictions (id, restriction_name, created_at, updated_at) VALUES
+    (1, 'Reservation', now(), now()),
+    (2, 'Owner Block', now(), now())
+ON CONFLICT (id) DO NOTHING;
+
+-- serial sequences don't move when ids are inserted explicitly
+SELECT setval(pg_get_serial_sequence('rooms', 'id'), (SELECT max(id) FROM rooms));
+SELECT setval(pg_get_serial_sequence('restrictions', 'id'), (SELECT max(id) FROM restrictions));